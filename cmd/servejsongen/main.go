@@ -0,0 +1,675 @@
+/*
+servejsongen generates code serving GET, POST, PUT and DELETE requests on Go
+structs without reflection, implementing serveJSON.Dispatcher for each type
+named with -type.  It's meant to be run by go generate in the package declaring
+the types:
+
+  //go:generate go run github.com/donniet/mirror.3/cmd/servejsongen -type Mirror
+
+The generated code follows paths through struct fields by their JSON names,
+slices by index and maps with string keys, and reads and writes everything
+else with encoding/json, so it serves these exactly as serveJSON.ServeJSON
+does.  Nodes whose behaviour depends on more than that, such as types with
+validators, access or key tags, computed values, custom marshalling,
+interfaces or arrays, return serveJSON.ErrNotGenerated and are served by
+reflection as before.
+*/
+package main
+
+import (
+  "bytes"
+  "flag"
+  "fmt"
+  "go/ast"
+  "go/format"
+  "go/parser"
+  "go/token"
+  "io/ioutil"
+  "log"
+  "os"
+  "reflect"
+  "sort"
+  "strconv"
+  "strings"
+)
+
+const serveJSONPath = "github.com/donniet/mirror.3/serveJSON"
+
+var (
+  typeNames = flag.String("type", "", "comma separated list of type names; required")
+  output = flag.String("output", "", "output file name; defaults to <type>_dispatch.go")
+  tests = flag.Bool("test", false, "also read _test.go files, for types declared in tests")
+)
+
+func main() {
+  log.SetFlags(0)
+  log.SetPrefix("servejsongen: ")
+  flag.Parse()
+
+  if *typeNames == "" {
+    flag.Usage()
+    os.Exit(2)
+  }
+  names := strings.Split(*typeNames, ",")
+
+  dir := "."
+  if args := flag.Args(); len(args) > 0 {
+    dir = args[0]
+  }
+
+  g, err := load(dir, *tests)
+  if err != nil {
+    log.Fatal(err)
+  }
+
+  src, err := g.generate(names)
+  if err != nil {
+    log.Fatal(err)
+  }
+
+  name := *output
+  if name == "" {
+    name = strings.ToLower(names[0]) + "_dispatch.go"
+  }
+  if err := ioutil.WriteFile(name, src, 0644); err != nil {
+    log.Fatal(err)
+  }
+}
+
+type kind int
+
+const (
+  unsupported kind = iota
+  leaf
+  structure
+  slice
+  mapping
+  pointer
+)
+
+/*
+node is a type reachable from one of the generated types.  read is whether
+encoding/json marshals it as serveJSON.Marshal would, and deep whether
+encoding/json also unmarshals it as ServeJSON would, with nothing to validate.
+*/
+type node struct {
+  expr string
+  name string
+  kind kind
+  // how serveJSON names the kind in errors
+  reflectKind string
+  elem *node
+  fields []*field
+  // whether mutations may pass through the node itself
+  plain bool
+  // the struct has a field tagged as its key
+  keyed bool
+  // the struct has fields encoding/json doesn't copy
+  lossy bool
+  // the type refers to package time
+  time bool
+
+  read, deep, visiting bool
+  checked bool
+}
+
+type field struct {
+  name string
+  goName string
+  node *node
+  tagged bool
+}
+
+type generator struct {
+  pkg string
+  qualifier string
+  specs map[string]*ast.TypeSpec
+  methods map[string]map[string]bool
+  nodes map[string]*node
+  order []*node
+  // whether the generated code refers to package time
+  time bool
+}
+
+// load parses the package in dir
+func load(dir string, tests bool) (*generator, error) {
+  fset := token.NewFileSet()
+  pkgs, err := parser.ParseDir(fset, dir, func(info os.FileInfo) bool {
+    return tests || !strings.HasSuffix(info.Name(), "_test.go")
+  }, 0)
+  if err != nil {
+    return nil, err
+  }
+
+  g := &generator{
+    specs: make(map[string]*ast.TypeSpec),
+    methods: make(map[string]map[string]bool),
+    nodes: make(map[string]*node),
+  }
+
+  for name, pkg := range pkgs {
+    if strings.HasSuffix(name, "_test") {
+      continue
+    }
+    if g.pkg != "" {
+      return nil, fmt.Errorf("multiple packages in %s", dir)
+    }
+    g.pkg = name
+
+    for _, file := range pkg.Files {
+      for _, decl := range file.Decls {
+        switch d := decl.(type) {
+        case *ast.GenDecl:
+          for _, spec := range d.Specs {
+            if ts, ok := spec.(*ast.TypeSpec); ok {
+              g.specs[ts.Name.Name] = ts
+            }
+          }
+        case *ast.FuncDecl:
+          if d.Recv == nil || len(d.Recv.List) == 0 {
+            continue
+          }
+          recv := d.Recv.List[0].Type
+          if star, ok := recv.(*ast.StarExpr); ok {
+            recv = star.X
+          }
+          if id, ok := recv.(*ast.Ident); ok {
+            if g.methods[id.Name] == nil {
+              g.methods[id.Name] = make(map[string]bool)
+            }
+            g.methods[id.Name][d.Name.Name] = true
+          }
+        }
+      }
+    }
+  }
+
+  if g.pkg == "" {
+    return nil, fmt.Errorf("no package in %s", dir)
+  }
+  if g.pkg != "serveJSON" {
+    g.qualifier = "server."
+  }
+  return g, nil
+}
+
+var basic = map[string]string{
+  "bool": "bool", "string": "string",
+  "int": "int", "int8": "int8", "int16": "int16", "int32": "int32", "int64": "int64",
+  "uint": "uint", "uint8": "uint8", "uint16": "uint16", "uint32": "uint32", "uint64": "uint64",
+  "uintptr": "uintptr", "byte": "uint8", "rune": "int32",
+  "float32": "float32", "float64": "float64",
+}
+
+// methods that change how a type is served
+var custom = []string{"MarshalJSON", "UnmarshalJSON", "MarshalText", "UnmarshalText", "Get", "Set"}
+
+func (g *generator) has(name string, methods ...string) bool {
+  for _, m := range methods {
+    if g.methods[name][m] {
+      return true
+    }
+  }
+  return false
+}
+
+// node returns the node for the type expression e
+func (g *generator) node(e ast.Expr) *node {
+  expr := exprString(e)
+  if n, ok := g.nodes[expr]; ok {
+    return n
+  }
+
+  n := &node{expr: expr, name: mangle(e), plain: true}
+  g.nodes[expr] = n
+  g.order = append(g.order, n)
+
+  switch t := e.(type) {
+  case *ast.Ident:
+    if k, ok := basic[t.Name]; ok {
+      n.kind, n.reflectKind = leaf, k
+      break
+    }
+    spec, ok := g.specs[t.Name]
+    if !ok || spec.TypeParams != nil || g.has(t.Name, custom...) {
+      break
+    }
+    n.plain = !g.has(t.Name, "Validate")
+
+    switch u := spec.Type.(type) {
+    case *ast.StructType:
+      n.kind, n.reflectKind = structure, "struct"
+      g.structFields(n, u)
+    case *ast.Ident:
+      // named basic types are read and written whole
+      if k, ok := basic[u.Name]; ok {
+        n.kind, n.reflectKind = leaf, k
+      }
+    }
+  case *ast.SelectorExpr:
+    switch expr {
+    case "time.Time":
+      n.kind, n.reflectKind = leaf, "struct"
+      n.time = true
+    case "time.Duration":
+      n.kind, n.reflectKind = leaf, "int64"
+      n.time = true
+    }
+  case *ast.StarExpr:
+    n.kind, n.reflectKind = pointer, "ptr"
+    n.elem = g.node(t.X)
+    n.time = n.elem.time
+  case *ast.ArrayType:
+    if t.Len != nil {
+      break
+    }
+    elem := g.node(t.Elt)
+    if keyed(elem) {
+      // keyed elements are found by value rather than index
+      break
+    }
+    n.kind, n.reflectKind = slice, "slice"
+    n.elem = elem
+    n.time = elem.time
+  case *ast.MapType:
+    if id, ok := t.Key.(*ast.Ident); !ok || id.Name != "string" {
+      break
+    }
+    n.kind, n.reflectKind = mapping, "map"
+    n.elem = g.node(t.Value)
+    n.time = n.elem.time
+  }
+  return n
+}
+
+func keyed(n *node) bool {
+  for n.kind == pointer {
+    n = n.elem
+  }
+  return n.kind == structure && n.keyed
+}
+
+func (g *generator) structFields(n *node, s *ast.StructType) {
+  for _, f := range s.Fields.List {
+    if len(f.Names) == 0 {
+      // embedded fields are promoted, which the generated code doesn't follow
+      n.kind = unsupported
+      return
+    }
+
+    tag := reflect.StructTag("")
+    if f.Tag != nil {
+      if s, err := strconv.Unquote(f.Tag.Value); err == nil {
+        tag = reflect.StructTag(s)
+      }
+    }
+    serve := strings.Split(tag.Get("serve"), ",")
+    tagged := false
+    for _, s := range serve {
+      switch s {
+      case "readonly", "writeonly", "hidden":
+        tagged = true
+      case "key":
+        n.keyed = true
+      }
+    }
+
+    for _, name := range f.Names {
+      if !name.IsExported() {
+        n.lossy = true
+        continue
+      }
+
+      jsonName := name.Name
+      if j := strings.Split(tag.Get("json"), ",")[0]; j == "-" {
+        n.lossy = true
+        continue
+      } else if j != "" {
+        jsonName = j
+      }
+
+      n.fields = append(n.fields, &field{
+        name: jsonName,
+        goName: name.Name,
+        node: g.node(f.Type),
+        tagged: tagged,
+      })
+    }
+  }
+}
+
+// check works out whether n and everything beneath it is read and written plainly
+func (n *node) check() {
+  if n.checked || n.visiting {
+    return
+  }
+  n.visiting = true
+  defer func() { n.visiting, n.checked = false, true }()
+
+  switch n.kind {
+  case unsupported:
+    n.read, n.deep = false, false
+  case leaf:
+    n.read, n.deep = true, n.plain
+  case structure:
+    n.read, n.deep = true, n.plain && !n.lossy
+    for _, f := range n.fields {
+      f.node.check()
+      if f.tagged || !f.node.read && !f.node.visiting {
+        n.read = false
+      }
+      if f.tagged || !f.node.deep && !f.node.visiting {
+        n.deep = false
+      }
+    }
+    n.deep = n.deep && n.read
+  default:
+    n.elem.check()
+    n.read = n.elem.read || n.elem.visiting
+    n.deep = n.read && (n.elem.deep || n.elem.visiting)
+  }
+}
+
+func (g *generator) generate(names []string) ([]byte, error) {
+  var roots []*node
+  for _, name := range names {
+    name = strings.TrimSpace(name)
+    spec, ok := g.specs[name]
+    if !ok {
+      return nil, fmt.Errorf("type %s not found in package %s", name, g.pkg)
+    }
+    if _, ok := spec.Type.(*ast.StructType); !ok {
+      return nil, fmt.Errorf("type %s is not a struct", name)
+    }
+    roots = append(roots, g.node(ast.NewIdent(name)))
+  }
+  for _, n := range g.order {
+    n.check()
+  }
+
+  // only the functions the generated code calls are written
+  reached := make(map[*node]bool)
+  for _, r := range roots {
+    reach(r, reached)
+  }
+
+  var funcs bytes.Buffer
+  nodes := append([]*node{}, g.order...)
+  sort.SliceStable(nodes, func(i, j int) bool { return nodes[i].name < nodes[j].name })
+  for _, n := range nodes {
+    if reached[n] {
+      g.function(&funcs, n)
+    }
+  }
+
+  var b bytes.Buffer
+  fmt.Fprintf(&b, "// Code generated by \"servejsongen %s\"; DO NOT EDIT.\n\n", strings.Join(os.Args[1:], " "))
+  fmt.Fprintf(&b, "package %s\n\n", g.pkg)
+  b.WriteString("import (\n\t\"encoding/json\"\n\t\"net/http\"\n")
+  if g.time {
+    b.WriteString("\t\"time\"\n")
+  }
+  if g.qualifier != "" {
+    fmt.Fprintf(&b, "\n\tserver %q\n", serveJSONPath)
+  }
+  b.WriteString(")\n")
+
+  for _, r := range roots {
+    fmt.Fprintf(&b, "\n// DispatchJSON implements %sDispatcher\n", g.qualifier)
+    fmt.Fprintf(&b, "func (x *%s) DispatchJSON(r *%sRequest, path %sPath) (*json.RawMessage, error) {\n", r.expr, g.qualifier, g.qualifier)
+    fmt.Fprintf(&b, "\treturn %s(r, path, x)\n}\n", r.name)
+  }
+  b.Write(funcs.Bytes())
+
+  src, err := format.Source(b.Bytes())
+  if err != nil {
+    return nil, fmt.Errorf("formatting generated code: %v\n%s", err, b.Bytes())
+  }
+  return src, nil
+}
+
+// reach marks n and the nodes its dispatch function calls, leaving out the rest
+func reach(n *node, reached map[*node]bool) {
+  if reached[n] || n.kind == unsupported {
+    return
+  }
+  reached[n] = true
+
+  switch n.kind {
+  case structure:
+    for _, f := range n.fields {
+      if !f.tagged {
+        reach(f.node, reached)
+      }
+    }
+  case slice, mapping, pointer:
+    reach(n.elem, reached)
+  }
+}
+
+// function writes the dispatch function for n
+func (g *generator) function(b *bytes.Buffer, n *node) {
+  // the signature names the type, and a struct's deletes its fields' types
+  g.time = g.time || n.time
+  q := g.qualifier
+  p := func(format string, args ...interface{}) {
+    fmt.Fprintf(b, format, args...)
+    b.WriteString("\n")
+  }
+  notGenerated := "return nil, " + q + "ErrNotGenerated"
+  notFound := func(format string, arg string) string {
+    return fmt.Sprintf("return nil, %sNewError(%sCodeNotFound, %q, %s)", q, q, format, arg)
+  }
+  // insert puts the body of a PUT into the slice before leaf
+  insert := func(leaf string) {
+    p("item := new(%s)", n.elem.expr)
+    p("i, err := %sInsertIndex(r, len(*v), %s, item)", q, leaf)
+    p("if err != nil { return nil, err }")
+    p("*v = append(*v, *item)")
+    p("copy((*v)[i+1:], (*v)[i:len(*v)-1])")
+    p("(*v)[i] = *item")
+    p("return %sInserted(r, %s, item)", q, leaf)
+  }
+
+  p("")
+  p("func %s(r *%sRequest, path %sPath, v *%s) (*json.RawMessage, error) {", n.name, q, q, n.expr)
+  if !n.plain {
+    p("if r.Method != http.MethodGet { %s }", notGenerated)
+  }
+
+  // the node itself
+  p("if len(path) == 0 {")
+  // deep implies read, and plain
+  switch {
+  case !n.read:
+    p("%s", notGenerated)
+  case !n.deep:
+    if n.plain {
+      p("if r.Method != http.MethodGet { %s }", notGenerated)
+    }
+    // so only GETs are left
+    p("return %sMarshalNode(v)", q)
+  default:
+    switch n.kind {
+    case slice:
+      p("if r.Method == http.MethodPut {")
+      insert(`""`)
+      p("}")
+    case pointer:
+      p("if r.Method == http.MethodPut { %s }", notGenerated)
+    }
+    p("if r.Method == http.MethodPost {")
+    p("c := new(%s)", n.expr)
+    p("if err := %sDecodeNode(r, v, c); err != nil { return nil, err }", q)
+    p("*v = *c")
+    p("}")
+    p("return %sServeNode(r, v, %q)", q, n.reflectKind)
+  }
+  p("}")
+
+  switch n.kind {
+  case leaf:
+    if n.plain {
+      p("if r.Method == http.MethodDelete && len(path) == 1 {")
+      p("return nil, %sNewError(%sCodeMethodNotAllowed, \"cannot delete from type of '%%s'\", %q)", q, q, n.reflectKind)
+      p("}")
+    }
+    p("%s", notFound("path not found '%s'", "path"))
+
+  case structure:
+    // only GETs reach here through nodes that aren't plain
+    if n.plain {
+      p("if r.Method == http.MethodDelete && len(path) == 1 {")
+      if !n.deep {
+        p("%s", notGenerated)
+      } else {
+        p("switch path[0] {")
+        for _, f := range n.fields {
+          g.time = g.time || f.node.time
+          p("case %q:", f.name)
+          p("if err := %sRemoving(r, &v.%s); err != nil { return nil, err }", q, f.goName)
+          p("v.%s = *new(%s)", f.goName, f.node.expr)
+          p("return %sMarshalNode(&v.%s)", q, f.goName)
+        }
+        p("}")
+        p("%s", notFound("field name not found: '%s'", "path[0]"))
+      }
+      p("}")
+    }
+    p("switch path[0] {")
+    for _, f := range n.fields {
+      if f.tagged || f.node.kind == unsupported {
+        p("case %q: %s", f.name, notGenerated)
+      } else {
+        p("case %q: return %s(r, path[1:], &v.%s)", f.name, f.node.name, f.goName)
+      }
+    }
+    p("}")
+    p("%s", notFound("field name not found: '%s'", "path[0]"))
+
+  case slice:
+    p("if len(path) == 1 {")
+    p("switch {")
+    p("case r.Method == http.MethodPut && %sCanInsert(len(*v), path[0]):", q)
+    if !n.deep {
+      p("%s", notGenerated)
+    } else {
+      insert("path[0]")
+    }
+    p("case r.Method == http.MethodDelete:")
+    if !n.deep {
+      p("%s", notGenerated)
+    } else {
+      p("i, err := %sElementIndex(len(*v), path[0])", q)
+      p("if err != nil { return nil, err }")
+      p("if err := %sRemoving(r, &(*v)[i]); err != nil { return nil, err }", q)
+      p("*v = append((*v)[:i], (*v)[i+1:]...)")
+      p("return nil, nil")
+    }
+    p("}")
+    p("}")
+    if n.elem.kind == unsupported {
+      p("%s", notGenerated)
+      break
+    }
+    p("i, err := %sElementIndex(len(*v), path[0])", q)
+    p("if err != nil { return nil, err }")
+    p("return %s(r, path[1:], &(*v)[i])", n.elem.name)
+
+  case mapping:
+    p("if r.Method == http.MethodDelete && len(path) == 1 {")
+    if !n.deep {
+      p("%s", notGenerated)
+    } else {
+      p("e, ok := (*v)[path[0]]")
+      p("if !ok {")
+      p("%s", notFound("key not found: '%s'", "path[0]"))
+      p("}")
+      p("if err := %sRemoving(r, &e); err != nil { return nil, err }", q)
+      p("delete(*v, path[0])")
+      p("return nil, nil")
+    }
+    p("}")
+    if n.elem.kind == unsupported {
+      p("%s", notGenerated)
+      break
+    }
+    p("e, ok := (*v)[path[0]]")
+    p("if !ok && (r.Method == http.MethodGet || r.Method == http.MethodDelete) {")
+    p("%s", notFound("key not found: '%s'", "path[0]"))
+    p("}")
+    p("res, err := %s(r, path[1:], &e)", n.elem.name)
+    p("if err == nil && r.Method != http.MethodGet {")
+    p("if *v == nil { *v = make(%s) }", n.expr)
+    p("(*v)[path[0]] = e")
+    p("}")
+    p("return res, err")
+
+  case pointer:
+    if n.elem.kind == unsupported {
+      p("%s", notGenerated)
+      break
+    }
+    p("if *v == nil {")
+    p("if r.Method == http.MethodGet || r.Method == http.MethodDelete {")
+    p("%s", notFound("value is nil at '%s'", "path"))
+    p("}")
+    p("// nothing is allocated unless the request succeeds")
+    p("e := new(%s)", n.elem.expr)
+    p("res, err := %s(r, path, e)", n.elem.name)
+    p("if err == nil { *v = e }")
+    p("return res, err")
+    p("}")
+    p("return %s(r, path, *v)", n.elem.name)
+  }
+  p("}")
+}
+
+func exprString(e ast.Expr) string {
+  switch t := e.(type) {
+  case *ast.Ident:
+    return t.Name
+  case *ast.SelectorExpr:
+    return exprString(t.X) + "." + t.Sel.Name
+  case *ast.StarExpr:
+    return "*" + exprString(t.X)
+  case *ast.ArrayType:
+    if t.Len != nil {
+      return "[" + exprString(t.Len) + "]" + exprString(t.Elt)
+    }
+    return "[]" + exprString(t.Elt)
+  case *ast.MapType:
+    return "map[" + exprString(t.Key) + "]" + exprString(t.Value)
+  case *ast.ParenExpr:
+    return exprString(t.X)
+  case *ast.BasicLit:
+    return t.Value
+  }
+  return fmt.Sprintf("%T", e)
+}
+
+// mangle names the dispatch function for the type expression e
+func mangle(e ast.Expr) string {
+  var parts func(e ast.Expr) string
+  parts = func(e ast.Expr) string {
+    switch t := e.(type) {
+    case *ast.Ident:
+      return t.Name
+    case *ast.SelectorExpr:
+      return parts(t.X) + "_" + t.Sel.Name
+    case *ast.StarExpr:
+      return "ptr_" + parts(t.X)
+    case *ast.ArrayType:
+      if t.Len != nil {
+        return "array_" + parts(t.Elt)
+      }
+      return "slice_" + parts(t.Elt)
+    case *ast.MapType:
+      return "map_" + parts(t.Key) + "_" + parts(t.Value)
+    case *ast.ParenExpr:
+      return parts(t.X)
+    }
+    return "other"
+  }
+  return "dispatch_" + parts(e)
+}
@@ -2,18 +2,25 @@ package main
 
 import (
   "net/http"
-  "io/ioutil"
   "log"
   server "github.com/donniet/mirror.3/serveJSON"
   "encoding/json"
-  "strings"
   "time"
   "fmt"
   "flag"
+  "os"
 )
 
 type DateTime struct {
   Visible bool `json:"visible"`
+  Now Clock `json:"now"`
+}
+
+// Clock is computed from the time of each request, to the minute
+type Clock struct{}
+
+func (c *Clock) Get() (interface{}, error) {
+  return time.Now().Truncate(time.Minute), nil
 }
 
 type Weather struct {
@@ -22,12 +29,26 @@ type Weather struct {
   Icon string `json:"icon"`
   Visible bool `json:"visible"`
 }
+func (w *Weather) Validate() error {
+  if w.High < w.Low {
+    return fmt.Errorf("high %v is below low %v", w.High, w.Low)
+  }
+  return nil
+}
 
 type Stream struct {
   URL string `json:"url"`
-  Name string `json:"name"`
+  Name string `json:"name" serve:"key"`
   Visible bool `json:"visible"`
 }
+func (s *Stream) Validate() error {
+  if s.URL == "" {
+    return fmt.Errorf("stream url is empty")
+  }
+  return nil
+}
+
+//go:generate go run ./cmd/servejsongen -type Mirror -output mirror_dispatch.go
 
 type Mirror struct {
   DateTime DateTime `json:"dateTime"`
@@ -38,8 +59,14 @@ type Mirror struct {
 }
 
 type Faces struct {
-  LastSeen *FaceDetected `json:"predicted,omitempty"`
-  Threshold float32 `json:"threshold"`
+  LastSeen *FaceDetected `json:"predicted,omitempty" serve:"readonly"`
+  Threshold float32 `json:"threshold" validate:"min=0,max=1"`
+}
+func (f *Faces) Validate() error {
+  if f.Threshold < 0 || f.Threshold > 1 {
+    return fmt.Errorf("threshold %v is outside 0 to 1", f.Threshold)
+  }
+  return nil
 }
 
 type FaceDetected struct {
@@ -52,6 +79,18 @@ type Display struct {
   PowerStatus string `json:"powerStatus"`
 }
 
+// powerStatuses are the values PowerStatus may be set to
+var powerStatuses = []string{"on", "standby", "unknown"}
+
+func validPowerStatus(power string) bool {
+  for _, p := range powerStatuses {
+    if p == power {
+      return true
+    }
+  }
+  return false
+}
+
 func (d *Display) MarshalJSON() ([]byte, error) {
   m := make(map[string]string)
   if d.PowerStatus == "" {
@@ -68,78 +107,71 @@ func (d *Display) UnmarshalJSON(data []byte) error {
   }
   power := m["powerStatus"]
 
-  switch power {
-  case "on":
-  case "standby":
-  case "unknown":
-  default:
+  if !validPowerStatus(power) {
     return fmt.Errorf("unknown power value: '%s'", power)
   }
 
   d.PowerStatus = power
   return nil
 }
-
-type Saver struct {
-  fileName string
-  data interface{}
-}
-func (s Saver) Notify(req *server.Request) error {
-  switch req.Method {
-  case http.MethodPut:
-  case http.MethodPost:
-  case http.MethodDelete:
-  default:
-    return nil
+func (d *Display) JSONSchema() *server.Schema {
+  enum := make([]interface{}, len(powerStatuses))
+  for i, p := range powerStatuses {
+    enum[i] = p
   }
-
-  if b, err := json.MarshalIndent(s.data, "", "\t"); err != nil {
-    log.Fatal(err)
-  } else if err = ioutil.WriteFile(s.fileName, b, 0660); err != nil {
-    log.Fatal(err)
+  return &server.Schema{
+    Type: server.SchemaTypes{"object"},
+    Properties: map[string]*server.Schema{
+      "powerStatus": &server.Schema{
+        Type: server.SchemaTypes{"string"},
+        Enum: enum,
+      },
+    },
+    Required: []string{"powerStatus"},
+  }
+}
+// Validate catches values set through the powerStatus field itself, which
+// bypass UnmarshalJSON.  A display never set is left empty, shown as unknown.
+func (d *Display) Validate() error {
+  if d.PowerStatus != "" && !validPowerStatus(d.PowerStatus) {
+    return fmt.Errorf("unknown power value: '%s'", d.PowerStatus)
   }
   return nil
 }
 
+// System describes the machine the mirror runs on, and isn't saved
+type System struct {
+  Hostname string `json:"hostname" serve:"readonly"`
+  Uptime Uptime `json:"uptime"`
+}
+
+// Uptime is computed as the seconds since the server started
+type Uptime struct{}
+
+var started = time.Now()
+
+func (u *Uptime) Get() (interface{}, error) {
+  return int64(time.Since(started) / time.Second), nil
+}
+
 var (
   fileName = "state.json"
+  journalName = "state.log"
+  compactInterval = time.Hour
   addr = ":8080"
+  historyLimit = 100
+  retain = 24 * time.Hour
+  timelineLimit = 10000
 )
 
 func init() {
-  flag.StringVar(&fileName, "stateFile", fileName, "file name to save and restore state")
+  flag.StringVar(&fileName, "stateFile", fileName, "file name to save and restore snapshots of state")
+  flag.StringVar(&journalName, "journal", journalName, "file name to log changes to state since the last snapshot")
+  flag.DurationVar(&compactInterval, "compact", compactInterval, "how often to snapshot state and compact the journal")
   flag.StringVar(&addr, "addr", addr, "address to listen on")
-}
-
-type State struct {
-  Data interface{}
-  watchers []server.Notifier
-}
-func (s *State) Watch(watcher server.Notifier) {
-  s.watchers = append(s.watchers, watcher)
-}
-func (s *State) Unwatch(watcher server.Notifier) {
-  found := len(s.watchers)
-  for i, n := range s.watchers {
-    if n == watcher {
-      found = i
-      break
-    }
-  }
-
-  if found < len(s.watchers) {
-    s.watchers[found] = s.watchers[len(s.watchers) - 1]
-    s.watchers = s.watchers[:len(s.watchers) - 1]
-  }
-}
-func (s *State) Request(req *server.Request) (*json.RawMessage, error) {
-  req.Response, req.Error = server.ServeJSON(req, s.Data)
-
-  for _, n := range s.watchers {
-    go n.Notify(req)
-  }
-
-  return req.Response, req.Error
+  flag.IntVar(&historyLimit, "history", historyLimit, "number of changes that can be undone")
+  flag.DurationVar(&retain, "retain", retain, "how long to keep changes that can be looked back at with asOf and diff")
+  flag.IntVar(&timelineLimit, "timeline", timelineLimit, "most changes to keep to look back at, however recent")
 }
 
 func main() {
@@ -147,52 +179,57 @@ func main() {
   log.SetFlags(log.Lshortfile | log.LstdFlags)
 
   d := &Mirror{}
-  state := &State{Data: d}
+  state := server.NewTree(d)
+  // so that an accidental change from a phone can be undone
+  state.KeepHistory(historyLimit)
+  // so that what was shown last night can be looked back at
+  state.KeepTimeline(retain, timelineLimit)
+
+  system := &System{}
+  system.Hostname, _ = os.Hostname()
+
+  // websockets see the mirror at the root, and the system beneath it
+  router := server.NewRouter()
+  router.Mount(server.Path{}, state)
+  systemState := server.NewTree(system)
+  // so that the whole router can be looked back at
+  systemState.KeepTimeline(retain, timelineLimit)
+  router.Mount(server.Path{"system"}, systemState)
 
   sockets := NewSockets()
-  saver := &Saver{fileName, d}
+  journal := server.NewJournal(state, fileName, journalName)
 
-  state.Watch(sockets)
-  state.Watch(saver)
-
-  if b, err := ioutil.ReadFile(fileName); err != nil {
-    log.Fatal(err)
-  } else if err = json.Unmarshal(b, d); err != nil {
+  if err := journal.Replay(); err != nil {
     log.Fatal(err)
   }
+  defer journal.Close()
+
+  router.Watch(sockets)
+  state.Watch(journal)
+
+  stopCompacting := journal.CompactEvery(compactInterval, func(err error) {
+    log.Printf("compacting journal: %v", err)
+  })
+  defer stopCompacting()
+
+  // the clock only changes once a minute, and is only pushed when it does
+  stopClock := state.Push(server.Path{"dateTime", "now"}, time.Second)
+  defer stopClock()
 
   go func() {
     for req := range sockets.Incoming {
-      req.Response, req.Error = server.ServeJSON(req, d);
-      sockets.Notify(req)
+      if _, err := router.Request(req); err != nil {
+        // failed requests aren't broadcast, so answer the requestor directly
+        sockets.Notify(req)
+      }
     }
   }()
 
   mux := http.NewServeMux()
   mux.Handle("/", http.FileServer(http.Dir("client")))
   mux.Handle("/socket", sockets.ConnectionHandler())
-  mux.Handle("/api/", http.StripPrefix("/api/", http.HandlerFunc(func (w http.ResponseWriter, r *http.Request) {
-    body, _ := ioutil.ReadAll(r.Body)
-
-    req := &server.Request{
-      Method: r.Method,
-      Path: strings.Split(r.URL.Path, "/"),
-      Body: (*json.RawMessage)(&body),
-    }
-    if len(body) == 0 {
-      req.Body = nil
-    }
-
-    res, err := state.Request(req)
-    if err != nil {
-      http.Error(w, req.Error.Error(), 500)
-      return
-    }
-
-    if res != nil {
-      w.Write(*res)
-    }
-  })))
+  mux.Handle("/api/", http.StripPrefix("/api/", router))
+  mux.Handle("/schema/", http.StripPrefix("/schema/", server.SchemaHandler{Wrapped: d}))
 
   log.Fatal(http.ListenAndServe(addr, mux))
 }
@@ -0,0 +1,140 @@
+// Code generated by "servejsongen -type Mirror -output mirror_dispatch.go"; DO NOT EDIT.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	server "github.com/donniet/mirror.3/serveJSON"
+)
+
+// DispatchJSON implements server.Dispatcher
+func (x *Mirror) DispatchJSON(r *server.Request, path server.Path) (*json.RawMessage, error) {
+	return dispatch_Mirror(r, path, x)
+}
+
+func dispatch_DateTime(r *server.Request, path server.Path, v *DateTime) (*json.RawMessage, error) {
+	if len(path) == 0 {
+		return nil, server.ErrNotGenerated
+	}
+	if r.Method == http.MethodDelete && len(path) == 1 {
+		return nil, server.ErrNotGenerated
+	}
+	switch path[0] {
+	case "visible":
+		return dispatch_bool(r, path[1:], &v.Visible)
+	case "now":
+		return nil, server.ErrNotGenerated
+	}
+	return nil, server.NewError(server.CodeNotFound, "field name not found: '%s'", path[0])
+}
+
+func dispatch_Faces(r *server.Request, path server.Path, v *Faces) (*json.RawMessage, error) {
+	if r.Method != http.MethodGet {
+		return nil, server.ErrNotGenerated
+	}
+	if len(path) == 0 {
+		return nil, server.ErrNotGenerated
+	}
+	switch path[0] {
+	case "predicted":
+		return nil, server.ErrNotGenerated
+	case "threshold":
+		return dispatch_float32(r, path[1:], &v.Threshold)
+	}
+	return nil, server.NewError(server.CodeNotFound, "field name not found: '%s'", path[0])
+}
+
+func dispatch_Mirror(r *server.Request, path server.Path, v *Mirror) (*json.RawMessage, error) {
+	if len(path) == 0 {
+		return nil, server.ErrNotGenerated
+	}
+	if r.Method == http.MethodDelete && len(path) == 1 {
+		return nil, server.ErrNotGenerated
+	}
+	switch path[0] {
+	case "dateTime":
+		return dispatch_DateTime(r, path[1:], &v.DateTime)
+	case "weather":
+		return dispatch_Weather(r, path[1:], &v.Weather)
+	case "streams":
+		return nil, server.ErrNotGenerated
+	case "display":
+		return nil, server.ErrNotGenerated
+	case "faces":
+		return dispatch_Faces(r, path[1:], &v.Faces)
+	}
+	return nil, server.NewError(server.CodeNotFound, "field name not found: '%s'", path[0])
+}
+
+func dispatch_Weather(r *server.Request, path server.Path, v *Weather) (*json.RawMessage, error) {
+	if r.Method != http.MethodGet {
+		return nil, server.ErrNotGenerated
+	}
+	if len(path) == 0 {
+		return server.MarshalNode(v)
+	}
+	switch path[0] {
+	case "high":
+		return dispatch_float32(r, path[1:], &v.High)
+	case "low":
+		return dispatch_float32(r, path[1:], &v.Low)
+	case "icon":
+		return dispatch_string(r, path[1:], &v.Icon)
+	case "visible":
+		return dispatch_bool(r, path[1:], &v.Visible)
+	}
+	return nil, server.NewError(server.CodeNotFound, "field name not found: '%s'", path[0])
+}
+
+func dispatch_bool(r *server.Request, path server.Path, v *bool) (*json.RawMessage, error) {
+	if len(path) == 0 {
+		if r.Method == http.MethodPost {
+			c := new(bool)
+			if err := server.DecodeNode(r, v, c); err != nil {
+				return nil, err
+			}
+			*v = *c
+		}
+		return server.ServeNode(r, v, "bool")
+	}
+	if r.Method == http.MethodDelete && len(path) == 1 {
+		return nil, server.NewError(server.CodeMethodNotAllowed, "cannot delete from type of '%s'", "bool")
+	}
+	return nil, server.NewError(server.CodeNotFound, "path not found '%s'", path)
+}
+
+func dispatch_float32(r *server.Request, path server.Path, v *float32) (*json.RawMessage, error) {
+	if len(path) == 0 {
+		if r.Method == http.MethodPost {
+			c := new(float32)
+			if err := server.DecodeNode(r, v, c); err != nil {
+				return nil, err
+			}
+			*v = *c
+		}
+		return server.ServeNode(r, v, "float32")
+	}
+	if r.Method == http.MethodDelete && len(path) == 1 {
+		return nil, server.NewError(server.CodeMethodNotAllowed, "cannot delete from type of '%s'", "float32")
+	}
+	return nil, server.NewError(server.CodeNotFound, "path not found '%s'", path)
+}
+
+func dispatch_string(r *server.Request, path server.Path, v *string) (*json.RawMessage, error) {
+	if len(path) == 0 {
+		if r.Method == http.MethodPost {
+			c := new(string)
+			if err := server.DecodeNode(r, v, c); err != nil {
+				return nil, err
+			}
+			*v = *c
+		}
+		return server.ServeNode(r, v, "string")
+	}
+	if r.Method == http.MethodDelete && len(path) == 1 {
+		return nil, server.NewError(server.CodeMethodNotAllowed, "cannot delete from type of '%s'", "string")
+	}
+	return nil, server.NewError(server.CodeNotFound, "path not found '%s'", path)
+}
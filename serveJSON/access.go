@@ -0,0 +1,427 @@
+package serveJSON
+
+import (
+  "bytes"
+  "encoding/json"
+  "reflect"
+  "strings"
+  "sync"
+)
+
+/*
+Fields tagged `serve:"readonly"` can be read but not written by requests,
+`serve:"writeonly"` can be written but are never sent back, and
+`serve:"hidden"` can't be reached at all.  The server itself can still change
+any of them through Tree.Update.
+*/
+const (
+  ReadOnly = "readonly"
+  WriteOnly = "writeonly"
+  Hidden = "hidden"
+)
+
+// hasTag reports whether the serve tag of f includes option
+func hasTag(f reflect.StructField, option string) bool {
+  for _, o := range strings.Split(f.Tag.Get("serve"), ",") {
+    if strings.TrimSpace(o) == option {
+      return true
+    }
+  }
+  return false
+}
+
+func checkAccess(f reflect.StructField, name string, m mode) error {
+  switch {
+  case hasTag(f, Hidden):
+    return newError(CodeNotFound, "field name not found: '%s'", name)
+  case m&modeWrite != 0 && hasTag(f, ReadOnly):
+    return newError(CodeMethodNotAllowed, "field '%s' is read only", name)
+  case m&modeWrite == 0 && hasTag(f, WriteOnly):
+    return newError(CodeMethodNotAllowed, "field '%s' is write only", name)
+  }
+  return nil
+}
+
+var accessCacheLock sync.Mutex
+var accessCache = make(map[reflect.Type]bool)
+
+/*
+hasAccessTags reports whether values of type t may contain fields with access
+tags, or computed nodes, so they can't simply be marshalled.  Interfaces could
+hold anything, so they always may.
+*/
+func hasAccessTags(t reflect.Type) bool {
+  accessCacheLock.Lock()
+  defer accessCacheLock.Unlock()
+
+  return accessTags(t)
+}
+
+func accessTags(t reflect.Type) bool {
+  if tagged, ok := accessCache[t]; ok {
+    return tagged
+  }
+  // guard against recursive types while we look
+  accessCache[t] = false
+
+  tagged := computed(t)
+
+  switch t.Kind() {
+  case reflect.Interface:
+    tagged = true
+  case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+    tagged = tagged || accessTags(t.Elem())
+  case reflect.Struct:
+    for i := 0; i < t.NumField() && !tagged; i++ {
+      f := t.Field(i)
+      tagged = hasTag(f, ReadOnly) || hasTag(f, WriteOnly) || hasTag(f, Hidden) || accessTags(f.Type)
+    }
+  }
+
+  accessCache[t] = tagged
+  return tagged
+}
+
+/*
+protect runs f, which may overwrite the whole value pointed to by pv, and then
+puts back any read only or hidden fields, or computed nodes, it changed.
+*/
+func protect(pv reflect.Value, f func() error) error {
+  if !hasAccessTags(pv.Elem().Type()) {
+    return f()
+  }
+
+  snapshot := deepCopy(pv.Elem())
+
+  if err := f(); err != nil {
+    return err
+  }
+
+  restoreProtected(pv.Elem(), snapshot)
+  return nil
+}
+
+/*
+restoreProtected copies protected fields and computed nodes from old into v.  Where old has no
+counterpart, such as new slice elements or map entries, it is the invalid
+Value and protected fields are reset to their zero values.
+*/
+func restoreProtected(v, old reflect.Value) {
+  if !hasAccessTags(v.Type()) {
+    return
+  }
+  if old.IsValid() && old.Type() != v.Type() {
+    old = reflect.Value{}
+  }
+
+  switch v.Kind() {
+  case reflect.Ptr, reflect.Interface:
+    if v.IsNil() {
+      return
+    }
+    if old.IsValid() && !old.IsNil() {
+      old = old.Elem()
+    } else {
+      old = reflect.Value{}
+    }
+    if v.Kind() == reflect.Interface {
+      // interface contents aren't settable, so work on a copy
+      c := reflect.New(v.Elem().Type()).Elem()
+      c.Set(v.Elem())
+      restoreProtected(c, old)
+      v.Set(c)
+      return
+    }
+    restoreProtected(v.Elem(), old)
+  case reflect.Struct:
+    t := v.Type()
+    for i := 0; i < t.NumField(); i++ {
+      f := t.Field(i)
+      field := v.Field(i)
+
+      if !field.CanSet() {
+        if promoted(f) {
+          // the fields promoted from unexported embedded structs can still be set
+          if old.IsValid() {
+            restoreProtected(field, old.Field(i))
+          } else {
+            restoreProtected(field, reflect.Value{})
+          }
+        }
+        continue
+      }
+
+      if hasTag(f, ReadOnly) || hasTag(f, Hidden) || computed(f.Type) {
+        if old.IsValid() {
+          field.Set(old.Field(i))
+        } else {
+          field.Set(reflect.Zero(f.Type))
+        }
+      } else if old.IsValid() {
+        restoreProtected(field, old.Field(i))
+      } else {
+        restoreProtected(field, reflect.Value{})
+      }
+    }
+  case reflect.Slice, reflect.Array:
+    for i := 0; i < v.Len(); i++ {
+      if old.IsValid() && i < old.Len() {
+        restoreProtected(v.Index(i), old.Index(i))
+      } else {
+        restoreProtected(v.Index(i), reflect.Value{})
+      }
+    }
+  case reflect.Map:
+    for _, k := range v.MapKeys() {
+      c := reflect.New(v.Type().Elem()).Elem()
+      c.Set(v.MapIndex(k))
+
+      if old.IsValid() {
+        restoreProtected(c, old.MapIndex(k))
+      } else {
+        restoreProtected(c, reflect.Value{})
+      }
+      v.SetMapIndex(k, c)
+    }
+  }
+}
+
+var marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+var secretCache = make(map[reflect.Type]bool)
+
+/*
+secretFields reports whether values of type t may contain write only or hidden
+fields, which a body written to them may carry.  Interfaces hold decoded JSON,
+which has no tags.
+*/
+func secretFields(t reflect.Type) bool {
+  accessCacheLock.Lock()
+  defer accessCacheLock.Unlock()
+
+  return secrets(t)
+}
+
+func secrets(t reflect.Type) bool {
+  if secret, ok := secretCache[t]; ok {
+    return secret
+  }
+  secretCache[t] = false
+
+  secret := false
+  switch t.Kind() {
+  case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+    secret = secrets(t.Elem())
+  case reflect.Struct:
+    for i := 0; i < t.NumField() && !secret; i++ {
+      f := t.Field(i)
+      secret = hasTag(f, WriteOnly) || hasTag(f, Hidden) || secrets(f.Type)
+    }
+  }
+
+  secretCache[t] = secret
+  return secret
+}
+
+/*
+secretPath reports whether path, beneath a value of type t, passes through a
+write only or hidden field, and otherwise returns the type it leads to if that
+is known.
+*/
+func secretPath(t reflect.Type, path Path) (bool, reflect.Type) {
+  for _, token := range path {
+    for t.Kind() == reflect.Ptr {
+      t = t.Elem()
+    }
+
+    switch t.Kind() {
+    case reflect.Struct:
+      f, err := structField(token, t)
+      if err != nil {
+        return false, nil
+      }
+      if hasTag(f, WriteOnly) || hasTag(f, Hidden) {
+        return true, nil
+      }
+      t = f.Type
+    case reflect.Slice, reflect.Array, reflect.Map:
+      t = t.Elem()
+    default:
+      return false, nil
+    }
+  }
+  return false, t
+}
+
+/*
+conceal marks what watchers of a request to data of type t mustn't be sent: all
+of its values if its path passes through a write only or hidden field, or just
+its body if that is written to a value that may hold them.
+*/
+func conceal(req *Request, t reflect.Type, base Path) {
+  path := append(cleanPath(base), cleanPath(req.Path)...)
+
+  secret, node := secretPath(t, path)
+  req.concealed = secret
+  req.concealedBody = secret || (node != nil && secretFields(node))
+
+  for _, sub := range req.Batch {
+    conceal(sub, t, path)
+    // a batch responds with the responses of each request
+    req.concealed = req.concealed || sub.concealed
+    req.concealedBody = req.concealedBody || sub.concealedBody
+  }
+}
+
+/*
+Marshal encodes v like json.Marshal, but leaves out fields tagged as hidden or
+write only.  It is used for every response ServeJSON sends.
+*/
+func Marshal(v interface{}) ([]byte, error) {
+  if v == nil || !hasAccessTags(reflect.TypeOf(v)) {
+    return json.Marshal(v)
+  }
+  return marshalValue(reflect.ValueOf(v))
+}
+
+func marshalValue(v reflect.Value) ([]byte, error) {
+  if !v.IsValid() {
+    return jsonNull, nil
+  }
+
+  if g, ok := getter(v); ok {
+    value, err := g.Get()
+    if err != nil {
+      return nil, err
+    }
+    return Marshal(value)
+  }
+
+  t := v.Type()
+
+  if t.Implements(marshalerType) || (v.CanAddr() && v.Addr().Type().Implements(marshalerType)) || !hasAccessTags(t) {
+    if v.CanAddr() {
+      return json.Marshal(v.Addr().Interface())
+    }
+    return json.Marshal(v.Interface())
+  }
+
+  switch v.Kind() {
+  case reflect.Ptr, reflect.Interface:
+    if v.IsNil() {
+      return jsonNull, nil
+    }
+    return marshalValue(v.Elem())
+  case reflect.Struct:
+    return marshalStruct(v)
+  case reflect.Slice, reflect.Array:
+    if v.Kind() == reflect.Slice && v.IsNil() {
+      return jsonNull, nil
+    }
+    items := make([]json.RawMessage, v.Len())
+    for i := range items {
+      b, err := marshalValue(v.Index(i))
+      if err != nil {
+        return nil, err
+      }
+      items[i] = b
+    }
+    return json.Marshal(items)
+  case reflect.Map:
+    if v.IsNil() {
+      return jsonNull, nil
+    }
+    m := reflect.MakeMapWithSize(reflect.MapOf(t.Key(), rawMessageType), v.Len())
+    for _, k := range v.MapKeys() {
+      b, err := marshalValue(v.MapIndex(k))
+      if err != nil {
+        return nil, err
+      }
+      m.SetMapIndex(k, reflect.ValueOf(json.RawMessage(b)))
+    }
+    return json.Marshal(m.Interface())
+  }
+  return json.Marshal(v.Interface())
+}
+
+func marshalStruct(v reflect.Value) ([]byte, error) {
+  var b bytes.Buffer
+  t := v.Type()
+
+  b.WriteByte('{')
+  first := true
+
+  for i := 0; i < t.NumField(); i++ {
+    f := t.Field(i)
+
+    name, ok := fieldName(f)
+    unexported := f.PkgPath != "" && promoted(f)
+    if (!ok && !unexported) || hasTag(f, Hidden) || hasTag(f, WriteOnly) {
+      continue
+    }
+
+    field := v.Field(i)
+    if strings.Contains(f.Tag.Get("json"), ",omitempty") && isEmptyValue(field) {
+      continue
+    }
+
+    var value []byte
+    var err error
+    if unexported {
+      // only the promoted fields of unexported embedded structs can be read
+      value, err = marshalStruct(field)
+    } else {
+      value, err = marshalValue(field)
+    }
+    if err != nil {
+      return nil, err
+    }
+
+    if unexported || f.Anonymous && name == f.Name && bytes.HasPrefix(value, []byte("{")) {
+      // embedded structs are flattened into their parent
+      if inner := bytes.TrimSpace(value[1:len(value)-1]); len(inner) > 0 {
+        if !first {
+          b.WriteByte(',')
+        }
+        first = false
+        b.Write(inner)
+      }
+      continue
+    } else if f.Anonymous && name == f.Name && isEmptyValue(field) {
+      continue
+    }
+
+    key, _ := json.Marshal(name)
+
+    if !first {
+      b.WriteByte(',')
+    }
+    first = false
+
+    b.Write(key)
+    b.WriteByte(':')
+    b.Write(value)
+  }
+
+  b.WriteByte('}')
+  return b.Bytes(), nil
+}
+
+// isEmptyValue matches the omitempty rules of encoding/json
+func isEmptyValue(v reflect.Value) bool {
+  switch v.Kind() {
+  case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+    return v.Len() == 0
+  case reflect.Bool:
+    return !v.Bool()
+  case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+    return v.Int() == 0
+  case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+    return v.Uint() == 0
+  case reflect.Float32, reflect.Float64:
+    return v.Float() == 0
+  case reflect.Interface, reflect.Ptr:
+    return v.IsNil()
+  }
+  return false
+}
@@ -0,0 +1,193 @@
+package serveJSON
+
+import (
+  "testing"
+  "net/http"
+  "encoding/json"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "strings"
+)
+
+type TestAccount struct {
+  Name string `json:"name"`
+  Owner string `json:"owner" serve:"readonly"`
+  Password string `json:"password,omitempty" serve:"writeonly"`
+  Secret string `json:"secret" serve:"hidden"`
+  Ignored string `json:"-"`
+}
+
+type TestAccounts struct {
+  Accounts []TestAccount `json:"accounts"`
+  Primary TestAccount `json:"primary"`
+  Server *TestStruct `json:"server,omitempty" serve:"readonly"`
+}
+
+func TestAccessGET(t *testing.T) {
+  resetTesters()
+
+  output, err := ServeJSON(&Request{Method: http.MethodGet, Path: Path{}}, accounts)
+
+  if err != nil {
+    t.Fatal(err)
+  } else if s := string(*output); strings.Contains(s, "pa") || strings.Contains(s, "sp") || strings.Contains(s, "ia") || strings.Contains(s, "password") {
+    t.Errorf("expected hidden and write only fields to be left out, got `%s`", s)
+  } else if !strings.Contains(s, `"owner":"root"`) || !strings.Contains(s, `"integer":1`) {
+    t.Errorf("expected read only fields to be included, got `%s`", s)
+  }
+
+  for _, path := range []Path{
+    Path{"primary", "password"},
+    Path{"primary", "secret"},
+    Path{"primary", "Secret"},
+    Path{"primary", "Ignored"},
+    Path{"primary", "-"},
+  } {
+    if _, err := ServeJSON(&Request{Method: http.MethodGet, Path: path}, accounts); err == nil {
+      t.Errorf("'%v': expected error, got none", path)
+    }
+  }
+}
+
+func TestAccessWrite(t *testing.T) {
+  resetTesters()
+
+  if _, err := ServeJSON(&Request{Method: http.MethodPost, Path: Path{"primary", "password"}, Body: rawBody(`"new"`)}, accounts); err != nil {
+    t.Error(err)
+  } else if accounts.Primary.Password != "new" {
+    t.Errorf("expected write only field to be written, got '%s'", accounts.Primary.Password)
+  }
+
+  for _, path := range []Path{
+    Path{"primary", "owner"},
+    Path{"primary", "secret"},
+    Path{"server", "integer"},
+  } {
+    if _, err := ServeJSON(&Request{Method: http.MethodPost, Path: path, Body: rawBody(`"x"`)}, accounts); err == nil {
+      t.Errorf("'%v': expected error, got none", path)
+    }
+  }
+
+  _, err := ServeJSON(&Request{Method: http.MethodPost, Path: Path{"primary"}, Body: rawBody(`{"name": "q", "owner": "me", "secret": "x"}`)}, accounts)
+  if err != nil {
+    t.Error(err)
+  } else if p := accounts.Primary; p.Name != "q" || p.Owner != "root" || p.Secret != "sp" {
+    t.Errorf("expected protected fields to survive a whole object write, got %#v", p)
+  }
+
+  _, err = ServeJSON(&Request{Method: http.MethodPut, Path: Path{"accounts"}, Body: rawBody(`{"name": "b", "owner": "me", "secret": "x"}`)}, accounts)
+  if err != nil {
+    t.Error(err)
+  } else if a := accounts.Accounts[1]; a.Name != "b" || a.Owner != "" || a.Secret != "" {
+    t.Errorf("expected protected fields of new elements to be cleared, got %#v", a)
+  }
+
+  _, err = ServeJSON(&Request{Method: http.MethodPatch, Path: Path{"primary"}, Body: rawBody(`{"owner": "me"}`)}, accounts)
+  if err == nil {
+    t.Errorf("expected read only error from merge patch, got none")
+  }
+
+  _, err = ServeJSON(&Request{Method: http.MethodDelete, Path: Path{"accounts", "0"}}, accounts)
+  if err != nil {
+    t.Error(err)
+  } else if a := accounts.Accounts[0]; a.Name != "b" || a.Owner != "" {
+    t.Errorf("expected delete to shift elements untouched, got %#v", a)
+  }
+}
+
+func TestAccessJSONPatch(t *testing.T) {
+  resetTesters()
+
+  _, err := jsonPatch(Path{}, `[{"op": "replace", "path": "/primary/owner", "value": "me"}]`, accounts)
+  if err == nil {
+    t.Errorf("expected read only error, got none")
+  }
+
+  _, err = jsonPatch(Path{}, `[{"op": "replace", "path": "/primary", "value": {"name": "r", "owner": "me"}}]`, accounts)
+  if err != nil {
+    t.Error(err)
+  } else if p := accounts.Primary; p.Name != "r" || p.Owner != "root" {
+    t.Errorf("expected read only field to be kept, got %#v", p)
+  }
+}
+
+func TestAccessWatch(t *testing.T) {
+  resetTesters()
+  tree := NewTree(accounts)
+  watcher := TestCounter{make(chan *Request, 8)}
+  tree.Watch(watcher)
+
+  dir, err := ioutil.TempDir("", "journal")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer os.RemoveAll(dir)
+
+  j := NewJournal(tree, filepath.Join(dir, "state.json"), filepath.Join(dir, "state.log"))
+  defer j.Close()
+  tree.Watch(j)
+
+  for _, c := range []struct {
+    method string
+    path Path
+    body string
+  }{
+    {http.MethodPost, Path{"primary", "password"}, `"first-secret"`},
+    {http.MethodPost, Path{"primary"}, `{"name":"q","password":"second-secret"}`},
+    {http.MethodPut, Path{"accounts", "-"}, `{"name":"b","password":"third-secret"}`},
+    {MethodBatch, Path{"accounts", "0"}, `[{"method":"POST","path":["password"],"body":"fourth-secret"}]`},
+    {http.MethodDelete, Path{"primary", "password"}, ""},
+  } {
+    _, err := historyRequest(tree, c.method, "", c.path, c.body)
+    if err != nil {
+      t.Fatalf("%s '%v': %v", c.method, c.path, err)
+    }
+
+    b, err := json.Marshal(<-watcher.count)
+    if err != nil {
+      t.Fatal(err)
+    }
+    if strings.Contains(string(b), "secret") {
+      t.Errorf("%s '%v': expected write only values kept from watchers, got `%s`", c.method, c.path, b)
+    }
+  }
+
+  // the journal still logs the bodies, so that they can be replayed
+  entries, err := j.entries()
+  if err != nil {
+    t.Fatal(err)
+  }
+  if len(entries) != 5 || entries[0].Request.Body == nil || string(*entries[0].Request.Body) != `"first-secret"` {
+    t.Errorf("expected the journal to keep the bodies, got %v", entries)
+  }
+
+  // responses without write only values are still sent
+  req, _ := historyRequest(tree, http.MethodPost, "", Path{"primary", "name"}, `"r"`)
+  if b, _ := json.Marshal(<-watcher.count); !strings.Contains(string(b), `"response":"r"`) || req.Response == nil {
+    t.Errorf("expected the response passed on, got `%s`", b)
+  }
+}
+
+func TestMarshal(t *testing.T) {
+  type Inner struct {
+    Shown int `json:"shown"`
+    Hidden int `serve:"hidden"`
+  }
+  type outer struct {
+    Inner
+    Empty *Inner `json:"empty,omitempty"`
+    Map map[string]Inner `json:"map"`
+  }
+
+  b, err := Marshal(&outer{
+    Inner: Inner{1, 2},
+    Map: map[string]Inner{"a": Inner{3, 4}},
+  })
+
+  if err != nil {
+    t.Error(err)
+  } else if string(b) != `{"shown":1,"map":{"a":{"shown":3}}}` {
+    t.Errorf("unexpected output `%s`", b)
+  }
+}
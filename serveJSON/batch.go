@@ -0,0 +1,74 @@
+package serveJSON
+
+import (
+  "encoding/json"
+  "fmt"
+  "net/http"
+  "reflect"
+)
+
+const (
+  MethodBatch = "BATCH"
+  BatchType = "application/batch+json"
+)
+
+/*
+requests returns the requests in a batch, decoding them from the body if they
+weren't given in the Batch field.  Paths of the batched requests are relative
+to the path of the batch.
+*/
+func (r *Request) requests() ([]*Request, error) {
+  if r.Batch == nil && r.Body != nil && len(*r.Body) > 0 {
+    if err := json.Unmarshal(*r.Body, &r.Batch); err != nil {
+      return nil, wrapError(CodeBadRequest, err)
+    }
+  }
+
+  for i, sub := range r.Batch {
+    if sub == nil {
+      return nil, newError(CodeBadRequest, "request %d is empty", i)
+    }
+    if sub.Method == MethodBatch {
+      return nil, newError(CodeBadRequest, "request %d, batches cannot be nested", i)
+    }
+  }
+  return r.Batch, nil
+}
+
+/*
+serveBatch applies each request in the batch in order, storing each response
+in its request and returning them together as an array.  The nodes changed are
+validated once every request has been applied, so the batch may pass through
+states that wouldn't be valid on their own.  If any request or validation fails
+the error is returned and it is up to the caller to roll back the others.
+*/
+func serveBatch(r *Request, base Path, pv reflect.Value) (*json.RawMessage, error) {
+  batch, err := r.requests()
+  if err != nil {
+    return nil, err
+  }
+
+  responses := make([]*json.RawMessage, len(batch))
+
+  for i, sub := range batch {
+    sub.unvalidated = true
+    if sub.Response, sub.Error = serve(sub, base, pv); sub.Error != nil {
+      return nil, fmt.Errorf("request %d (%s %v): %w", i, sub.Method, sub.Path, sub.Error)
+    }
+    responses[i] = sub.Response
+  }
+
+  for i, sub := range batch {
+    if sub.Method == http.MethodGet {
+      continue
+    }
+    // nodes taken away by a later request are left to the validation of their parent
+    if err := validate(pv, sub.changed); err != nil && Code(err) != CodeNotFound {
+      sub.Error = err
+      return nil, fmt.Errorf("request %d (%s %v): %w", i, sub.Method, sub.Path, err)
+    }
+  }
+
+  bytes, _ := json.Marshal(responses)
+  return (*json.RawMessage)(&bytes), nil
+}
@@ -0,0 +1,168 @@
+package serveJSON
+
+import (
+  "testing"
+  "net/http"
+  "net/http/httptest"
+  "encoding/json"
+  "strings"
+  "fmt"
+)
+
+func TestServeJSONBatch(t *testing.T) {
+  mirror := newTestMirror()
+  url := []byte(`"rtsp://garage"`)
+  integer := []byte(`7`)
+
+  req := &Request{
+    Method: MethodBatch,
+    Batch: []*Request{
+      &Request{Method: http.MethodPost, Path: Path{"streams", "0", "url"}, Body: (*json.RawMessage)(&url)},
+      &Request{Method: http.MethodDelete, Path: Path{"streams", "1"}},
+      &Request{Method: http.MethodPost, Path: Path{"test", "integer"}, Body: (*json.RawMessage)(&integer)},
+      &Request{Method: http.MethodGet, Path: Path{"streams"}},
+    },
+  }
+
+  output, err := ServeJSON(req, mirror)
+
+  var responses []*json.RawMessage
+
+  if err != nil {
+    t.Fatal(err)
+  } else if output == nil {
+    t.Fatalf("output is nil")
+  } else if err = json.Unmarshal(*output, &responses); err != nil {
+    t.Fatal(err)
+  } else if len(responses) != 4 || responses[1] != nil || string(*responses[2]) != "7" {
+    t.Errorf("unexpected combined response `%s`", *output)
+  }
+
+  if len(mirror.Streams) != 1 || mirror.Streams[0].URL != "rtsp://garage" || mirror.Test.Integer != 7 {
+    t.Errorf("expected every request to be applied, got %#v", mirror)
+  }
+  if req.Batch[3].Response == nil || !strings.Contains(string(*req.Batch[3].Response), "garage") {
+    t.Errorf("expected batched GET to see earlier changes, got %v", req.Batch[3].Response)
+  }
+}
+
+func TestServeJSONBatchAtomic(t *testing.T) {
+  mirror := newTestMirror()
+  body := []byte(`[
+    {"method": "POST", "path": ["0", "url"], "body": "rtsp://garage"},
+    {"method": "DELETE", "path": ["1"]},
+    {"method": "DELETE", "path": ["5"]}
+  ]`)
+
+  _, err := ServeJSON(&Request{
+    Method: MethodBatch,
+    Path: Path{"streams"},
+    Body: (*json.RawMessage)(&body),
+  }, mirror)
+
+  if err == nil {
+    t.Errorf("expected out of bounds error, got none")
+  } else if len(mirror.Streams) != 2 || mirror.Streams[0].URL != "rtsp://kitchen" {
+    t.Errorf("expected batch to be rolled back, got %#v", mirror.Streams)
+  }
+
+  nested := []byte(`[{"method": "BATCH", "batch": []}]`)
+
+  _, err = ServeJSON(&Request{
+    Method: MethodBatch,
+    Body: (*json.RawMessage)(&nested),
+  }, mirror)
+
+  if err == nil {
+    t.Errorf("expected nested batch error, got none")
+  }
+}
+
+func TestServeJSONBatchValidation(t *testing.T) {
+  validated := &TestValidated{Range: TestRange{High: 10, Low: 5}}
+
+  // the low passes the high in between, but the batch is valid as a whole
+  body := []byte(`[
+    {"method": "POST", "path": ["low"], "body": 12},
+    {"method": "POST", "path": ["high"], "body": 15}
+  ]`)
+  if _, err := ServeJSON(&Request{Method: MethodBatch, Path: Path{"range"}, Body: (*json.RawMessage)(&body)}, validated); err != nil {
+    t.Error(err)
+  } else if validated.Range.Low != 12 || validated.Range.High != 15 {
+    t.Errorf("expected both changes applied, got %v", validated.Range)
+  }
+
+  body = []byte(`[
+    {"method": "POST", "path": ["high"], "body": 20},
+    {"method": "POST", "path": ["low"], "body": 30}
+  ]`)
+  _, err := ServeJSON(&Request{Method: MethodBatch, Path: Path{"range"}, Body: (*json.RawMessage)(&body)}, validated)
+  if Code(err) != CodeValidation {
+    t.Errorf("expected the batch to fail validation once applied, got %v", err)
+  } else if validated.Range.Low != 12 || validated.Range.High != 15 {
+    t.Errorf("expected the batch to be rolled back, got %v", validated.Range)
+  }
+
+  // a node changed and then taken away isn't validated
+  validated.Names = []TestName{"one", "two"}
+  body = []byte(`[
+    {"method": "POST", "path": ["names", "1"], "body": ""},
+    {"method": "DELETE", "path": ["names", "1"]}
+  ]`)
+  if _, err := ServeJSON(&Request{Method: MethodBatch, Body: (*json.RawMessage)(&body)}, validated); err != nil {
+    t.Error(err)
+  } else if len(validated.Names) != 1 {
+    t.Errorf("expected the name removed, got %v", validated.Names)
+  }
+}
+
+func TestTreeBatch(t *testing.T) {
+  tree := NewTree(newTestMirror())
+  counter := TestCounter{make(chan *Request, 4)}
+  tree.Watch(counter)
+
+  version := tree.Version(Path{"streams", "1"})
+
+  w := httptest.NewRecorder()
+  r := httptest.NewRequest("POST", "/streams", strings.NewReader(`[
+    {"method": "POST", "path": ["1", "name"], "body": "garage", "ifMatch": `+fmt.Sprint(version)+`},
+    {"method": "PUT", "path": [], "body": {"name": "shed", "url": "rtsp://shed"}}
+  ]`))
+  r.Header.Set("Content-Type", BatchType)
+  tree.ServeHTTP(w, r)
+
+  if w.Code != 200 {
+    t.Fatalf("expected 200, got %d `%s`", w.Code, w.Body.String())
+  }
+
+  req := <-counter.count
+  if req.Method != MethodBatch || len(req.Batch) != 2 {
+    t.Errorf("expected a single batch notification, got %#v", req)
+  }
+  if len(counter.count) != 0 {
+    t.Errorf("expected exactly one notification")
+  }
+
+  if v := tree.Version(Path{"streams", "1"}); v == version {
+    t.Errorf("expected batched requests to change versions")
+  }
+
+  w = httptest.NewRecorder()
+  r = httptest.NewRequest("POST", "/streams", strings.NewReader(`[
+    {"method": "POST", "path": ["0", "name"], "body": "porch"},
+    {"method": "POST", "path": ["1", "name"], "body": "garage", "ifMatch": `+fmt.Sprint(version)+`}
+  ]`))
+  r.Header.Set("Content-Type", BatchType)
+  tree.ServeHTTP(w, r)
+
+  if w.Code != http.StatusPreconditionFailed {
+    t.Errorf("expected 412 for a stale batched request, got %d `%s`", w.Code, w.Body.String())
+  }
+
+  tree.View(func(data interface{}) error {
+    if name := data.(*TestMirror).Streams[0].Name; name != "kitchen" {
+      t.Errorf("expected no part of a rejected batch to apply, got '%s'", name)
+    }
+    return nil
+  })
+}
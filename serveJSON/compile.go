@@ -0,0 +1,223 @@
+package serveJSON
+
+import (
+  "container/list"
+  "reflect"
+  "strconv"
+  "strings"
+  "sync"
+)
+
+/*
+compiledPath is a path resolved once against a type into the field indices,
+element indices and map keys that reach it, so that walking it again only
+follows them.  Paths through interfaces, raw JSON, computed values or keyed
+elements depend on the data as well as the type, and aren't compiled.
+*/
+type compiledPath struct {
+  steps []step
+}
+
+type step struct {
+  kind reflect.Kind
+  token string
+  // struct fields
+  field reflect.StructField
+  tagged bool
+  // slice and array elements
+  index int
+  // map entries
+  key reflect.Value
+}
+
+type compiledKey struct {
+  t reflect.Type
+  path string
+}
+
+// at most this many paths are kept compiled, since map keys come from requests
+const maxCompiledPaths = 4096
+
+/*
+compiledCache keeps the compiled paths used most recently, dropping the least
+recently used once it holds maxCompiledPaths of them.
+*/
+type compiledCache struct {
+  lock sync.Mutex
+  entries map[compiledKey]*list.Element
+  order *list.List
+}
+
+type compiledEntry struct {
+  key compiledKey
+  path *compiledPath
+}
+
+var compiledPaths = &compiledCache{
+  entries: make(map[compiledKey]*list.Element),
+  order: list.New(),
+}
+
+func (c *compiledCache) load(key compiledKey) (*compiledPath, bool) {
+  c.lock.Lock()
+  defer c.lock.Unlock()
+
+  e, ok := c.entries[key]
+  if !ok {
+    return nil, false
+  }
+  c.order.MoveToFront(e)
+  return e.Value.(*compiledEntry).path, true
+}
+
+func (c *compiledCache) store(key compiledKey, path *compiledPath) {
+  c.lock.Lock()
+  defer c.lock.Unlock()
+
+  if e, ok := c.entries[key]; ok {
+    c.order.MoveToFront(e)
+    return
+  }
+  c.entries[key] = c.order.PushFront(&compiledEntry{key, path})
+
+  for c.order.Len() > maxCompiledPaths {
+    oldest := c.order.Back()
+    c.order.Remove(oldest)
+    delete(c.entries, oldest.Value.(*compiledEntry).key)
+  }
+}
+
+func (c *compiledCache) count() int {
+  c.lock.Lock()
+  defer c.lock.Unlock()
+
+  return c.order.Len()
+}
+
+/*
+compiled returns the compiled path from a pointer of type t, or nil if the
+path can't be compiled and has to be walked by reflection.  Paths that don't
+compile aren't kept, as they may be missing from the type for any reason.
+*/
+func compiled(t reflect.Type, path Path) *compiledPath {
+  key := compiledKey{t, strings.Join(path, "\x00")}
+
+  if c, ok := compiledPaths.load(key); ok {
+    return c
+  }
+
+  c := compile(t, path)
+  if c != nil {
+    compiledPaths.store(key, c)
+  }
+  return c
+}
+
+func compile(t reflect.Type, path Path) *compiledPath {
+  if t.Kind() != reflect.Ptr {
+    return nil
+  }
+
+  c := &compiledPath{steps: make([]step, 0, len(path))}
+
+  for _, token := range path {
+    // pointers are followed at every step, as deref does
+    container := t.Elem()
+    for container.Kind() == reflect.Ptr {
+      container = container.Elem()
+    }
+    if container.Kind() == reflect.Interface || container == rawMessageType || computed(container) {
+      return nil
+    }
+
+    s := step{kind: container.Kind(), token: token}
+
+    switch s.kind {
+    case reflect.Struct:
+      f, err := structField(token, container)
+      if err != nil {
+        return nil
+      }
+      s.field = f
+      s.tagged = hasTag(f, ReadOnly) || hasTag(f, WriteOnly) || hasTag(f, Hidden)
+      t = reflect.PtrTo(f.Type)
+    case reflect.Slice, reflect.Array:
+      if _, keyed := keyField(container.Elem()); keyed {
+        return nil
+      }
+      i, err := strconv.Atoi(token)
+      if err != nil || i < 0 {
+        return nil
+      }
+      s.index = i
+      t = reflect.PtrTo(container.Elem())
+    case reflect.Map:
+      key, err := mapKey(token, container)
+      if err != nil {
+        return nil
+      }
+      s.key = key
+      t = reflect.PtrTo(container.Elem())
+    default:
+      return nil
+    }
+
+    c.steps = append(c.steps, s)
+  }
+  return c
+}
+
+/*
+walk follows the compiled path from pv like the reflective walk, returning an
+error without explaining it if anything along the way is missing.
+*/
+func (c *compiledPath) walk(pv reflect.Value, m mode) (reflect.Value, func(), error) {
+  create := m&modeCreate != 0
+
+  var commits []func()
+
+  commit := func() {
+    for i := len(commits) - 1; i >= 0; i-- {
+      commits[i]()
+    }
+  }
+
+  for i := range c.steps {
+    s := &c.steps[i]
+
+    var err error
+    if pv, err = deref(pv, create); err != nil {
+      return pv, commit, err
+    }
+
+    v := pv.Elem()
+
+    switch s.kind {
+    case reflect.Struct:
+      if s.tagged && m&modeInternal == 0 {
+        if err := checkAccess(s.field, s.token, m); err != nil {
+          return pv, commit, err
+        }
+      }
+      pv = v.FieldByIndex(s.field.Index)
+    case reflect.Slice, reflect.Array:
+      if s.index >= v.Len() {
+        return pv, commit, errNotCompiled
+      }
+      pv = v.Index(s.index)
+    case reflect.Map:
+      var c func()
+      if pv, c, err = mapEntry(s.key, s.token, v, create); err != nil {
+        return pv, commit, err
+      }
+      commits = append(commits, c)
+    }
+
+    if pv.CanAddr() {
+      pv = pv.Addr()
+    }
+  }
+  return pv, commit, nil
+}
+
+var errNotCompiled = newError(CodeNotFound, "compiled path not found")
@@ -0,0 +1,183 @@
+package serveJSON
+
+import (
+  "testing"
+  "net/http"
+  "encoding/json"
+  "reflect"
+  "strconv"
+  "strings"
+)
+
+type TestFace struct {
+  Name string `json:"name"`
+  Probability float32 `json:"probability"`
+}
+
+type TestFaces struct {
+  Seen *TestFace `json:"seen,omitempty"`
+  Secret string `json:"secret" serve:"hidden"`
+  Threshold float32 `json:"threshold"`
+  History []TestFace `json:"history"`
+  Counts map[string]int `json:"counts"`
+}
+
+type TestHome struct {
+  Faces TestFaces `json:"faces"`
+  Mirror *TestMirror `json:"mirror"`
+  Extra interface{} `json:"extra"`
+  Cameras []TestCamera `json:"cameras"`
+}
+
+func TestCompile(t *testing.T) {
+  resetTesters()
+  pt := reflect.TypeOf(home)
+
+  for _, c := range []struct {
+    path Path
+    compiles bool
+  }{
+    {Path{"faces", "seen", "name"}, true},
+    {Path{"faces", "history", "1", "name"}, true},
+    {Path{"faces", "history", "9"}, true},
+    {Path{"faces", "counts", "don"}, true},
+    {Path{"mirror", "streams", "0", "url"}, true},
+    {Path{}, true},
+    {Path{"faces", "missing"}, false},
+    {Path{"faces", "history", "x"}, false},
+    {Path{"faces", "threshold", "x"}, false},
+    {Path{"extra", "x"}, false},
+    {Path{"cameras", "porch"}, false},
+  } {
+    if got := compiled(pt, c.path) != nil; got != c.compiles {
+      t.Errorf("'%v': expected compiled %v, got %v", c.path, c.compiles, got)
+    }
+  }
+}
+
+func TestCompiledWalk(t *testing.T) {
+  for _, c := range []struct {
+    path Path
+    m mode
+  }{
+    {Path{"faces", "seen", "name"}, modeRead},
+    {Path{"faces", "history", "1", "name"}, modeWrite},
+    {Path{"faces", "history", "9"}, modeRead},
+    {Path{"faces", "counts", "don"}, modeRead},
+    {Path{"faces", "counts", "sam"}, modeRead},
+    {Path{"faces", "counts", "sam"}, modeWrite | modeCreate},
+    {Path{"faces", "secret"}, modeRead},
+    {Path{"faces", "secret"}, modeInternal},
+    {Path{"mirror", "streams", "0", "url"}, modeRead},
+    {Path{"extra", "x"}, modeRead},
+  } {
+    resetTesters()
+    other := home
+    resetTesters()
+    home.Faces.Seen, other.Faces.Seen = nil, nil
+
+    pe, commit, err := walk(c.path, reflect.ValueOf(home), c.m)
+    expected, expectedCommit, expectedErr := walkReflect(c.path, reflect.ValueOf(other), c.m)
+
+    if Code(err) != Code(expectedErr) {
+      t.Errorf("'%v': expected %v, got %v", c.path, expectedErr, err)
+      continue
+    } else if err != nil {
+      continue
+    }
+
+    commit()
+    expectedCommit()
+
+    if !reflect.DeepEqual(pe.Interface(), expected.Interface()) || !reflect.DeepEqual(home, other) {
+      t.Errorf("'%v': expected %#v, got %#v", c.path, expected.Interface(), pe.Interface())
+    }
+  }
+
+  resetTesters()
+  if _, _, err := walk(Path{"faces", "seen", "name"}, reflect.ValueOf(home), modeWrite|modeCreate); err != nil || home.Faces.Seen == nil {
+    t.Errorf("expected nil pointers to be created, got %v", err)
+  }
+}
+
+func BenchmarkWalkReflect(b *testing.B) {
+  resetTesters()
+  pv := reflect.ValueOf(home)
+  path := Path{"mirror", "streams", "1", "url"}
+
+  b.ReportAllocs()
+  for i := 0; i < b.N; i++ {
+    if _, _, err := walkReflect(path, pv, modeRead); err != nil {
+      b.Fatal(err)
+    }
+  }
+}
+
+func BenchmarkWalkCompiled(b *testing.B) {
+  resetTesters()
+  pv := reflect.ValueOf(home)
+  path := Path{"mirror", "streams", "1", "url"}
+
+  b.ReportAllocs()
+  for i := 0; i < b.N; i++ {
+    if _, _, err := walk(path, pv, modeRead); err != nil {
+      b.Fatal(err)
+    }
+  }
+}
+
+func BenchmarkWalkCompiledParallel(b *testing.B) {
+  resetTesters()
+  pv := reflect.ValueOf(home)
+  path := Path{"faces", "seen", "probability"}
+
+  b.ReportAllocs()
+  b.RunParallel(func(pb *testing.PB) {
+    for pb.Next() {
+      if _, _, err := walk(path, pv, modeRead); err != nil {
+        b.Fatal(err)
+      }
+    }
+  })
+}
+
+func BenchmarkServeJSONPost(b *testing.B) {
+  resetTesters()
+  body := json.RawMessage(`0.75`)
+
+  b.ReportAllocs()
+  for i := 0; i < b.N; i++ {
+    req := &Request{Method: http.MethodPost, Path: Path{"faces", "seen", "probability"}, Body: &body}
+    if _, err := ServeJSON(req, home); err != nil {
+      b.Fatal(err)
+    }
+  }
+}
+
+func TestCompiledEviction(t *testing.T) {
+  pt := reflect.TypeOf(home)
+
+  if compiled(pt, Path{"faces", "missing", "field"}) != nil {
+    t.Fatal("expected the missing field not to compile")
+  }
+  if _, ok := compiledPaths.load(compiledKey{pt, "faces\x00missing\x00field"}); ok {
+    t.Errorf("expected the failed compile not to be kept")
+  }
+
+  // every map key is a new path, yet the cache stays bounded
+  kept := Path{"faces", "seen", "name"}
+  for i := 0; i < maxCompiledPaths+100; i++ {
+    compiled(pt, kept)
+    compiled(pt, Path{"faces", "counts", strconv.Itoa(i)})
+  }
+
+  if n := compiledPaths.count(); n > maxCompiledPaths {
+    t.Errorf("expected at most %d compiled paths, got %d", maxCompiledPaths, n)
+  }
+  if _, ok := compiledPaths.load(compiledKey{pt, "faces\x00counts\x000"}); ok {
+    t.Errorf("expected the least recently used path to be dropped")
+  }
+  if _, ok := compiledPaths.load(compiledKey{pt, strings.Join(kept, "\x00")}); !ok {
+    t.Errorf("expected the path used throughout to be kept")
+  }
+}
@@ -0,0 +1,207 @@
+package serveJSON
+
+import (
+  "bytes"
+  "encoding/json"
+  "net/http"
+  "reflect"
+  "time"
+)
+
+/*
+Getter is implemented by nodes whose value is computed when it is read rather
+than stored, such as the current time.  The result of Get is marshalled in
+place of the node, and paths below the node read into the result.
+*/
+type Getter interface {
+  Get() (interface{}, error)
+}
+
+/*
+Setter is implemented by computed nodes that accept a POST, whose body is
+passed to Set instead of being decoded into the node.  Computed nodes refuse
+every other method, and without a Setter are read only.  Whatever Set changes lies outside the tree, so it is
+not rolled back if a later part of the request fails.
+*/
+type Setter interface {
+  Set(body json.RawMessage) error
+}
+
+var getterType = reflect.TypeOf((*Getter)(nil)).Elem()
+
+// computed reports whether values of type t, or pointers to them, are Getters
+func computed(t reflect.Type) bool {
+  return t.Implements(getterType) || (t.Kind() != reflect.Ptr && reflect.PtrTo(t).Implements(getterType))
+}
+
+// getter returns the Getter implemented by v or its address, if any
+func getter(v reflect.Value) (Getter, bool) {
+  if !v.IsValid() || !v.CanInterface() {
+    return nil, false
+  }
+  if v.Type().Implements(getterType) {
+    if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+      return nil, false
+    }
+    return v.Interface().(Getter), true
+  }
+  if v.CanAddr() && v.Addr().Type().Implements(getterType) {
+    return v.Addr().Interface().(Getter), true
+  }
+  return nil, false
+}
+
+// getValue calls Get and returns a pointer to a copy of the computed value
+func getValue(g Getter) (reflect.Value, error) {
+  value, err := g.Get()
+  if err != nil {
+    return reflect.Value{}, err
+  }
+  if value == nil {
+    return reflect.Value{}, newError(CodeNotFound, "computed value is nil")
+  }
+
+  pv := reflect.New(reflect.TypeOf(value))
+  pv.Elem().Set(reflect.ValueOf(value))
+  return pv, nil
+}
+
+var computedCache = make(map[reflect.Type]bool)
+
+// holdsComputed reports whether values of type t may contain computed nodes
+func holdsComputed(t reflect.Type) bool {
+  accessCacheLock.Lock()
+  defer accessCacheLock.Unlock()
+
+  return holds(t)
+}
+
+func holds(t reflect.Type) bool {
+  if held, ok := computedCache[t]; ok {
+    return held
+  }
+  computedCache[t] = false
+
+  held := false
+  switch t.Kind() {
+  case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+    held = computed(t.Elem()) || holds(t.Elem())
+  case reflect.Struct:
+    for i := 0; i < t.NumField() && !held; i++ {
+      held = computed(t.Field(i).Type) || holds(t.Field(i).Type)
+    }
+  }
+
+  computedCache[t] = held
+  return held
+}
+
+/*
+decode unmarshals a request body into the value pointed to by pv.  Clients
+often send back what a GET gave them, so the values of computed nodes in the
+body are skipped rather than decoded, much as read only fields are put back.
+*/
+func decode(body []byte, pv reflect.Value) error {
+  if holdsComputed(pv.Type().Elem()) {
+    body = skipComputed(body, pv.Type().Elem())
+  }
+  return json.Unmarshal(body, pv.Interface())
+}
+
+// skipComputed removes the values of computed nodes beneath type t from body
+func skipComputed(body []byte, t reflect.Type) []byte {
+  for t.Kind() == reflect.Ptr {
+    t = t.Elem()
+  }
+  if !holdsComputed(t) {
+    return body
+  }
+
+  switch t.Kind() {
+  case reflect.Struct:
+    var fields map[string]json.RawMessage
+    if json.Unmarshal(body, &fields) != nil {
+      return body
+    }
+    for name, raw := range fields {
+      if f, err := structField(name, t); err != nil {
+        continue
+      } else if computed(f.Type) {
+        delete(fields, name)
+      } else {
+        fields[name] = skipComputed(raw, f.Type)
+      }
+    }
+    if b, err := json.Marshal(fields); err == nil {
+      return b
+    }
+  case reflect.Map:
+    var entries map[string]json.RawMessage
+    if computed(t.Elem()) || json.Unmarshal(body, &entries) != nil {
+      return body
+    }
+    for key, raw := range entries {
+      entries[key] = skipComputed(raw, t.Elem())
+    }
+    if b, err := json.Marshal(entries); err == nil {
+      return b
+    }
+  case reflect.Slice, reflect.Array:
+    var items []json.RawMessage
+    if computed(t.Elem()) || json.Unmarshal(body, &items) != nil {
+      return body
+    }
+    for i, raw := range items {
+      items[i] = skipComputed(raw, t.Elem())
+    }
+    if b, err := json.Marshal(items); err == nil {
+      return b
+    }
+  }
+  return body
+}
+
+// setComputed passes the body of a POST to the computed node g, refusing other methods
+func setComputed(r *Request, g Getter) error {
+  setter, ok := g.(Setter)
+  if !ok || r.Method != http.MethodPost {
+    return newError(CodeMethodNotAllowed, "cannot %s computed value of type '%T'", r.Method, g)
+  }
+  if r.Body == nil || len(*r.Body) == 0 {
+    return newError(CodeBadRequest, "body is empty")
+  }
+  return wrapError(CodeBadRequest, setter.Set(*r.Body))
+}
+
+/*
+Push serves a GET of path every interval and passes it on to the watchers
+whenever the response has changed, so subscribers see computed values change
+without asking.  Call the returned function to stop pushing.
+*/
+func (t *Tree) Push(path Path, interval time.Duration) (stop func()) {
+  done := make(chan struct{})
+  ticker := time.NewTicker(interval)
+
+  go func() {
+    defer ticker.Stop()
+
+    var last []byte
+    for {
+      select {
+      case <-done:
+        return
+      case <-ticker.C:
+      }
+
+      req := &Request{Method: http.MethodGet, Path: path}
+      t.serve(req)
+      if req.Error != nil || req.Response == nil || bytes.Equal(*req.Response, last) {
+        continue
+      }
+      last = *req.Response
+      t.notify(req)
+    }
+  }()
+
+  return func() { close(done) }
+}
@@ -0,0 +1,179 @@
+package serveJSON
+
+import (
+  "testing"
+  "net/http"
+  "encoding/json"
+  "fmt"
+  "sync"
+  "time"
+)
+
+type TestUptime struct {
+  Seconds int `json:"seconds"`
+  Load []float64 `json:"load"`
+}
+
+type TestSystem struct {
+  lock sync.Mutex
+  calls int
+}
+
+func (s *TestSystem) Get() (interface{}, error) {
+  s.lock.Lock()
+  defer s.lock.Unlock()
+
+  s.calls++
+  return TestUptime{Seconds: 60 * s.calls, Load: []float64{0.5, 0.25}}, nil
+}
+
+type TestDimmer struct {
+  level int
+}
+
+func (d *TestDimmer) Get() (interface{}, error) {
+  return d.level, nil
+}
+
+func (d *TestDimmer) Set(body json.RawMessage) error {
+  var level int
+  if err := json.Unmarshal(body, &level); err != nil {
+    return err
+  }
+  if level < 0 || level > 100 {
+    return fmt.Errorf("level %d out of range", level)
+  }
+  d.level = level
+  return nil
+}
+
+type TestRoom struct {
+  Name string `json:"name"`
+  System TestSystem `json:"system"`
+  Dimmer TestDimmer `json:"dimmer"`
+}
+
+func TestServeJSONComputed(t *testing.T) {
+  room := &TestRoom{Name: "hall", Dimmer: TestDimmer{level: 40}}
+
+  for _, c := range []struct {
+    path Path
+    expected string
+  }{
+    {Path{"system"}, `{"seconds":60,"load":[0.5,0.25]}`},
+    {Path{"system", "seconds"}, `120`},
+    {Path{"system", "load", "1"}, `0.25`},
+    {Path{}, `{"name":"hall","system":{"seconds":240,"load":[0.5,0.25]},"dimmer":40}`},
+  } {
+    if output, err := ServeJSON(&Request{Method: http.MethodGet, Path: c.path}, room); err != nil {
+      t.Errorf("'%v': %v", c.path, err)
+    } else if string(*output) != c.expected {
+      t.Errorf("'%v': expected `%s`, got `%s`", c.path, c.expected, *output)
+    }
+  }
+
+  if output, err := ServeJSON(&Request{Method: http.MethodPost, Path: Path{"dimmer"}, Body: rawBody(`75`)}, room); err != nil {
+    t.Error(err)
+  } else if string(*output) != `75` || room.Dimmer.level != 75 {
+    t.Errorf("expected the setter to be called, got `%s` and %d", *output, room.Dimmer.level)
+  }
+
+  for _, c := range []struct {
+    method string
+    path Path
+    body string
+    code ErrorCode
+  }{
+    {http.MethodPost, Path{"dimmer"}, `101`, CodeBadRequest},
+    {http.MethodPost, Path{"dimmer"}, `"dim"`, CodeBadRequest},
+    {http.MethodPatch, Path{"dimmer"}, `50`, CodeMethodNotAllowed},
+    {http.MethodPost, Path{"system"}, `{"seconds": 1}`, CodeMethodNotAllowed},
+    {http.MethodPost, Path{"system", "seconds"}, `1`, CodeMethodNotAllowed},
+    {http.MethodDelete, Path{"system"}, ``, CodeMethodNotAllowed},
+    {http.MethodDelete, Path{"dimmer"}, ``, CodeMethodNotAllowed},
+    {http.MethodPut, Path{"system"}, `{"seconds": 1}`, CodeMethodNotAllowed},
+    {http.MethodPut, Path{"dimmer"}, `50`, CodeMethodNotAllowed},
+    {MethodMove, Path{"system"}, `0`, CodeMethodNotAllowed},
+    {http.MethodPatch, Path{"system"}, `{"seconds": 1}`, CodeMethodNotAllowed},
+  } {
+    if _, err := ServeJSON(&Request{Method: c.method, Path: c.path, Body: rawBody(c.body)}, room); Code(err) != c.code {
+      t.Errorf("%s '%v': expected %s, got %v", c.method, c.path, c.code, err)
+    }
+  }
+  if room.Dimmer.level != 75 {
+    t.Errorf("expected failed requests to leave the dimmer alone, got %d", room.Dimmer.level)
+  }
+}
+
+func TestComputedSchema(t *testing.T) {
+  schema, err := JSONSchema(&TestRoom{}, Path{})
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  if s := schema.Properties["dimmer"]; s == nil || s.Type[0] != "integer" || s.ReadOnly {
+    t.Errorf("expected a writable integer dimmer, got %#v", s)
+  }
+  if s := schema.Properties["system"]; s == nil || s.Properties["seconds"] == nil || !s.ReadOnly {
+    t.Errorf("expected a read only system object, got %#v", s)
+  }
+}
+
+func TestComputedRoundTrip(t *testing.T) {
+  room := &TestRoom{Name: "hall", Dimmer: TestDimmer{level: 40}}
+  rooms := &[]TestRoom{{Name: "attic"}}
+
+  for _, c := range []struct {
+    method string
+    contentType string
+    data interface{}
+  }{
+    {http.MethodPost, "", room},
+    {http.MethodPatch, "", room},
+    {http.MethodPost, "", rooms},
+    {http.MethodPatch, JSONPatchType, room},
+  } {
+    got, err := ServeJSON(&Request{Method: http.MethodGet}, c.data)
+    if err != nil {
+      t.Fatal(err)
+    }
+
+    // what a GET gives back is sent back again, computed values and all
+    body := *got
+    if c.contentType == JSONPatchType {
+      body = json.RawMessage(`[{"op":"replace","path":"","value":` + string(body) + `}]`)
+    }
+    req := &Request{Method: c.method, ContentType: c.contentType, Body: &body}
+    if _, err := ServeJSON(req, c.data); err != nil {
+      t.Errorf("%s %s %T: %v", c.method, c.contentType, c.data, err)
+    }
+  }
+
+  if room.Name != "hall" || room.Dimmer.level != 40 {
+    t.Errorf("expected the room to be unchanged, got %#v", room)
+  }
+}
+
+func TestTreePush(t *testing.T) {
+  room := &TestRoom{Name: "hall", Dimmer: TestDimmer{level: 40}}
+  tree := NewTree(room)
+  counter := TestCounter{make(chan *Request, 10)}
+  tree.Watch(counter)
+
+  stop := tree.Push(Path{"system", "seconds"}, time.Millisecond)
+  first, second := <-counter.count, <-counter.count
+  stop()
+
+  if first.Method != http.MethodGet || first.Response == nil || string(*first.Response) == string(*second.Response) {
+    t.Errorf("expected changing values to be pushed, got %v and %v", first, second)
+  }
+
+  stop = tree.Push(Path{"dimmer"}, time.Millisecond)
+  <-counter.count
+  time.Sleep(20 * time.Millisecond)
+  stop()
+
+  if n := len(counter.count); n != 0 {
+    t.Errorf("expected unchanged values to be pushed once, got %d more", n)
+  }
+}
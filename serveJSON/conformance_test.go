@@ -0,0 +1,232 @@
+package serveJSON
+
+import (
+  "testing"
+  "net/http"
+  "encoding/json"
+  "fmt"
+  "reflect"
+)
+
+type TestItem struct {
+  Label string `json:"label"`
+  Count int `json:"count"`
+  Tags []string `json:"tags,omitempty"`
+}
+
+type TestInventory struct {
+  Owner *TestItem `json:"owner"`
+  Items []TestItem `json:"items"`
+  Pointers []*TestItem `json:"pointers"`
+  Counts map[string]int `json:"counts"`
+  Shelves map[string]*TestItem `json:"shelves"`
+  Bins map[string][]TestItem `json:"bins"`
+  Nested *TestStruct2 `json:"nested,omitempty"`
+  Checked TestRange `json:"checked"`
+  Hidden string `json:"hidden" serve:"hidden"`
+  Extra interface{} `json:"extra"`
+  note string
+}
+
+func newTestInventory() *TestInventory {
+  return &TestInventory{
+    Owner: &TestItem{Label: "don"},
+    Items: []TestItem{TestItem{Label: "a", Count: 1}, TestItem{Label: "b", Count: 2, Tags: []string{"x"}}},
+    Pointers: []*TestItem{&TestItem{Label: "p"}, nil},
+    Counts: map[string]int{"a": 1},
+    Shelves: map[string]*TestItem{"top": &TestItem{Label: "t"}, "empty": nil},
+    Bins: map[string][]TestItem{"left": []TestItem{TestItem{Label: "l"}}},
+    Checked: TestRange{Low: 1, High: 2},
+    Hidden: "secret",
+    Extra: map[string]interface{}{"x": 1.},
+  }
+}
+
+var (
+  _ Dispatcher = (*TestStruct)(nil)
+  _ Dispatcher = (*TestStruct2)(nil)
+  _ Dispatcher = (*TestMirror)(nil)
+  _ Dispatcher = (*TestInventory)(nil)
+)
+
+// the cases of serveJSON_test.go, which use the shared testers
+var serveJSONCases = map[string]func(*testing.T){
+  "GET": TestServeJSONGET,
+  "GETExtra": TestServeJSONGETExtra,
+  "GETName": TestServeJSONGETName,
+  "UnknownField": TestServeJSONUnknownField,
+  "PUT": TestServeJSONPUT,
+  "Delete": TestServeJSONDelete,
+  "POST": TestServeJSONPOST,
+  "POSTInvalidJSON": TestServeJSONPOSTInvalidJSON,
+  "POSTNonPointer": TestServeJSONPOSTNonPointer,
+  "POSTEmptyBody": TestServeJSONPOSTEmptyBody,
+  "Patch": TestServeJSONPatch,
+  "PatchMap": TestServeJSONPatchMap,
+  "PatchErrors": TestServeJSONPatchErrors,
+  "InvalidMethod": TestServeJSONInvalidMethod,
+  "Map": TestServeJSONMap,
+  "MapPOST": TestServeJSONMapPOST,
+  "MapDelete": TestServeJSONMapDelete,
+  "NilPointer": TestServeJSONNilPointer,
+  "NilMapOfPointers": TestServeJSONNilMapOfPointers,
+  "Dynamic": TestServeJSONDynamic,
+  "DeleteReset": TestServeJSONDeleteReset,
+}
+
+func TestConformance(t *testing.T) {
+  defer func(generated bool) {
+    dispatchGenerated = generated
+    resetTesters()
+  }(dispatchGenerated)
+
+  for _, generated := range []bool{false, true} {
+    dispatchGenerated = generated
+
+    name := "reflect"
+    if generated {
+      name = "generated"
+    }
+    t.Run(name, func(t *testing.T) {
+      for name, test := range serveJSONCases {
+        resetTesters()
+        t.Run(name, test)
+      }
+    })
+  }
+}
+
+// serveBoth serves the request against data from fresh with each implementation
+func serveBoth(t *testing.T, fresh func() interface{}, req Request) {
+  t.Helper()
+
+  var results [2]struct {
+    data interface{}
+    res *json.RawMessage
+    err error
+    req Request
+  }
+
+  defer func(generated bool) { dispatchGenerated = generated }(dispatchGenerated)
+
+  for i, generated := range []bool{false, true} {
+    dispatchGenerated = generated
+
+    r := &results[i]
+    r.data, r.req = fresh(), req
+    r.res, r.err = ServeJSON(&r.req, r.data)
+  }
+
+  reflected, generated := results[0], results[1]
+  name := fmt.Sprintf("%s '%v'", req.Method, req.Path)
+
+  if Code(reflected.err) != Code(generated.err) {
+    t.Errorf("%s: expected error %v, got %v", name, reflected.err, generated.err)
+  }
+  if raw(reflected.res) != raw(generated.res) {
+    t.Errorf("%s: expected `%s`, got `%s`", name, raw(reflected.res), raw(generated.res))
+  }
+  if raw(reflected.req.Removed) != raw(generated.req.Removed) {
+    t.Errorf("%s: expected removed `%s`, got `%s`", name, raw(reflected.req.Removed), raw(generated.req.Removed))
+  }
+  if reflected.err == nil && !reflect.DeepEqual(reflected.req.changed, generated.req.changed) {
+    t.Errorf("%s: expected changed %v, got %v", name, reflected.req.changed, generated.req.changed)
+  }
+  if !reflect.DeepEqual(reflected.data, generated.data) {
+    t.Errorf("%s: expected data %#v, got %#v", name, reflected.data, generated.data)
+  }
+}
+
+func raw(r *json.RawMessage) string {
+  if r == nil {
+    return "<nil>"
+  }
+  return string(*r)
+}
+
+func TestConformanceDifferential(t *testing.T) {
+  inventory := func() interface{} { return newTestInventory() }
+  mirror := func() interface{} { return newTestMirror() }
+  body := func(s string) *json.RawMessage {
+    b := json.RawMessage(s)
+    return &b
+  }
+
+  for _, c := range []struct {
+    fresh func() interface{}
+    req Request
+  }{
+    {inventory, Request{Method: http.MethodGet, Path: Path{"owner"}}},
+    {inventory, Request{Method: http.MethodGet, Path: Path{"owner", "label"}}},
+    {inventory, Request{Method: http.MethodGet, Path: Path{"owner", "label", "x"}}},
+    {inventory, Request{Method: http.MethodGet, Path: Path{"items", "1", "tags", "0"}}},
+    {inventory, Request{Method: http.MethodGet, Path: Path{"items", "2"}}},
+    {inventory, Request{Method: http.MethodGet, Path: Path{"items", "x"}}},
+    {inventory, Request{Method: http.MethodGet, Path: Path{"pointers", "1"}}},
+    {inventory, Request{Method: http.MethodGet, Path: Path{"pointers", "1", "label"}}},
+    {inventory, Request{Method: http.MethodGet, Path: Path{"counts"}}},
+    {inventory, Request{Method: http.MethodGet, Path: Path{"counts", "b"}}},
+    {inventory, Request{Method: http.MethodGet, Path: Path{"shelves", "empty"}}},
+    {inventory, Request{Method: http.MethodGet, Path: Path{"shelves", "empty", "label"}}},
+    {inventory, Request{Method: http.MethodGet, Path: Path{"bins", "left", "0", "label"}}},
+    {inventory, Request{Method: http.MethodGet, Path: Path{"nested", "test"}}},
+    {inventory, Request{Method: http.MethodGet, Path: Path{"checked"}}},
+    {inventory, Request{Method: http.MethodGet, Path: Path{"hidden"}}},
+    {inventory, Request{Method: http.MethodGet, Path: Path{"extra", "x"}}},
+    {inventory, Request{Method: http.MethodGet, Path: Path{"note"}}},
+    {inventory, Request{Method: http.MethodGet, Path: Path{"items"}, Options: &Options{Depth: 1}}},
+    {inventory, Request{Method: http.MethodGet, Path: Path{}}},
+
+    {inventory, Request{Method: http.MethodPost, Path: Path{"owner", "count"}, Body: body(`3`)}},
+    {inventory, Request{Method: http.MethodPost, Path: Path{"owner"}, Body: body(`{"count":3}`)}},
+    {inventory, Request{Method: http.MethodPost, Path: Path{"owner"}, Body: body(`{"count":"x"}`)}},
+    {inventory, Request{Method: http.MethodPost, Path: Path{"owner"}}},
+    {inventory, Request{Method: http.MethodPost, Path: Path{"nested", "test", "integer"}, Body: body(`7`)}},
+    {inventory, Request{Method: http.MethodPost, Path: Path{"nested", "test", "integer"}, Body: body(`"x"`)}},
+    {inventory, Request{Method: http.MethodPost, Path: Path{"pointers", "1", "label"}, Body: body(`"q"`)}},
+    {inventory, Request{Method: http.MethodPost, Path: Path{"counts", "b"}, Body: body(`2`)}},
+    {inventory, Request{Method: http.MethodPost, Path: Path{"counts"}, Body: body(`{"c":3}`)}},
+    {inventory, Request{Method: http.MethodPost, Path: Path{"shelves", "new", "label"}, Body: body(`"n"`)}},
+    {inventory, Request{Method: http.MethodPost, Path: Path{"bins", "right"}, Body: body(`[{"label":"r"}]`)}},
+    {inventory, Request{Method: http.MethodPost, Path: Path{"items", "0"}, Body: body(`{"tags":["y"]}`)}},
+    {inventory, Request{Method: http.MethodPost, Path: Path{"items", "5", "label"}, Body: body(`"z"`)}},
+    {inventory, Request{Method: http.MethodPost, Path: Path{"checked", "low"}, Body: body(`5`)}},
+    {inventory, Request{Method: http.MethodPost, Path: Path{"hidden"}, Body: body(`"x"`)}},
+    {inventory, Request{Method: http.MethodPost, Path: Path{"missing"}, Body: body(`1`)}},
+
+    {inventory, Request{Method: http.MethodPut, Path: Path{"items"}, Body: body(`{"label":"c"}`)}},
+    {inventory, Request{Method: http.MethodPut, Path: Path{"items", "0"}, Body: body(`{"label":"c"}`)}},
+    {inventory, Request{Method: http.MethodPut, Path: Path{"items", "2"}, Body: body(`{"label":"c"}`)}},
+    {inventory, Request{Method: http.MethodPut, Path: Path{"items", "-"}, Body: body(`{"label":"c"}`)}},
+    {inventory, Request{Method: http.MethodPut, Path: Path{"items", "3"}, Body: body(`{"label":"c"}`)}},
+    {inventory, Request{Method: http.MethodPut, Path: Path{"items"}, Body: body(`[]`)}},
+    {inventory, Request{Method: http.MethodPut, Path: Path{"items"}}},
+    {inventory, Request{Method: http.MethodPut, Path: Path{"items", "1", "tags", "0"}, Body: body(`"w"`)}},
+    {inventory, Request{Method: http.MethodPut, Path: Path{"pointers"}, Body: body(`null`)}},
+    {inventory, Request{Method: http.MethodPut, Path: Path{"bins", "left"}, Body: body(`{"label":"m"}`)}},
+    {inventory, Request{Method: http.MethodPut, Path: Path{"bins", "new"}, Body: body(`{"label":"m"}`)}},
+    {inventory, Request{Method: http.MethodPut, Path: Path{"owner", "label"}, Body: body(`"x"`)}},
+    {inventory, Request{Method: http.MethodPut, Path: Path{"counts"}, Body: body(`1`)}},
+
+    {inventory, Request{Method: http.MethodDelete, Path: Path{"items", "0"}}},
+    {inventory, Request{Method: http.MethodDelete, Path: Path{"items", "2"}}},
+    {inventory, Request{Method: http.MethodDelete, Path: Path{"items", "1", "tags"}}},
+    {inventory, Request{Method: http.MethodDelete, Path: Path{"items", "1", "missing"}}},
+    {inventory, Request{Method: http.MethodDelete, Path: Path{"pointers", "1"}}},
+    {inventory, Request{Method: http.MethodDelete, Path: Path{"counts", "a"}}},
+    {inventory, Request{Method: http.MethodDelete, Path: Path{"counts", "z"}}},
+    {inventory, Request{Method: http.MethodDelete, Path: Path{"shelves", "empty", "label"}}},
+    {inventory, Request{Method: http.MethodDelete, Path: Path{"owner", "label", "x"}}},
+    {inventory, Request{Method: http.MethodDelete, Path: Path{"owner"}}},
+    {inventory, Request{Method: http.MethodDelete, Path: Path{"checked", "low"}}},
+
+    {mirror, Request{Method: http.MethodGet, Path: Path{"streams", "1", "url"}}},
+    {mirror, Request{Method: http.MethodPost, Path: Path{"test"}, Body: body(`{"array":["a"]}`)}},
+    {mirror, Request{Method: http.MethodPut, Path: Path{"test", "array", "0"}, Body: body(`"b"`)}},
+    {mirror, Request{Method: http.MethodDelete, Path: Path{"streams", "0"}}},
+    {mirror, Request{Method: http.MethodDelete, Path: Path{"test"}}},
+    {mirror, Request{Method: http.MethodPatch, Path: Path{"test"}, Body: body(`{"integer":1}`)}},
+  } {
+    serveBoth(t, c.fresh, c.req)
+  }
+}
@@ -0,0 +1,56 @@
+package serveJSON
+
+import (
+  "reflect"
+  "strconv"
+  "strings"
+)
+
+/*
+constraints are the rules given by a `validate` struct tag, such as
+`validate:"required,min=0,max=1"` or `validate:"enum=on|standby"`.  min and
+max bound numbers, and the length of strings, slices and maps.  They only
+describe the field in the generated JSON Schema; values are checked by
+Validators alone.
+*/
+type constraints struct {
+  required bool
+  min *float64
+  max *float64
+  enum []string
+}
+
+func parseConstraints(f reflect.StructField) (c constraints, err error) {
+  tag, ok := f.Tag.Lookup("validate")
+  if !ok {
+    return
+  }
+
+  for _, o := range strings.Split(tag, ",") {
+    key, value := strings.TrimSpace(o), ""
+    if i := strings.Index(key, "="); i >= 0 {
+      key, value = key[:i], key[i+1:]
+    }
+
+    switch key {
+    case "":
+    case "required":
+      c.required = true
+    case "min", "max":
+      n, err := strconv.ParseFloat(value, 64)
+      if err != nil {
+        return c, newError(CodeInternal, "invalid %s in validate tag of '%s': %v", key, f.Name, err)
+      }
+      if key == "min" {
+        c.min = &n
+      } else {
+        c.max = &n
+      }
+    case "enum":
+      c.enum = strings.Split(value, "|")
+    default:
+      return c, newError(CodeInternal, "unknown option '%s' in validate tag of '%s'", key, f.Name)
+    }
+  }
+  return
+}
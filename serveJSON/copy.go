@@ -0,0 +1,122 @@
+package serveJSON
+
+import (
+  "reflect"
+)
+
+/*
+deepCopy returns a copy of v that shares no pointers, slices or maps with the
+original.  Unexported struct fields are copied shallowly.
+*/
+func deepCopy(v reflect.Value) reflect.Value {
+  c := reflect.New(v.Type()).Elem()
+
+  switch v.Kind() {
+  case reflect.Ptr:
+    if !v.IsNil() {
+      p := reflect.New(v.Type().Elem())
+      p.Elem().Set(deepCopy(v.Elem()))
+      c.Set(p)
+    }
+  case reflect.Interface:
+    if !v.IsNil() {
+      c.Set(deepCopy(v.Elem()))
+    }
+  case reflect.Struct:
+    c.Set(v)
+    copyFields(c, v)
+  case reflect.Array:
+    for i := 0; i < v.Len(); i++ {
+      c.Index(i).Set(deepCopy(v.Index(i)))
+    }
+  case reflect.Slice:
+    if !v.IsNil() {
+      c.Set(reflect.MakeSlice(v.Type(), v.Len(), v.Len()))
+      for i := 0; i < v.Len(); i++ {
+        c.Index(i).Set(deepCopy(v.Index(i)))
+      }
+    }
+  case reflect.Map:
+    if !v.IsNil() {
+      c.Set(reflect.MakeMapWithSize(v.Type(), v.Len()))
+      for _, k := range v.MapKeys() {
+        c.SetMapIndex(k, deepCopy(v.MapIndex(k)))
+      }
+    }
+  default:
+    c.Set(v)
+  }
+  return c
+}
+
+// copyFields deep copies the fields of the struct v that can be set into c
+func copyFields(c, v reflect.Value) {
+  for i := 0; i < v.NumField(); i++ {
+    if f := c.Field(i); f.CanSet() {
+      f.Set(deepCopy(v.Field(i)))
+    } else if promoted(v.Type().Field(i)) {
+      // those promoted from unexported embedded structs are still settable
+      copyFields(f, v.Field(i))
+    }
+  }
+}
+
+/*
+restore copies snapshot back into v, reusing the pointers already in v where
+both sides are non-nil so references held elsewhere stay valid.
+*/
+func restore(v, snapshot reflect.Value) {
+  switch v.Kind() {
+  case reflect.Ptr:
+    if !v.IsNil() && !snapshot.IsNil() {
+      restore(v.Elem(), snapshot.Elem())
+      return
+    }
+  case reflect.Struct:
+    for i := 0; i < v.NumField(); i++ {
+      if !v.Field(i).CanSet() {
+        // unexported fields can only be restored with the whole struct
+        v.Set(snapshot)
+        return
+      }
+    }
+    for i := 0; i < v.NumField(); i++ {
+      restore(v.Field(i), snapshot.Field(i))
+    }
+    return
+  }
+  v.Set(snapshot)
+}
+
+/*
+atomically runs f, which may only change the value at path beneath pv, and
+puts that value back if f fails.  Only that value is copied, or the nearest
+one above it that exists if f is to create it.
+*/
+func atomically(pv reflect.Value, path Path, f func() error) error {
+  n := len(path)
+  var snapshot reflect.Value
+  for ; n > 0; n-- {
+    if pe, _, err := walk(path[:n], pv, modeInternal); err == nil {
+      snapshot = deepCopy(pe.Elem())
+      break
+    }
+  }
+  if n == 0 {
+    snapshot = deepCopy(pv.Elem())
+  }
+
+  err := f()
+  if err == nil {
+    return nil
+  }
+
+  // map entries are walked into copies, so the value is written back through commit
+  pe, commit, werr := walk(path[:n], pv, modeInternal|modeWrite)
+  if werr != nil {
+    return err
+  }
+  restore(pe.Elem(), snapshot)
+  commit()
+  return err
+}
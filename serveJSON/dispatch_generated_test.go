@@ -0,0 +1,735 @@
+// Code generated by "servejsongen -test -type TestStruct,TestStruct2,TestMirror,TestInventory -output dispatch_generated_test.go"; DO NOT EDIT.
+
+package serveJSON
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// DispatchJSON implements Dispatcher
+func (x *TestStruct) DispatchJSON(r *Request, path Path) (*json.RawMessage, error) {
+	return dispatch_TestStruct(r, path, x)
+}
+
+// DispatchJSON implements Dispatcher
+func (x *TestStruct2) DispatchJSON(r *Request, path Path) (*json.RawMessage, error) {
+	return dispatch_TestStruct2(r, path, x)
+}
+
+// DispatchJSON implements Dispatcher
+func (x *TestMirror) DispatchJSON(r *Request, path Path) (*json.RawMessage, error) {
+	return dispatch_TestMirror(r, path, x)
+}
+
+// DispatchJSON implements Dispatcher
+func (x *TestInventory) DispatchJSON(r *Request, path Path) (*json.RawMessage, error) {
+	return dispatch_TestInventory(r, path, x)
+}
+
+func dispatch_TestInventory(r *Request, path Path, v *TestInventory) (*json.RawMessage, error) {
+	if len(path) == 0 {
+		return nil, ErrNotGenerated
+	}
+	if r.Method == http.MethodDelete && len(path) == 1 {
+		return nil, ErrNotGenerated
+	}
+	switch path[0] {
+	case "owner":
+		return dispatch_ptr_TestItem(r, path[1:], &v.Owner)
+	case "items":
+		return dispatch_slice_TestItem(r, path[1:], &v.Items)
+	case "pointers":
+		return dispatch_slice_ptr_TestItem(r, path[1:], &v.Pointers)
+	case "counts":
+		return dispatch_map_string_int(r, path[1:], &v.Counts)
+	case "shelves":
+		return dispatch_map_string_ptr_TestItem(r, path[1:], &v.Shelves)
+	case "bins":
+		return dispatch_map_string_slice_TestItem(r, path[1:], &v.Bins)
+	case "nested":
+		return dispatch_ptr_TestStruct2(r, path[1:], &v.Nested)
+	case "checked":
+		return dispatch_TestRange(r, path[1:], &v.Checked)
+	case "hidden":
+		return nil, ErrNotGenerated
+	case "extra":
+		return nil, ErrNotGenerated
+	}
+	return nil, NewError(CodeNotFound, "field name not found: '%s'", path[0])
+}
+
+func dispatch_TestItem(r *Request, path Path, v *TestItem) (*json.RawMessage, error) {
+	if len(path) == 0 {
+		if r.Method == http.MethodPost {
+			c := new(TestItem)
+			if err := DecodeNode(r, v, c); err != nil {
+				return nil, err
+			}
+			*v = *c
+		}
+		return ServeNode(r, v, "struct")
+	}
+	if r.Method == http.MethodDelete && len(path) == 1 {
+		switch path[0] {
+		case "label":
+			if err := Removing(r, &v.Label); err != nil {
+				return nil, err
+			}
+			v.Label = *new(string)
+			return MarshalNode(&v.Label)
+		case "count":
+			if err := Removing(r, &v.Count); err != nil {
+				return nil, err
+			}
+			v.Count = *new(int)
+			return MarshalNode(&v.Count)
+		case "tags":
+			if err := Removing(r, &v.Tags); err != nil {
+				return nil, err
+			}
+			v.Tags = *new([]string)
+			return MarshalNode(&v.Tags)
+		}
+		return nil, NewError(CodeNotFound, "field name not found: '%s'", path[0])
+	}
+	switch path[0] {
+	case "label":
+		return dispatch_string(r, path[1:], &v.Label)
+	case "count":
+		return dispatch_int(r, path[1:], &v.Count)
+	case "tags":
+		return dispatch_slice_string(r, path[1:], &v.Tags)
+	}
+	return nil, NewError(CodeNotFound, "field name not found: '%s'", path[0])
+}
+
+func dispatch_TestMirror(r *Request, path Path, v *TestMirror) (*json.RawMessage, error) {
+	if len(path) == 0 {
+		if r.Method == http.MethodPost {
+			c := new(TestMirror)
+			if err := DecodeNode(r, v, c); err != nil {
+				return nil, err
+			}
+			*v = *c
+		}
+		return ServeNode(r, v, "struct")
+	}
+	if r.Method == http.MethodDelete && len(path) == 1 {
+		switch path[0] {
+		case "streams":
+			if err := Removing(r, &v.Streams); err != nil {
+				return nil, err
+			}
+			v.Streams = *new([]TestStream)
+			return MarshalNode(&v.Streams)
+		case "test":
+			if err := Removing(r, &v.Test); err != nil {
+				return nil, err
+			}
+			v.Test = *new(TestStruct)
+			return MarshalNode(&v.Test)
+		}
+		return nil, NewError(CodeNotFound, "field name not found: '%s'", path[0])
+	}
+	switch path[0] {
+	case "streams":
+		return dispatch_slice_TestStream(r, path[1:], &v.Streams)
+	case "test":
+		return dispatch_TestStruct(r, path[1:], &v.Test)
+	}
+	return nil, NewError(CodeNotFound, "field name not found: '%s'", path[0])
+}
+
+func dispatch_TestRange(r *Request, path Path, v *TestRange) (*json.RawMessage, error) {
+	if r.Method != http.MethodGet {
+		return nil, ErrNotGenerated
+	}
+	if len(path) == 0 {
+		return MarshalNode(v)
+	}
+	switch path[0] {
+	case "high":
+		return dispatch_int(r, path[1:], &v.High)
+	case "low":
+		return dispatch_int(r, path[1:], &v.Low)
+	}
+	return nil, NewError(CodeNotFound, "field name not found: '%s'", path[0])
+}
+
+func dispatch_TestStream(r *Request, path Path, v *TestStream) (*json.RawMessage, error) {
+	if len(path) == 0 {
+		if r.Method == http.MethodPost {
+			c := new(TestStream)
+			if err := DecodeNode(r, v, c); err != nil {
+				return nil, err
+			}
+			*v = *c
+		}
+		return ServeNode(r, v, "struct")
+	}
+	if r.Method == http.MethodDelete && len(path) == 1 {
+		switch path[0] {
+		case "name":
+			if err := Removing(r, &v.Name); err != nil {
+				return nil, err
+			}
+			v.Name = *new(string)
+			return MarshalNode(&v.Name)
+		case "url":
+			if err := Removing(r, &v.URL); err != nil {
+				return nil, err
+			}
+			v.URL = *new(string)
+			return MarshalNode(&v.URL)
+		}
+		return nil, NewError(CodeNotFound, "field name not found: '%s'", path[0])
+	}
+	switch path[0] {
+	case "name":
+		return dispatch_string(r, path[1:], &v.Name)
+	case "url":
+		return dispatch_string(r, path[1:], &v.URL)
+	}
+	return nil, NewError(CodeNotFound, "field name not found: '%s'", path[0])
+}
+
+func dispatch_TestStruct(r *Request, path Path, v *TestStruct) (*json.RawMessage, error) {
+	if len(path) == 0 {
+		if r.Method == http.MethodPost {
+			c := new(TestStruct)
+			if err := DecodeNode(r, v, c); err != nil {
+				return nil, err
+			}
+			*v = *c
+		}
+		return ServeNode(r, v, "struct")
+	}
+	if r.Method == http.MethodDelete && len(path) == 1 {
+		switch path[0] {
+		case "visible":
+			if err := Removing(r, &v.Visible); err != nil {
+				return nil, err
+			}
+			v.Visible = *new(bool)
+			return MarshalNode(&v.Visible)
+		case "integer":
+			if err := Removing(r, &v.Integer); err != nil {
+				return nil, err
+			}
+			v.Integer = *new(int)
+			return MarshalNode(&v.Integer)
+		case "array":
+			if err := Removing(r, &v.Array); err != nil {
+				return nil, err
+			}
+			v.Array = *new([]string)
+			return MarshalNode(&v.Array)
+		case "NoTag":
+			if err := Removing(r, &v.NoTag); err != nil {
+				return nil, err
+			}
+			v.NoTag = *new(bool)
+			return MarshalNode(&v.NoTag)
+		}
+		return nil, NewError(CodeNotFound, "field name not found: '%s'", path[0])
+	}
+	switch path[0] {
+	case "visible":
+		return dispatch_bool(r, path[1:], &v.Visible)
+	case "integer":
+		return dispatch_int(r, path[1:], &v.Integer)
+	case "array":
+		return dispatch_slice_string(r, path[1:], &v.Array)
+	case "NoTag":
+		return dispatch_bool(r, path[1:], &v.NoTag)
+	}
+	return nil, NewError(CodeNotFound, "field name not found: '%s'", path[0])
+}
+
+func dispatch_TestStruct2(r *Request, path Path, v *TestStruct2) (*json.RawMessage, error) {
+	if len(path) == 0 {
+		if r.Method == http.MethodPost {
+			c := new(TestStruct2)
+			if err := DecodeNode(r, v, c); err != nil {
+				return nil, err
+			}
+			*v = *c
+		}
+		return ServeNode(r, v, "struct")
+	}
+	if r.Method == http.MethodDelete && len(path) == 1 {
+		switch path[0] {
+		case "test":
+			if err := Removing(r, &v.Test); err != nil {
+				return nil, err
+			}
+			v.Test = *new(*TestStruct)
+			return MarshalNode(&v.Test)
+		}
+		return nil, NewError(CodeNotFound, "field name not found: '%s'", path[0])
+	}
+	switch path[0] {
+	case "test":
+		return dispatch_ptr_TestStruct(r, path[1:], &v.Test)
+	}
+	return nil, NewError(CodeNotFound, "field name not found: '%s'", path[0])
+}
+
+func dispatch_bool(r *Request, path Path, v *bool) (*json.RawMessage, error) {
+	if len(path) == 0 {
+		if r.Method == http.MethodPost {
+			c := new(bool)
+			if err := DecodeNode(r, v, c); err != nil {
+				return nil, err
+			}
+			*v = *c
+		}
+		return ServeNode(r, v, "bool")
+	}
+	if r.Method == http.MethodDelete && len(path) == 1 {
+		return nil, NewError(CodeMethodNotAllowed, "cannot delete from type of '%s'", "bool")
+	}
+	return nil, NewError(CodeNotFound, "path not found '%s'", path)
+}
+
+func dispatch_int(r *Request, path Path, v *int) (*json.RawMessage, error) {
+	if len(path) == 0 {
+		if r.Method == http.MethodPost {
+			c := new(int)
+			if err := DecodeNode(r, v, c); err != nil {
+				return nil, err
+			}
+			*v = *c
+		}
+		return ServeNode(r, v, "int")
+	}
+	if r.Method == http.MethodDelete && len(path) == 1 {
+		return nil, NewError(CodeMethodNotAllowed, "cannot delete from type of '%s'", "int")
+	}
+	return nil, NewError(CodeNotFound, "path not found '%s'", path)
+}
+
+func dispatch_map_string_int(r *Request, path Path, v *map[string]int) (*json.RawMessage, error) {
+	if len(path) == 0 {
+		if r.Method == http.MethodPost {
+			c := new(map[string]int)
+			if err := DecodeNode(r, v, c); err != nil {
+				return nil, err
+			}
+			*v = *c
+		}
+		return ServeNode(r, v, "map")
+	}
+	if r.Method == http.MethodDelete && len(path) == 1 {
+		e, ok := (*v)[path[0]]
+		if !ok {
+			return nil, NewError(CodeNotFound, "key not found: '%s'", path[0])
+		}
+		if err := Removing(r, &e); err != nil {
+			return nil, err
+		}
+		delete(*v, path[0])
+		return nil, nil
+	}
+	e, ok := (*v)[path[0]]
+	if !ok && (r.Method == http.MethodGet || r.Method == http.MethodDelete) {
+		return nil, NewError(CodeNotFound, "key not found: '%s'", path[0])
+	}
+	res, err := dispatch_int(r, path[1:], &e)
+	if err == nil && r.Method != http.MethodGet {
+		if *v == nil {
+			*v = make(map[string]int)
+		}
+		(*v)[path[0]] = e
+	}
+	return res, err
+}
+
+func dispatch_map_string_ptr_TestItem(r *Request, path Path, v *map[string]*TestItem) (*json.RawMessage, error) {
+	if len(path) == 0 {
+		if r.Method == http.MethodPost {
+			c := new(map[string]*TestItem)
+			if err := DecodeNode(r, v, c); err != nil {
+				return nil, err
+			}
+			*v = *c
+		}
+		return ServeNode(r, v, "map")
+	}
+	if r.Method == http.MethodDelete && len(path) == 1 {
+		e, ok := (*v)[path[0]]
+		if !ok {
+			return nil, NewError(CodeNotFound, "key not found: '%s'", path[0])
+		}
+		if err := Removing(r, &e); err != nil {
+			return nil, err
+		}
+		delete(*v, path[0])
+		return nil, nil
+	}
+	e, ok := (*v)[path[0]]
+	if !ok && (r.Method == http.MethodGet || r.Method == http.MethodDelete) {
+		return nil, NewError(CodeNotFound, "key not found: '%s'", path[0])
+	}
+	res, err := dispatch_ptr_TestItem(r, path[1:], &e)
+	if err == nil && r.Method != http.MethodGet {
+		if *v == nil {
+			*v = make(map[string]*TestItem)
+		}
+		(*v)[path[0]] = e
+	}
+	return res, err
+}
+
+func dispatch_map_string_slice_TestItem(r *Request, path Path, v *map[string][]TestItem) (*json.RawMessage, error) {
+	if len(path) == 0 {
+		if r.Method == http.MethodPost {
+			c := new(map[string][]TestItem)
+			if err := DecodeNode(r, v, c); err != nil {
+				return nil, err
+			}
+			*v = *c
+		}
+		return ServeNode(r, v, "map")
+	}
+	if r.Method == http.MethodDelete && len(path) == 1 {
+		e, ok := (*v)[path[0]]
+		if !ok {
+			return nil, NewError(CodeNotFound, "key not found: '%s'", path[0])
+		}
+		if err := Removing(r, &e); err != nil {
+			return nil, err
+		}
+		delete(*v, path[0])
+		return nil, nil
+	}
+	e, ok := (*v)[path[0]]
+	if !ok && (r.Method == http.MethodGet || r.Method == http.MethodDelete) {
+		return nil, NewError(CodeNotFound, "key not found: '%s'", path[0])
+	}
+	res, err := dispatch_slice_TestItem(r, path[1:], &e)
+	if err == nil && r.Method != http.MethodGet {
+		if *v == nil {
+			*v = make(map[string][]TestItem)
+		}
+		(*v)[path[0]] = e
+	}
+	return res, err
+}
+
+func dispatch_ptr_TestItem(r *Request, path Path, v **TestItem) (*json.RawMessage, error) {
+	if len(path) == 0 {
+		if r.Method == http.MethodPut {
+			return nil, ErrNotGenerated
+		}
+		if r.Method == http.MethodPost {
+			c := new(*TestItem)
+			if err := DecodeNode(r, v, c); err != nil {
+				return nil, err
+			}
+			*v = *c
+		}
+		return ServeNode(r, v, "ptr")
+	}
+	if *v == nil {
+		if r.Method == http.MethodGet || r.Method == http.MethodDelete {
+			return nil, NewError(CodeNotFound, "value is nil at '%s'", path)
+		}
+		// nothing is allocated unless the request succeeds
+		e := new(TestItem)
+		res, err := dispatch_TestItem(r, path, e)
+		if err == nil {
+			*v = e
+		}
+		return res, err
+	}
+	return dispatch_TestItem(r, path, *v)
+}
+
+func dispatch_ptr_TestStruct(r *Request, path Path, v **TestStruct) (*json.RawMessage, error) {
+	if len(path) == 0 {
+		if r.Method == http.MethodPut {
+			return nil, ErrNotGenerated
+		}
+		if r.Method == http.MethodPost {
+			c := new(*TestStruct)
+			if err := DecodeNode(r, v, c); err != nil {
+				return nil, err
+			}
+			*v = *c
+		}
+		return ServeNode(r, v, "ptr")
+	}
+	if *v == nil {
+		if r.Method == http.MethodGet || r.Method == http.MethodDelete {
+			return nil, NewError(CodeNotFound, "value is nil at '%s'", path)
+		}
+		// nothing is allocated unless the request succeeds
+		e := new(TestStruct)
+		res, err := dispatch_TestStruct(r, path, e)
+		if err == nil {
+			*v = e
+		}
+		return res, err
+	}
+	return dispatch_TestStruct(r, path, *v)
+}
+
+func dispatch_ptr_TestStruct2(r *Request, path Path, v **TestStruct2) (*json.RawMessage, error) {
+	if len(path) == 0 {
+		if r.Method == http.MethodPut {
+			return nil, ErrNotGenerated
+		}
+		if r.Method == http.MethodPost {
+			c := new(*TestStruct2)
+			if err := DecodeNode(r, v, c); err != nil {
+				return nil, err
+			}
+			*v = *c
+		}
+		return ServeNode(r, v, "ptr")
+	}
+	if *v == nil {
+		if r.Method == http.MethodGet || r.Method == http.MethodDelete {
+			return nil, NewError(CodeNotFound, "value is nil at '%s'", path)
+		}
+		// nothing is allocated unless the request succeeds
+		e := new(TestStruct2)
+		res, err := dispatch_TestStruct2(r, path, e)
+		if err == nil {
+			*v = e
+		}
+		return res, err
+	}
+	return dispatch_TestStruct2(r, path, *v)
+}
+
+func dispatch_slice_TestItem(r *Request, path Path, v *[]TestItem) (*json.RawMessage, error) {
+	if len(path) == 0 {
+		if r.Method == http.MethodPut {
+			item := new(TestItem)
+			i, err := InsertIndex(r, len(*v), "", item)
+			if err != nil {
+				return nil, err
+			}
+			*v = append(*v, *item)
+			copy((*v)[i+1:], (*v)[i:len(*v)-1])
+			(*v)[i] = *item
+			return Inserted(r, "", item)
+		}
+		if r.Method == http.MethodPost {
+			c := new([]TestItem)
+			if err := DecodeNode(r, v, c); err != nil {
+				return nil, err
+			}
+			*v = *c
+		}
+		return ServeNode(r, v, "slice")
+	}
+	if len(path) == 1 {
+		switch {
+		case r.Method == http.MethodPut && CanInsert(len(*v), path[0]):
+			item := new(TestItem)
+			i, err := InsertIndex(r, len(*v), path[0], item)
+			if err != nil {
+				return nil, err
+			}
+			*v = append(*v, *item)
+			copy((*v)[i+1:], (*v)[i:len(*v)-1])
+			(*v)[i] = *item
+			return Inserted(r, path[0], item)
+		case r.Method == http.MethodDelete:
+			i, err := ElementIndex(len(*v), path[0])
+			if err != nil {
+				return nil, err
+			}
+			if err := Removing(r, &(*v)[i]); err != nil {
+				return nil, err
+			}
+			*v = append((*v)[:i], (*v)[i+1:]...)
+			return nil, nil
+		}
+	}
+	i, err := ElementIndex(len(*v), path[0])
+	if err != nil {
+		return nil, err
+	}
+	return dispatch_TestItem(r, path[1:], &(*v)[i])
+}
+
+func dispatch_slice_TestStream(r *Request, path Path, v *[]TestStream) (*json.RawMessage, error) {
+	if len(path) == 0 {
+		if r.Method == http.MethodPut {
+			item := new(TestStream)
+			i, err := InsertIndex(r, len(*v), "", item)
+			if err != nil {
+				return nil, err
+			}
+			*v = append(*v, *item)
+			copy((*v)[i+1:], (*v)[i:len(*v)-1])
+			(*v)[i] = *item
+			return Inserted(r, "", item)
+		}
+		if r.Method == http.MethodPost {
+			c := new([]TestStream)
+			if err := DecodeNode(r, v, c); err != nil {
+				return nil, err
+			}
+			*v = *c
+		}
+		return ServeNode(r, v, "slice")
+	}
+	if len(path) == 1 {
+		switch {
+		case r.Method == http.MethodPut && CanInsert(len(*v), path[0]):
+			item := new(TestStream)
+			i, err := InsertIndex(r, len(*v), path[0], item)
+			if err != nil {
+				return nil, err
+			}
+			*v = append(*v, *item)
+			copy((*v)[i+1:], (*v)[i:len(*v)-1])
+			(*v)[i] = *item
+			return Inserted(r, path[0], item)
+		case r.Method == http.MethodDelete:
+			i, err := ElementIndex(len(*v), path[0])
+			if err != nil {
+				return nil, err
+			}
+			if err := Removing(r, &(*v)[i]); err != nil {
+				return nil, err
+			}
+			*v = append((*v)[:i], (*v)[i+1:]...)
+			return nil, nil
+		}
+	}
+	i, err := ElementIndex(len(*v), path[0])
+	if err != nil {
+		return nil, err
+	}
+	return dispatch_TestStream(r, path[1:], &(*v)[i])
+}
+
+func dispatch_slice_ptr_TestItem(r *Request, path Path, v *[]*TestItem) (*json.RawMessage, error) {
+	if len(path) == 0 {
+		if r.Method == http.MethodPut {
+			item := new(*TestItem)
+			i, err := InsertIndex(r, len(*v), "", item)
+			if err != nil {
+				return nil, err
+			}
+			*v = append(*v, *item)
+			copy((*v)[i+1:], (*v)[i:len(*v)-1])
+			(*v)[i] = *item
+			return Inserted(r, "", item)
+		}
+		if r.Method == http.MethodPost {
+			c := new([]*TestItem)
+			if err := DecodeNode(r, v, c); err != nil {
+				return nil, err
+			}
+			*v = *c
+		}
+		return ServeNode(r, v, "slice")
+	}
+	if len(path) == 1 {
+		switch {
+		case r.Method == http.MethodPut && CanInsert(len(*v), path[0]):
+			item := new(*TestItem)
+			i, err := InsertIndex(r, len(*v), path[0], item)
+			if err != nil {
+				return nil, err
+			}
+			*v = append(*v, *item)
+			copy((*v)[i+1:], (*v)[i:len(*v)-1])
+			(*v)[i] = *item
+			return Inserted(r, path[0], item)
+		case r.Method == http.MethodDelete:
+			i, err := ElementIndex(len(*v), path[0])
+			if err != nil {
+				return nil, err
+			}
+			if err := Removing(r, &(*v)[i]); err != nil {
+				return nil, err
+			}
+			*v = append((*v)[:i], (*v)[i+1:]...)
+			return nil, nil
+		}
+	}
+	i, err := ElementIndex(len(*v), path[0])
+	if err != nil {
+		return nil, err
+	}
+	return dispatch_ptr_TestItem(r, path[1:], &(*v)[i])
+}
+
+func dispatch_slice_string(r *Request, path Path, v *[]string) (*json.RawMessage, error) {
+	if len(path) == 0 {
+		if r.Method == http.MethodPut {
+			item := new(string)
+			i, err := InsertIndex(r, len(*v), "", item)
+			if err != nil {
+				return nil, err
+			}
+			*v = append(*v, *item)
+			copy((*v)[i+1:], (*v)[i:len(*v)-1])
+			(*v)[i] = *item
+			return Inserted(r, "", item)
+		}
+		if r.Method == http.MethodPost {
+			c := new([]string)
+			if err := DecodeNode(r, v, c); err != nil {
+				return nil, err
+			}
+			*v = *c
+		}
+		return ServeNode(r, v, "slice")
+	}
+	if len(path) == 1 {
+		switch {
+		case r.Method == http.MethodPut && CanInsert(len(*v), path[0]):
+			item := new(string)
+			i, err := InsertIndex(r, len(*v), path[0], item)
+			if err != nil {
+				return nil, err
+			}
+			*v = append(*v, *item)
+			copy((*v)[i+1:], (*v)[i:len(*v)-1])
+			(*v)[i] = *item
+			return Inserted(r, path[0], item)
+		case r.Method == http.MethodDelete:
+			i, err := ElementIndex(len(*v), path[0])
+			if err != nil {
+				return nil, err
+			}
+			if err := Removing(r, &(*v)[i]); err != nil {
+				return nil, err
+			}
+			*v = append((*v)[:i], (*v)[i+1:]...)
+			return nil, nil
+		}
+	}
+	i, err := ElementIndex(len(*v), path[0])
+	if err != nil {
+		return nil, err
+	}
+	return dispatch_string(r, path[1:], &(*v)[i])
+}
+
+func dispatch_string(r *Request, path Path, v *string) (*json.RawMessage, error) {
+	if len(path) == 0 {
+		if r.Method == http.MethodPost {
+			c := new(string)
+			if err := DecodeNode(r, v, c); err != nil {
+				return nil, err
+			}
+			*v = *c
+		}
+		return ServeNode(r, v, "string")
+	}
+	if r.Method == http.MethodDelete && len(path) == 1 {
+		return nil, NewError(CodeMethodNotAllowed, "cannot delete from type of '%s'", "string")
+	}
+	return nil, NewError(CodeNotFound, "path not found '%s'", path)
+}
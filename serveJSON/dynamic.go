@@ -0,0 +1,74 @@
+package serveJSON
+
+import (
+  "encoding/json"
+  "reflect"
+)
+
+var rawMessageType = reflect.TypeOf(json.RawMessage{})
+
+/*
+unwrap follows pv through pointers, interface values and json.RawMessage blobs
+until it points at a concrete value that can be traversed.  Interface contents
+and decoded blobs are copies, so the returned commit function must be called
+after modifying the result to store them back.  When create is set nil
+pointers are allocated and nil interfaces become JSON objects.
+*/
+func unwrap(pv reflect.Value, create bool) (reflect.Value, func(), error) {
+  var commits []func()
+
+  commit := func() {
+    for i := len(commits) - 1; i >= 0; i-- {
+      commits[i]()
+    }
+  }
+
+  for {
+    var err error
+
+    if pv, err = deref(pv, create); err != nil {
+      return pv, commit, err
+    }
+
+    v := pv.Elem()
+
+    switch {
+    case v.Type() == rawMessageType:
+      item := reflect.New(reflect.TypeOf((*interface{})(nil)).Elem())
+
+      if raw := v.Bytes(); len(raw) > 0 {
+        if err := json.Unmarshal(raw, item.Interface()); err != nil {
+          return pv, commit, err
+        }
+      }
+
+      commits = append(commits, func() {
+        if b, err := json.Marshal(item.Interface()); err == nil {
+          v.SetBytes(b)
+        }
+      })
+      pv = item
+    case v.Kind() == reflect.Interface:
+      if v.IsNil() {
+        if !create {
+          return pv, commit, newError(CodeNotFound, "value is nil")
+        }
+        if !v.CanSet() {
+          return pv, commit, newError(CodeInternal, "cannot allocate value of type '%v'", v.Type())
+        }
+        v.Set(reflect.ValueOf(make(map[string]interface{})))
+      }
+
+      e := v.Elem()
+      item := reflect.New(e.Type())
+      item.Elem().Set(e)
+
+      commits = append(commits, func() {
+        v.Set(item.Elem())
+      })
+      pv = item
+    default:
+      return pv, commit, nil
+    }
+  }
+}
@@ -0,0 +1,181 @@
+package serveJSON
+
+import (
+  "encoding/json"
+  "errors"
+  "fmt"
+  "net/http"
+)
+
+// ErrorCode is a machine readable reason for a failed request
+type ErrorCode string
+
+const (
+  CodeNotFound ErrorCode = "not_found"
+  CodeBadRequest ErrorCode = "bad_request"
+  CodeMethodNotAllowed ErrorCode = "method_not_allowed"
+  CodeConflict ErrorCode = "conflict"
+  CodeValidation ErrorCode = "validation_failed"
+  CodePreconditionFailed ErrorCode = "precondition_failed"
+  CodeInternal ErrorCode = "internal"
+)
+
+var statusCodes = map[ErrorCode]int{
+  CodeNotFound: http.StatusNotFound,
+  CodeBadRequest: http.StatusBadRequest,
+  CodeMethodNotAllowed: http.StatusMethodNotAllowed,
+  CodeConflict: http.StatusConflict,
+  CodeValidation: http.StatusUnprocessableEntity,
+  CodePreconditionFailed: http.StatusPreconditionFailed,
+  CodeInternal: http.StatusInternalServerError,
+}
+
+// Status returns the HTTP status code for requests failing with c
+func (c ErrorCode) Status() int {
+  if status, ok := statusCodes[c]; ok {
+    return status
+  }
+  return http.StatusInternalServerError
+}
+
+/*
+Error is the error returned for failed requests.  It is also the form every
+error takes when a Request is sent to a client, so VersionErrors,
+ValidationErrors and errors from elsewhere are converted to it by AsError.
+*/
+type Error struct {
+  Code ErrorCode `json:"code"`
+  Message string `json:"message"`
+  Path Path `json:"path,omitempty"`
+  Err error `json:"-"`
+}
+
+func (e *Error) Error() string {
+  return e.Message
+}
+
+func (e *Error) Unwrap() error {
+  return e.Err
+}
+
+func (e *Error) ErrorCode() ErrorCode {
+  return e.Code
+}
+
+// coder is implemented by errors that know their ErrorCode
+type coder interface {
+  ErrorCode() ErrorCode
+}
+
+func newError(code ErrorCode, format string, args ...interface{}) *Error {
+  return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// wrapError gives err the code c unless it already has one
+func wrapError(c ErrorCode, err error) error {
+  var existing coder
+  if err == nil || errors.As(err, &existing) {
+    return err
+  }
+  return &Error{Code: c, Message: err.Error(), Err: err}
+}
+
+/*
+Code returns the ErrorCode of err.  JSON decoding errors are bad requests and
+anything else without a code is internal.
+*/
+func Code(err error) ErrorCode {
+  var c coder
+  var syntax *json.SyntaxError
+  var unmarshal *json.UnmarshalTypeError
+
+  switch {
+  case errors.As(err, &c):
+    return c.ErrorCode()
+  case errors.As(err, &syntax), errors.As(err, &unmarshal):
+    return CodeBadRequest
+  }
+  return CodeInternal
+}
+
+// StatusCode returns the HTTP status code for err
+func StatusCode(err error) int {
+  return Code(err).Status()
+}
+
+// AsError converts err to an *Error, keeping its message
+func AsError(err error) *Error {
+  if err == nil {
+    return nil
+  }
+
+  e := &Error{Code: Code(err), Message: err.Error(), Err: err}
+
+  var version *VersionError
+  var validation *ValidationError
+  var inner *Error
+
+  switch {
+  case errors.As(err, &version):
+    e.Path = version.Path
+  case errors.As(err, &validation):
+    e.Path = validation.Path
+  case errors.As(err, &inner):
+    e.Path = inner.Path
+  }
+  return e
+}
+
+// writeError answers an HTTP request with err as a JSON object
+func writeError(w http.ResponseWriter, err error) {
+  e := AsError(err)
+  b, _ := json.Marshal(e)
+
+  w.Header().Set("Content-Type", "application/json")
+  w.Header().Set("X-Content-Type-Options", "nosniff")
+  w.WriteHeader(e.Code.Status())
+  w.Write(b)
+}
+
+/*
+MarshalJSON encodes r with its Error as an *Error object, since most errors
+have no exported fields and would otherwise be sent as {}.  Values a Tree has
+concealed from its watchers are left out.
+*/
+func (r *Request) MarshalJSON() ([]byte, error) {
+  type request Request
+
+  body, response, removed := r.Body, r.Response, r.Removed
+  if r.concealed {
+    response, removed = nil, nil
+  }
+  if r.concealedBody {
+    body = nil
+  }
+
+  return json.Marshal(&struct {
+    *request
+    Error *Error `json:"error,omitempty"`
+    Body *json.RawMessage `json:"body"`
+    Response *json.RawMessage `json:"response,omitempty"`
+    Removed *json.RawMessage `json:"removed,omitempty"`
+  }{(*request)(r), AsError(r.Error), body, response, removed})
+}
+
+// UnmarshalJSON decodes r, reading any error back as an *Error
+func (r *Request) UnmarshalJSON(b []byte) error {
+  type request Request
+
+  decoded := &struct {
+    *request
+    Error *Error `json:"error,omitempty"`
+  }{request: (*request)(r)}
+
+  if err := json.Unmarshal(b, decoded); err != nil {
+    return err
+  }
+  if decoded.Error != nil {
+    r.Error = decoded.Error
+  }
+  return nil
+}
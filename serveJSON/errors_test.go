@@ -0,0 +1,101 @@
+package serveJSON
+
+import (
+  "testing"
+  "net/http"
+  "net/http/httptest"
+  "encoding/json"
+  "strings"
+)
+
+func TestErrorStatus(t *testing.T) {
+  tree := NewTree(&TestValidated{Range: TestRange{High: 10, Low: 5}})
+
+  for _, c := range []struct {
+    method string
+    url string
+    contentType string
+    body string
+    status int
+    code ErrorCode
+  }{
+    {"GET", "/missing", "", "", http.StatusNotFound, CodeNotFound},
+    {"GET", "/names/3", "", "", http.StatusNotFound, CodeNotFound},
+    {"GET", "/ranges/a", "", "", http.StatusNotFound, CodeNotFound},
+    {"POST", "/range/low", "", `"seven"`, http.StatusBadRequest, CodeBadRequest},
+    {"POST", "/range", "", `{`, http.StatusBadRequest, CodeBadRequest},
+    {"POST", "/range", "", "", http.StatusBadRequest, CodeBadRequest},
+    {"OPTIONS", "/range", "", "", http.StatusMethodNotAllowed, CodeMethodNotAllowed},
+    {"PUT", "/range", "", `1`, http.StatusMethodNotAllowed, CodeMethodNotAllowed},
+    {"PATCH", "/range", JSONPatchType, `[{"op": "test", "path": "/low", "value": 1}]`, http.StatusConflict, CodeConflict},
+    {"POST", "/range/low", "", `11`, http.StatusUnprocessableEntity, CodeValidation},
+  } {
+    w := httptest.NewRecorder()
+    r := httptest.NewRequest(c.method, c.url, strings.NewReader(c.body))
+    r.Header.Set("Content-Type", c.contentType)
+    tree.ServeHTTP(w, r)
+
+    var e Error
+    if w.Code != c.status {
+      t.Errorf("%s %s: expected %d, got %d `%s`", c.method, c.url, c.status, w.Code, w.Body.String())
+    } else if err := json.Unmarshal(w.Body.Bytes(), &e); err != nil {
+      t.Errorf("%s %s: expected a JSON error, got `%s`", c.method, c.url, w.Body.String())
+    } else if e.Code != c.code || e.Message == "" {
+      t.Errorf("%s %s: expected code '%s', got %#v", c.method, c.url, c.code, e)
+    }
+  }
+}
+
+func TestErrorWrapped(t *testing.T) {
+  mirror := newTestMirror()
+  body := []byte(`[
+    {"method": "POST", "path": ["0", "url"], "body": "rtsp://garage"},
+    {"method": "GET", "path": ["5"]}
+  ]`)
+
+  _, err := ServeJSON(&Request{Method: MethodBatch, Path: Path{"streams"}, Body: (*json.RawMessage)(&body)}, mirror)
+
+  if Code(err) != CodeNotFound || StatusCode(err) != http.StatusNotFound {
+    t.Errorf("expected batched error to keep its code, got '%s' from %v", Code(err), err)
+  }
+
+  if Code(nil) != CodeInternal || AsError(nil) != nil {
+    t.Errorf("expected nil errors to stay nil")
+  }
+}
+
+func TestRequestErrorJSON(t *testing.T) {
+  validation := &Request{
+    Method: http.MethodPost,
+    Path: Path{"range", "low"},
+    Error: &ValidationError{Path: Path{"range"}, Err: newError(CodeInternal, "high is below low")},
+  }
+
+  b, err := json.Marshal(validation)
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  var raw struct {
+    Error map[string]interface{} `json:"error"`
+  }
+  if err := json.Unmarshal(b, &raw); err != nil {
+    t.Fatal(err)
+  } else if raw.Error["code"] != string(CodeValidation) || raw.Error["message"] == "" {
+    t.Errorf("expected error object with a code and message, got `%s`", b)
+  } else if path, _ := raw.Error["path"].([]interface{}); len(path) != 1 || path[0] != "range" {
+    t.Errorf("expected error path, got `%s`", b)
+  }
+
+  decoded := &Request{}
+  if err := json.Unmarshal(b, decoded); err != nil {
+    t.Fatal(err)
+  } else if e, ok := decoded.Error.(*Error); !ok || e.Code != CodeValidation || decoded.Path.String() != "/range/low" {
+    t.Errorf("expected request to round trip, got %#v", decoded)
+  }
+
+  b, _ = json.Marshal(&Request{Method: http.MethodGet})
+  if strings.Contains(string(b), "error") {
+    t.Errorf("expected no error field, got `%s`", b)
+  }
+}
@@ -0,0 +1,192 @@
+package serveJSON
+
+import (
+  "encoding/json"
+  "errors"
+  "net/http"
+  "strconv"
+  "strings"
+)
+
+//go:generate go run ../cmd/servejsongen -test -type TestStruct,TestStruct2,TestMirror,TestInventory -output dispatch_generated_test.go
+
+/*
+Dispatcher is implemented by the code cmd/servejsongen generates for a type,
+which serves GET, POST, PUT and DELETE requests by following path through
+typed fields rather than reflection.  ServeJSON uses it whenever the data
+implements it.  Anything the generated code can't serve exactly as reflection
+would, such as nodes with validators, access tags, keys or custom marshalling,
+it returns ErrNotGenerated for and ServeJSON falls back to reflection.
+*/
+type Dispatcher interface {
+  DispatchJSON(r *Request, path Path) (*json.RawMessage, error)
+}
+
+// ErrNotGenerated is returned by generated code for requests left to reflection
+var ErrNotGenerated = errors.New("serveJSON: not served by generated code")
+
+// the conformance tests switch this off to compare against reflection
+var dispatchGenerated = true
+
+// dispatch serves r with generated code where the method and path allow it
+func dispatch(r *Request, d Dispatcher) (*json.RawMessage, error) {
+  path := cleanPath(r.Path)
+
+  switch r.Method {
+  case http.MethodGet, http.MethodPost, http.MethodPut:
+  case http.MethodDelete:
+    if len(path) == 0 {
+      return nil, ErrNotGenerated
+    }
+  default:
+    return nil, ErrNotGenerated
+  }
+  for _, token := range path {
+    // selectors and escaped keys are left to reflection
+    if _, _, ok := parseSelector(token); ok || strings.HasPrefix(token, `\`) {
+      return nil, ErrNotGenerated
+    }
+  }
+
+  if r.Method == http.MethodGet {
+    res, err := d.DispatchJSON(r, path)
+    if err != nil || res == nil {
+      return res, err
+    }
+    bytes, err := r.Options.apply(*res)
+    if err != nil {
+      return nil, err
+    }
+    return (*json.RawMessage)(&bytes), nil
+  }
+
+  removed := r.Removed
+  r.Removed, r.KeyPath, r.changed = nil, nil, path
+
+  res, err := d.DispatchJSON(r, path)
+  if err == ErrNotGenerated {
+    r.Removed = removed
+  }
+  return res, err
+}
+
+// NewError returns an *Error with the code and a formatted message, for generated code
+func NewError(code ErrorCode, format string, args ...interface{}) *Error {
+  return newError(code, format, args...)
+}
+
+// MarshalNode marshals the value v, for generated code
+func MarshalNode(v interface{}) (*json.RawMessage, error) {
+  bytes, err := json.Marshal(v)
+  if err != nil {
+    return nil, err
+  }
+  return (*json.RawMessage)(&bytes), nil
+}
+
+/*
+DecodeNode decodes the body of a POST over c, a new value of the type of v,
+starting from a copy of v so that nothing changes if it fails.  Generated code
+sets v to c if it succeeds.
+*/
+func DecodeNode(r *Request, v, c interface{}) error {
+  if r.Body == nil || len(*r.Body) == 0 {
+    return newError(CodeBadRequest, "body is empty")
+  }
+
+  current, err := json.Marshal(v)
+  if err != nil {
+    return err
+  }
+  if err := json.Unmarshal(current, c); err != nil {
+    return err
+  }
+  if err := json.Unmarshal(*r.Body, c); err != nil {
+    return wrapError(CodeBadRequest, err)
+  }
+  return nil
+}
+
+/*
+ServeNode serves a GET, POST or PUT of the value v itself for generated code,
+once the body of a POST has been decoded into it by DecodeNode.
+*/
+func ServeNode(r *Request, v interface{}, kind string) (*json.RawMessage, error) {
+  switch r.Method {
+  case http.MethodGet, http.MethodPost:
+    return MarshalNode(v)
+  case http.MethodPut:
+    if r.Body == nil || len(*r.Body) == 0 {
+      return nil, newError(CodeBadRequest, "body is empty")
+    }
+    return nil, newError(CodeMethodNotAllowed, "cannot put to type of '%s'", kind)
+  }
+  return nil, ErrNotGenerated
+}
+
+// CanInsert reports whether a PUT to leaf of a slice of length n inserts into it
+func CanInsert(n int, leaf string) bool {
+  if leaf == "-" {
+    return true
+  }
+  i, err := strconv.Atoi(leaf)
+  return err == nil && i >= 0 && i <= n
+}
+
+// ElementIndex returns the index token gives in a slice of length n, for generated code
+func ElementIndex(n int, token string) (int, error) {
+  i, err := strconv.Atoi(token)
+  if err != nil {
+    return 0, &Error{Code: CodeNotFound, Message: "invalid index '" + token + "'", Err: err}
+  }
+  if i < 0 || i >= n {
+    return 0, newError(CodeNotFound, "index '%d' out of bounds", i)
+  }
+  return i, nil
+}
+
+/*
+InsertIndex decodes the body of a PUT into item, a new element of a slice of
+length n, and returns the index it goes at: before the index leaf, or at the
+end if leaf is empty or "-".
+*/
+func InsertIndex(r *Request, n int, leaf string, item interface{}) (int, error) {
+  if r.Body == nil || len(*r.Body) == 0 {
+    return 0, newError(CodeBadRequest, "body is empty")
+  }
+
+  i := n
+  if leaf != "" && leaf != "-" {
+    i, _ = strconv.Atoi(leaf)
+  }
+
+  if err := json.Unmarshal(*r.Body, item); err != nil {
+    return 0, wrapError(CodeBadRequest, err)
+  }
+  return i, nil
+}
+
+// Inserted reports the element item inserted by a PUT to leaf
+func Inserted(r *Request, leaf string, item interface{}) (*json.RawMessage, error) {
+  if leaf != "" {
+    r.changed = parent(r.changed)
+  }
+  return MarshalNode(item)
+}
+
+// Removing reports the value v, about to be deleted or reset, as removed
+func Removing(r *Request, v interface{}) error {
+  var err error
+  if r.Removed, err = MarshalNode(v); err != nil {
+    return err
+  }
+  r.changed = parent(r.changed)
+  return nil
+}
+
+func parent(path Path) Path {
+  if len(path) == 0 {
+    return path
+  }
+  return path[:len(path)-1]
+}
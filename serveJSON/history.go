@@ -0,0 +1,213 @@
+package serveJSON
+
+import (
+  "encoding/json"
+  "net/http"
+  "reflect"
+  "time"
+)
+
+/*
+MethodUndo reverts the latest mutation recorded by a Tree keeping history, and
+MethodRedo applies the latest one undone again.  Both act on the requestor's
+own mutations unless the body is {"global":true}, and only on mutations at or
+beneath the request path.  The request reports the path of the node put back
+and its value, and is passed on to the watchers like any other mutation.
+*/
+const (
+  MethodUndo = "UNDO"
+  MethodRedo = "REDO"
+)
+
+// historyOptions is the optional body of an undo or redo
+type historyOptions struct {
+  Global bool `json:"global"`
+}
+
+// change holds a node as it was before and after a mutation
+type change struct {
+  requestor string
+  path Path
+  before, after reflect.Value
+  // the version of the tree once the change was made, and when
+  version uint64
+  at time.Time
+}
+
+/*
+history keeps the latest changes made to a Tree, which can be undone, and
+those undone since, which can be redone.  Both are bounded by limit.
+*/
+type history struct {
+  limit int
+  done []*change
+  undone []*change
+}
+
+/*
+KeepHistory has the tree remember up to limit mutations so that they can be
+undone with MethodUndo and redone with MethodRedo.  A limit of zero forgets
+any history and turns it off.
+*/
+func (t *Tree) KeepHistory(limit int) {
+  t.lock.Lock()
+  defer t.lock.Unlock()
+
+  if limit <= 0 {
+    t.history = nil
+    return
+  }
+  if t.history == nil {
+    t.history = &history{}
+  }
+  t.history.limit = limit
+  t.history.done = bounded(t.history.done, limit)
+  t.history.undone = bounded(t.history.undone, limit)
+}
+
+func bounded(changes []*change, limit int) []*change {
+  if len(changes) > limit {
+    return append([]*change{}, changes[len(changes)-limit:]...)
+  }
+  return changes
+}
+
+/*
+changing returns the path, with keys resolved, of the node a mutation of path
+may change: the container of any selectors, the parent for methods that add or
+remove elements, or otherwise the path itself.
+*/
+func changing(method string, path Path, pv reflect.Value) Path {
+  if selectorIndex(path, pv) >= 0 {
+    path, _ = resolvePath(unescape(selectorContainer(path, pv)), pv)
+    return path
+  }
+
+  path, _ = resolvePath(unescape(path), pv)
+  if len(path) > 0 && (method == http.MethodPut || method == http.MethodDelete || method == MethodMove) {
+    return path[:len(path)-1]
+  }
+  return path
+}
+
+/*
+snapshot copies the node a request to path is about to change.  Nodes that
+don't exist yet are created by the request, so the nearest one that does is
+copied instead.  Computed nodes can't be put back, and aren't copied.
+*/
+func snapshot(req *Request, path Path, pv reflect.Value) *change {
+  path = changing(req.Method, path, pv)
+
+  for n := len(path); n >= 0; n-- {
+    pe, _, err := walk(path[:n], pv, modeInternal)
+    if err != nil {
+      continue
+    }
+    if computed(pe.Type()) || computed(pe.Type().Elem()) {
+      return nil
+    }
+    return &change{
+      requestor: req.Requestor,
+      path: append(Path{}, path[:n]...),
+      before: deepCopy(pe.Elem()),
+    }
+  }
+  return nil
+}
+
+// record adds c once the mutation it was taken for has succeeded
+func (h *history) record(c *change, pv reflect.Value) {
+  pe, _, err := walk(c.path, pv, modeInternal)
+  if err != nil {
+    return
+  }
+  c.after = deepCopy(pe.Elem())
+
+  h.done = bounded(append(h.done, c), h.limit)
+
+  // what the requestor undid can't be redone once they change something else
+  undone := h.undone[:0]
+  for _, u := range h.undone {
+    if u.requestor != c.requestor {
+      undone = append(undone, u)
+    }
+  }
+  h.undone = undone
+}
+
+// latest removes and returns the last change in changes that req may step
+func latest(changes *[]*change, req *Request, path Path, global bool) *change {
+  for i := len(*changes) - 1; i >= 0; i-- {
+    c := (*changes)[i]
+    if !global && c.requestor != req.Requestor {
+      continue
+    }
+    if !hasPrefix(c.path, path) && !hasPrefix(path, c.path) {
+      continue
+    }
+    *changes = append((*changes)[:i], (*changes)[i+1:]...)
+    return c
+  }
+  return nil
+}
+
+/*
+step undoes or redoes the latest change req may, putting back the node it
+changed.  It fails with CodeConflict if the node has changed since, rather
+than losing whatever changed it.
+*/
+func (t *Tree) step(req *Request, path Path) (Path, *json.RawMessage, error) {
+  if t.history == nil {
+    return path, nil, newError(CodeMethodNotAllowed, "no history is kept")
+  }
+
+  var options historyOptions
+  if req.Body != nil && len(*req.Body) > 0 {
+    if err := json.Unmarshal(*req.Body, &options); err != nil {
+      return path, nil, wrapError(CodeBadRequest, err)
+    }
+  }
+  global := options.Global || req.Requestor == ""
+
+  from, to := &t.history.done, &t.history.undone
+  if req.Method == MethodRedo {
+    from, to = to, from
+  }
+
+  c := latest(from, req, path, global)
+  if c == nil {
+    return path, nil, newError(CodeNotFound, "nothing to %s at '%v'", req.Method, path)
+  }
+
+  value, expected := c.before, c.after
+  if req.Method == MethodRedo {
+    value, expected = c.after, c.before
+  }
+
+  pv := reflect.ValueOf(t.Data)
+  pe, commit, err := walk(c.path, pv, modeInternal|modeWrite|modeCreate)
+  if err != nil {
+    return c.path, nil, err
+  }
+  if !reflect.DeepEqual(pe.Elem().Interface(), expected.Interface()) {
+    // the change is dropped rather than holding up those before it
+    return c.path, nil, newError(CodeConflict, "'%v' has changed since", c.path)
+  }
+  // the copy stays in the history, so the tree gets a copy of it
+  restore(pe.Elem(), deepCopy(value))
+  commit()
+
+  bytes, err := Marshal(pe.Interface())
+  if err != nil {
+    return c.path, nil, err
+  }
+  if req.restored, err = json.Marshal(pe.Interface()); err != nil {
+    return c.path, nil, err
+  }
+
+  version := t.versions.record(c.path)
+  *to = bounded(append(*to, c), t.history.limit)
+  t.timeline.record(&change{requestor: req.Requestor, path: c.path, before: expected}, version)
+
+  return c.path, (*json.RawMessage)(&bytes), nil
+}
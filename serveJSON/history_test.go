@@ -0,0 +1,169 @@
+package serveJSON
+
+import (
+  "testing"
+  "net/http"
+  "net/http/httptest"
+  "encoding/json"
+  "reflect"
+  "strings"
+)
+
+func historyRequest(tree *Tree, method, requestor string, path Path, body string) (*Request, error) {
+  req := &Request{Method: method, Requestor: requestor, Path: path}
+  if body != "" {
+    b := json.RawMessage(body)
+    req.Body = &b
+  }
+  _, err := tree.Request(req)
+  return req, err
+}
+
+func TestHistoryUndoRedo(t *testing.T) {
+  mirror := newTestMirror()
+  tree := NewTree(mirror)
+  tree.KeepHistory(10)
+
+  if _, err := historyRequest(tree, http.MethodDelete, "phone", Path{"streams"}, ""); err != nil {
+    t.Fatal(err)
+  }
+  if mirror.Streams != nil {
+    t.Fatalf("expected the streams to be deleted, got %v", mirror.Streams)
+  }
+
+  req, err := historyRequest(tree, MethodUndo, "phone", Path{}, "")
+  if err != nil {
+    t.Fatal(err)
+  } else if !reflect.DeepEqual(mirror.Streams, newTestMirror().Streams) {
+    t.Errorf("expected the streams back, got %v", mirror.Streams)
+  } else if len(req.Path) != 0 || !strings.Contains(string(*req.Response), `"kitchen"`) {
+    t.Errorf("expected the node put back in the request, got %v `%s`", req.Path, *req.Response)
+  }
+
+  if _, err := historyRequest(tree, MethodRedo, "phone", Path{}, ""); err != nil {
+    t.Fatal(err)
+  } else if mirror.Streams != nil {
+    t.Errorf("expected the delete to be redone, got %v", mirror.Streams)
+  }
+
+  if _, err := historyRequest(tree, MethodUndo, "phone", Path{}, ""); err != nil {
+    t.Fatal(err)
+  }
+  if _, err := historyRequest(tree, MethodUndo, "phone", Path{}, ""); Code(err) != CodeNotFound {
+    t.Errorf("expected nothing more to undo, got %v", err)
+  }
+
+  // changes only to the streams are put back, not the whole tree
+  historyRequest(tree, http.MethodPut, "phone", Path{"streams", "0"}, `{"name":"garage"}`)
+  historyRequest(tree, http.MethodPost, "phone", Path{"test", "integer"}, `7`)
+
+  if req, err := historyRequest(tree, MethodUndo, "phone", Path{"streams"}, ""); err != nil {
+    t.Fatal(err)
+  } else if !reflect.DeepEqual(req.Path, Path{"streams"}) {
+    t.Errorf("expected the streams to be put back, got %v", req.Path)
+  }
+  if len(mirror.Streams) != 2 || mirror.Test.Integer != 7 {
+    t.Errorf("expected only the insert undone, got %v %v", mirror.Streams, mirror.Test)
+  }
+}
+
+func TestHistoryRequestors(t *testing.T) {
+  mirror := newTestMirror()
+  tree := NewTree(mirror)
+  tree.KeepHistory(10)
+
+  historyRequest(tree, http.MethodPost, "phone", Path{"streams", "0", "url"}, `"rtsp://phone"`)
+  historyRequest(tree, http.MethodPost, "tablet", Path{"test", "integer"}, `7`)
+
+  if _, err := historyRequest(tree, MethodUndo, "phone", Path{}, ""); err != nil {
+    t.Fatal(err)
+  }
+  if mirror.Streams[0].URL != "rtsp://kitchen" || mirror.Test.Integer != 7 {
+    t.Errorf("expected only the phone's change undone, got %v %v", mirror.Streams[0], mirror.Test)
+  }
+
+  if _, err := historyRequest(tree, MethodUndo, "phone", Path{}, `{"global":true}`); err != nil {
+    t.Fatal(err)
+  }
+  if mirror.Test.Integer != 42 {
+    t.Errorf("expected the tablet's change undone globally, got %v", mirror.Test)
+  }
+
+  // the tablet changes what the phone changed, so the phone can't undo it
+  historyRequest(tree, http.MethodPost, "phone", Path{"streams", "1", "url"}, `"rtsp://phone"`)
+  historyRequest(tree, http.MethodPost, "tablet", Path{"streams", "1"}, `{"url":"rtsp://tablet"}`)
+
+  if _, err := historyRequest(tree, MethodUndo, "phone", Path{}, ""); Code(err) != CodeConflict {
+    t.Errorf("expected a conflict undoing a node changed since, got %v", err)
+  } else if mirror.Streams[1].URL != "rtsp://tablet" {
+    t.Errorf("expected the tablet's change kept, got %v", mirror.Streams[1])
+  }
+
+  // a new change from the phone drops what it had undone
+  historyRequest(tree, http.MethodPost, "phone", Path{"test", "visible"}, `true`)
+  if _, err := historyRequest(tree, MethodRedo, "phone", Path{}, ""); Code(err) != CodeNotFound {
+    t.Errorf("expected nothing to redo, got %v", err)
+  }
+}
+
+func TestHistoryLimit(t *testing.T) {
+  data := &TestStruct2{}
+  tree := NewTree(data)
+
+  if _, err := historyRequest(tree, MethodUndo, "", Path{}, ""); Code(err) != CodeMethodNotAllowed {
+    t.Errorf("expected undo without history to be refused, got %v", err)
+  }
+
+  tree.KeepHistory(2)
+  for _, body := range []string{`1`, `2`, `3`} {
+    if _, err := historyRequest(tree, http.MethodPost, "", Path{"test", "integer"}, body); err != nil {
+      t.Fatal(err)
+    }
+  }
+
+  for _, expected := range []int{2, 1} {
+    if _, err := historyRequest(tree, MethodUndo, "", Path{}, ""); err != nil {
+      t.Fatal(err)
+    } else if data.Test.Integer != expected {
+      t.Errorf("expected %d, got %d", expected, data.Test.Integer)
+    }
+  }
+  if _, err := historyRequest(tree, MethodUndo, "", Path{}, ""); Code(err) != CodeNotFound {
+    t.Errorf("expected only the last two changes kept, got %v", err)
+  }
+
+  tree = NewTree(&TestStruct2{})
+  tree.KeepHistory(2)
+  historyRequest(tree, http.MethodPost, "", Path{"test", "integer"}, `1`)
+  historyRequest(tree, MethodUndo, "", Path{}, "")
+
+  if tree.Data.(*TestStruct2).Test != nil {
+    t.Errorf("expected the created pointer to be taken back, got %v", tree.Data.(*TestStruct2).Test)
+  }
+}
+
+func TestHistoryHTTP(t *testing.T) {
+  mirror := newTestMirror()
+  tree := NewTree(mirror)
+  tree.KeepHistory(10)
+
+  watcher := TestCounter{make(chan *Request, 4)}
+  tree.Watch(watcher)
+
+  w := httptest.NewRecorder()
+  tree.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/streams/0", nil))
+  <-watcher.count
+
+  w = httptest.NewRecorder()
+  tree.ServeHTTP(w, httptest.NewRequest(MethodUndo, "/", nil))
+
+  if w.Code != 200 || w.Header().Get("ETag") != ETag(tree.Version(Path{"streams"})) {
+    t.Fatalf("expected 200 with the version of the streams, got %d `%s` %s", w.Code, w.Body.String(), w.Header().Get("ETag"))
+  }
+  if req := <-watcher.count; req.Method != MethodUndo || !reflect.DeepEqual(req.Path, Path{"streams"}) {
+    t.Errorf("expected the undo announced at the streams, got %s %v", req.Method, req.Path)
+  }
+  if len(mirror.Streams) != 2 {
+    t.Errorf("expected the stream back, got %v", mirror.Streams)
+  }
+}
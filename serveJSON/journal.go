@@ -0,0 +1,551 @@
+package serveJSON
+
+import (
+  "encoding/json"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "net/http"
+  "os"
+  "path/filepath"
+  "reflect"
+  "sort"
+  "sync"
+  "time"
+)
+
+/*
+JournalEntry is a line of the journal, recording a successful mutation, or the
+whole of the data, which has no request, as left by Tree.Update or by a
+mutation after one that couldn't be logged.
+*/
+type JournalEntry struct {
+  Time time.Time `json:"time"`
+  Requestor string `json:"requestor,omitempty"`
+  // the version of the tree once the request was served
+  Version uint64 `json:"version"`
+  Request *Request `json:"request,omitempty"`
+  // the whole node put back by an undo or redo
+  Restored json.RawMessage `json:"restored,omitempty"`
+  Data json.RawMessage `json:"data,omitempty"`
+}
+
+// journalSnapshot is the data of a tree as of a version
+type journalSnapshot struct {
+  Time time.Time `json:"time"`
+  Version uint64 `json:"version"`
+  Data json.RawMessage `json:"data"`
+  // the timeline leading up to the data, if the tree keeps one
+  Timeline *journalTimeline `json:"timeline,omitempty"`
+}
+
+// journalTimeline is a timeline as written to a snapshot, oldest change first
+type journalTimeline struct {
+  Since uint64 `json:"since"`
+  SinceTime time.Time `json:"sinceTime"`
+  Changes []*journalChange `json:"changes"`
+}
+
+// journalChange is a change kept by a timeline, with the node as it was before
+type journalChange struct {
+  Time time.Time `json:"time"`
+  Version uint64 `json:"version"`
+  Requestor string `json:"requestor,omitempty"`
+  Path Path `json:"path"`
+  Before json.RawMessage `json:"before"`
+}
+
+/*
+Journal watches a Tree, appending each successful mutation to a log file as a
+line of JSON.  At startup Replay rebuilds the data from the last snapshot and
+the mutations logged since, and Compact writes a new snapshot and drops the
+mutations it includes from the log.
+
+Once it watches the tree, each mutation is logged in order and synced to disk
+before it is answered, and before the next one is served.  Should entries go
+missing all the same, Replay fails rather than serve the entries after them,
+which may address elements by index, against the wrong data.
+*/
+type Journal struct {
+  tree *Tree
+  snapshot string
+  log string
+  lock sync.Mutex
+  file *os.File
+  compacting sync.Mutex
+  // an entry couldn't be written, so the next logs the whole data
+  behind bool
+}
+
+/*
+NewJournal returns a journal of tree kept in the files named snapshot and log.
+A snapshot written by json.Marshal of the data alone, as a plain state file is,
+is read as the starting point of the log.
+*/
+func NewJournal(tree *Tree, snapshot, log string) *Journal {
+  return &Journal{tree: tree, snapshot: snapshot, log: log}
+}
+
+func journaled(method string) bool {
+  switch method {
+  case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+  case MethodMove, MethodBatch, MethodUndo, MethodRedo:
+  default:
+    return false
+  }
+  return true
+}
+
+// replayable copies req without anything that depends on when it was served
+func replayable(req *Request) *Request {
+  r := &Request{
+    Method: req.Method,
+    ContentType: req.ContentType,
+    Path: req.Path,
+    Body: req.Body,
+  }
+  if req.Body == nil || len(*req.Body) == 0 {
+    for _, sub := range req.Batch {
+      r.Batch = append(r.Batch, replayable(sub))
+    }
+  }
+  return r
+}
+
+// Notify appends the request to the log if it changed the tree
+func (j *Journal) Notify(req *Request) error {
+  if req.Error != nil || !journaled(req.Method) {
+    return nil
+  }
+  if j.behind {
+    return j.updated(req.Version, j.tree.Data)
+  }
+
+  return j.write(&JournalEntry{
+    Time: time.Now(),
+    Requestor: req.Requestor,
+    Version: req.Version,
+    Request: replayable(req),
+    Restored: req.restored,
+  })
+}
+
+// updated appends the data as left by Tree.Update at version to the log
+func (j *Journal) updated(version uint64, data interface{}) error {
+  b, err := json.Marshal(data)
+  if err != nil {
+    return err
+  }
+  return j.write(&JournalEntry{Time: time.Now(), Version: version, Data: b})
+}
+
+/*
+write appends entry to the log and syncs it to disk.  If it can't, the log is
+cut back to where it was and the journal falls behind until the next entry.
+*/
+func (j *Journal) write(entry *JournalEntry) (err error) {
+  defer func() { j.behind = err != nil }()
+
+  line, err := json.Marshal(entry)
+  if err != nil {
+    return err
+  }
+
+  j.lock.Lock()
+  defer j.lock.Unlock()
+
+  if j.file == nil {
+    if j.file, err = os.OpenFile(j.log, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0660); err != nil {
+      return err
+    }
+  }
+  info, err := j.file.Stat()
+  if err != nil {
+    return err
+  }
+
+  if _, err = j.file.Write(append(line, '\n')); err == nil {
+    err = j.file.Sync()
+  }
+  if err != nil {
+    // a line cut short would stop replay there
+    j.file.Truncate(info.Size())
+  }
+  return err
+}
+
+// Close closes the log, which is opened again by the next mutation
+func (j *Journal) Close() error {
+  j.lock.Lock()
+  defer j.lock.Unlock()
+
+  if j.file == nil {
+    return nil
+  }
+  err := j.file.Close()
+  j.file = nil
+  return err
+}
+
+// entries reads the log, ignoring a last line cut short by a crash
+func (j *Journal) entries() ([]*JournalEntry, error) {
+  f, err := os.Open(j.log)
+  if os.IsNotExist(err) {
+    return nil, nil
+  } else if err != nil {
+    return nil, err
+  }
+  defer f.Close()
+
+  var entries []*JournalEntry
+  dec := json.NewDecoder(f)
+  for {
+    entry := &JournalEntry{}
+    if err := dec.Decode(entry); err == io.EOF || err == io.ErrUnexpectedEOF {
+      break
+    } else if err != nil {
+      return nil, fmt.Errorf("%s: entry %d: %v", j.log, len(entries)+1, err)
+    }
+    entries = append(entries, entry)
+  }
+
+  sort.SliceStable(entries, func(a, b int) bool { return entries[a].Version < entries[b].Version })
+  return entries, nil
+}
+
+// readSnapshot reads the snapshot, which may be missing or hold the data alone
+func (j *Journal) readSnapshot() (*journalSnapshot, error) {
+  b, err := ioutil.ReadFile(j.snapshot)
+  if os.IsNotExist(err) {
+    return nil, nil
+  } else if err != nil {
+    return nil, err
+  }
+
+  s := &journalSnapshot{}
+  if err := json.Unmarshal(b, s); err != nil || s.Data == nil {
+    s = &journalSnapshot{Data: b}
+  }
+  return s, nil
+}
+
+/*
+Replay loads the snapshot into the data of the tree and serves each mutation
+logged since, in order, without notifying any watchers.  It should be called
+before the tree serves anything else, and before the journal watches it.  Each
+mutation is replayed at the version it was first served at, so versions keep
+increasing across restarts and a timeline kept by the tree holds the versions
+and times of the mutations.  If the versions show a mutation is missing,
+Replay fails with the data as of the entry before.
+*/
+func (j *Journal) Replay() error {
+  s, err := j.readSnapshot()
+  if err != nil {
+    return err
+  }
+  entries, err := j.entries()
+  if err != nil {
+    return err
+  }
+
+  if s != nil {
+    if err := j.tree.load(s); err != nil {
+      return fmt.Errorf("%s: %v", j.snapshot, err)
+    }
+  }
+
+  // the log goes on from the version the tree was at when it was loaded before
+  version := j.tree.Version(Path{})
+
+  for _, entry := range entries {
+    if entry.Version <= version {
+      // already in the snapshot
+      continue
+    }
+
+    // the whole data doesn't depend on the entries before it
+    if entry.Request != nil && entry.Version-records(entry.Request) != version {
+      return fmt.Errorf("%s: version %d doesn't follow version %d, an entry is missing", j.log, entry.Version, version)
+    }
+
+    if err := j.tree.replay(entry); err != nil {
+      return fmt.Errorf("%s: replaying version %d: %v", j.log, entry.Version, err)
+    }
+    version = entry.Version
+  }
+
+  j.tree.lock.Lock()
+  defer j.tree.lock.Unlock()
+
+  if version > j.tree.versions.current {
+    j.tree.versions.current = version
+  }
+  return nil
+}
+
+// load unmarshals the data of a snapshot into the tree, which is then at its version
+func (t *Tree) load(s *journalSnapshot) error {
+  t.lock.Lock()
+  defer t.lock.Unlock()
+
+  if err := json.Unmarshal(s.Data, t.Data); err != nil {
+    return err
+  }
+
+  if s.Version > t.versions.current {
+    t.versions.current = s.Version
+    t.versions.modified = []modification{{path: Path{}, version: s.Version}}
+  } else {
+    t.versions.record(Path{})
+  }
+
+  at := s.Time
+  if at.IsZero() {
+    at = time.Now()
+  }
+  t.timeline.reset(t.versions.current, at)
+
+  if t.timeline == nil || s.Timeline == nil {
+    return nil
+  }
+  return t.loadTimeline(s.Timeline)
+}
+
+// saveTimeline returns the timeline kept by the tree as written to a snapshot
+func (t *Tree) saveTimeline() (*journalTimeline, error) {
+  l := t.timeline
+  if l == nil {
+    return nil, nil
+  }
+
+  s := &journalTimeline{Since: l.since, SinceTime: l.sinceTime, Changes: []*journalChange{}}
+  for _, c := range l.changes {
+    // pointer receivers need an addressable copy
+    before := reflect.New(c.before.Type())
+    before.Elem().Set(c.before)
+
+    b, err := json.Marshal(before.Interface())
+    if err != nil {
+      return nil, err
+    }
+    s.Changes = append(s.Changes, &journalChange{
+      Time: c.at,
+      Version: c.version,
+      Requestor: c.requestor,
+      Path: c.path,
+      Before: b,
+    })
+  }
+  return s, nil
+}
+
+/*
+loadTimeline puts back a timeline written to a snapshot of the data the tree
+now holds.  The type of each node is found by winding a copy of the data back
+through the changes, newest first.
+*/
+func (t *Tree) loadTimeline(s *journalTimeline) error {
+  pv := reflect.ValueOf(t.Data)
+  c := reflect.New(pv.Elem().Type())
+  c.Elem().Set(deepCopy(pv.Elem()))
+
+  changes := make([]*change, len(s.Changes))
+  for i := len(s.Changes) - 1; i >= 0; i-- {
+    saved := s.Changes[i]
+
+    pe, commit, err := walk(saved.Path, c, modeInternal|modeWrite|modeCreate)
+    if err != nil {
+      return err
+    }
+    before := reflect.New(pe.Elem().Type())
+    if err := json.Unmarshal(saved.Before, before.Interface()); err != nil {
+      return fmt.Errorf("timeline at version %d: %v", saved.Version, err)
+    }
+    restore(pe.Elem(), deepCopy(before.Elem()))
+    commit()
+
+    changes[i] = &change{
+      requestor: saved.Requestor,
+      path: saved.Path,
+      before: before.Elem(),
+      version: saved.Version,
+      at: saved.Time,
+    }
+  }
+
+  t.timeline.changes = changes
+  t.timeline.since, t.timeline.sinceTime = s.Since, s.SinceTime
+  t.timeline.prune(time.Now())
+  return nil
+}
+
+// replay serves a logged request again, or puts back the data logged by Update
+func (t *Tree) replay(entry *JournalEntry) error {
+  if entry.Request == nil {
+    t.lock.Lock()
+    defer t.lock.Unlock()
+
+    // a fresh value, so fields omitted as empty are emptied
+    pv := reflect.ValueOf(t.Data)
+    c := reflect.New(pv.Elem().Type())
+    if err := json.Unmarshal(entry.Data, c.Interface()); err != nil {
+      return err
+    }
+    pv.Elem().Set(c.Elem())
+
+    t.timeline.reset(t.versions.record(Path{}), entry.Time)
+    return nil
+  }
+
+  req := replayable(entry.Request)
+  req.Requestor = entry.Requestor
+
+  t.rebase(entry.Version, records(req))
+  defer func() {
+    t.lock.Lock()
+    defer t.lock.Unlock()
+
+    t.timeline.stamp(entry.Time)
+  }()
+
+  if t.serve(req); req.Error == nil || entry.Restored == nil {
+    return req.Error
+  }
+
+  // history from before the snapshot is gone, so the node is put back whole
+  t.lock.Lock()
+  defer t.lock.Unlock()
+
+  pe, commit, err := walk(cleanPath(entry.Request.Path), reflect.ValueOf(t.Data), modeInternal|modeWrite|modeCreate)
+  if err != nil {
+    return err
+  }
+  c := reflect.New(pe.Elem().Type())
+  if err := json.Unmarshal(entry.Restored, c.Interface()); err != nil {
+    return err
+  }
+  before := deepCopy(pe.Elem())
+  pe.Elem().Set(c.Elem())
+  commit()
+
+  path := cleanPath(entry.Request.Path)
+  t.timeline.record(&change{requestor: entry.Requestor, path: path, before: before}, t.versions.record(path))
+  return nil
+}
+
+/*
+Compact writes the data of the tree to a new snapshot and drops the entries
+it includes from the log.  If the tree keeps a timeline, the snapshot holds the
+changes it remembers too, so replay puts the timeline back without serving the
+mutations again.  Each file is replaced by renaming a new one over it, so a
+crash leaves either the old or the new one.
+*/
+func (j *Journal) Compact() error {
+  j.compacting.Lock()
+  defer j.compacting.Unlock()
+
+  // the tree logs while it holds its lock, so the log is only locked after it
+  s := &journalSnapshot{Time: time.Now()}
+  err := j.tree.View(func(data interface{}) (err error) {
+    s.Version = j.tree.versions.node(Path{})
+    if s.Version < j.tree.versions.current {
+      // replay may have left the tree ahead of any node
+      s.Version = j.tree.versions.current
+    }
+
+    if s.Timeline, err = j.tree.saveTimeline(); err != nil {
+      return
+    }
+    s.Data, err = json.Marshal(data)
+    return
+  })
+  if err != nil {
+    return err
+  }
+
+  b, err := json.MarshalIndent(s, "", "\t")
+  if err != nil {
+    return err
+  }
+  if err := writeFile(j.snapshot, b); err != nil {
+    return err
+  }
+
+  j.lock.Lock()
+  defer j.lock.Unlock()
+
+  // mutations logged since the snapshot was taken are kept
+  entries, err := j.entries()
+  if err != nil {
+    return err
+  }
+  var kept []byte
+  for _, entry := range entries {
+    if entry.Version <= s.Version {
+      continue
+    }
+    line, err := json.Marshal(entry)
+    if err != nil {
+      return err
+    }
+    kept = append(append(kept, line...), '\n')
+  }
+
+  if j.file != nil {
+    j.file.Close()
+    j.file = nil
+  }
+  return writeFile(j.log, kept)
+}
+
+/*
+CompactEvery compacts the journal on an interval until the returned function
+is called.  Errors are passed to onError, which may be nil.
+*/
+func (j *Journal) CompactEvery(interval time.Duration, onError func(error)) (stop func()) {
+  done := make(chan struct{})
+  ticker := time.NewTicker(interval)
+
+  go func() {
+    defer ticker.Stop()
+
+    for {
+      select {
+      case <-done:
+        return
+      case <-ticker.C:
+      }
+
+      if err := j.Compact(); err != nil && onError != nil {
+        onError(err)
+      }
+    }
+  }()
+
+  return func() { close(done) }
+}
+
+// writeFile replaces the file name with b by renaming a temporary file over it
+func writeFile(name string, b []byte) error {
+  f, err := ioutil.TempFile(filepath.Dir(name), filepath.Base(name)+".*")
+  if err != nil {
+    return err
+  }
+  defer os.Remove(f.Name())
+
+  if _, err := f.Write(b); err != nil {
+    f.Close()
+    return err
+  }
+  if err := f.Sync(); err != nil {
+    f.Close()
+    return err
+  }
+  if err := f.Close(); err != nil {
+    return err
+  }
+  if err := os.Chmod(f.Name(), 0660); err != nil {
+    return err
+  }
+  return os.Rename(f.Name(), name)
+}
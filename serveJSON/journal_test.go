@@ -0,0 +1,259 @@
+package serveJSON
+
+import (
+  "testing"
+  "net/http"
+  "encoding/json"
+  "bytes"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "reflect"
+  "sync"
+)
+
+// replayed replays the files of j into a new tree
+func replayed(t *testing.T, j *Journal) *Tree {
+  t.Helper()
+
+  tree := NewTree(&TestMirror{})
+  tree.KeepHistory(10)
+  if err := NewJournal(tree, j.snapshot, j.log).Replay(); err != nil {
+    t.Fatal(err)
+  }
+  return tree
+}
+
+func TestJournalReplay(t *testing.T) {
+  dir, err := ioutil.TempDir("", "journal")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer os.RemoveAll(dir)
+
+  tree := NewTree(&TestMirror{})
+  tree.KeepHistory(10)
+  j := NewJournal(tree, filepath.Join(dir, "state.json"), filepath.Join(dir, "state.log"))
+  defer j.Close()
+
+  // the data to start with, as a plain state file
+  b, _ := json.Marshal(newTestMirror())
+  if err := ioutil.WriteFile(j.snapshot, b, 0660); err != nil {
+    t.Fatal(err)
+  }
+  if err := j.Replay(); err != nil {
+    t.Fatal(err)
+  }
+  tree.Watch(j)
+
+  historyRequest(tree, http.MethodPost, "phone", Path{"streams", "0", "url"}, `"rtsp://garage"`)
+  historyRequest(tree, http.MethodPut, "phone", Path{"streams", "0"}, `{"name":"attic"}`)
+  historyRequest(tree, http.MethodDelete, "phone", Path{"test", "array"}, "")
+  historyRequest(tree, MethodBatch, "phone", Path{"test"}, `[{"method":"POST","path":["integer"],"body":7},{"method":"PUT","path":["array"],"body":"x"}]`)
+  historyRequest(tree, MethodUndo, "phone", Path{}, "")
+  last, err := historyRequest(tree, http.MethodPost, "phone", Path{"test", "visible"}, `true`)
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  // a line cut short by a crash is ignored
+  f, _ := os.OpenFile(j.log, os.O_WRONLY|os.O_APPEND, 0660)
+  f.WriteString(`{"time":"2026-10-17T00:00:00Z","version":99,"request":{"meth`)
+  f.Close()
+
+  replay := replayed(t, j)
+  if !reflect.DeepEqual(replay.Data, tree.Data) {
+    t.Errorf("expected %#v, got %#v", tree.Data, replay.Data)
+  }
+  if v := replay.Version(Path{}); v != last.Version {
+    t.Errorf("expected versions to continue from %d, got %d", last.Version, v)
+  }
+}
+
+func TestJournalCompact(t *testing.T) {
+  dir, err := ioutil.TempDir("", "journal")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer os.RemoveAll(dir)
+
+  tree := NewTree(newTestMirror())
+  tree.KeepHistory(10)
+  j := NewJournal(tree, filepath.Join(dir, "state.json"), filepath.Join(dir, "state.log"))
+  defer j.Close()
+  tree.Watch(j)
+
+  historyRequest(tree, http.MethodPost, "phone", Path{"streams", "1", "url"}, `"rtsp://garage"`)
+  if err := j.Compact(); err != nil {
+    t.Fatal(err)
+  }
+
+  if b, err := ioutil.ReadFile(j.log); err != nil || len(b) != 0 {
+    t.Errorf("expected an empty log once compacted, got `%s` %v", b, err)
+  }
+  if replay := replayed(t, j); !reflect.DeepEqual(replay.Data, tree.Data) {
+    t.Errorf("expected the snapshot to hold %#v, got %#v", tree.Data, replay.Data)
+  }
+
+  // the change was before the snapshot, so replay puts back what the undo did
+  historyRequest(tree, MethodUndo, "phone", Path{}, "")
+  historyRequest(tree, http.MethodPost, "phone", Path{"test", "integer"}, `5`)
+
+  replay := replayed(t, j)
+  if !reflect.DeepEqual(replay.Data, tree.Data) {
+    t.Errorf("expected %#v, got %#v", tree.Data, replay.Data)
+  }
+
+  // a second replay of the same files gives the same data
+  if again := replayed(t, j); !reflect.DeepEqual(again.Data, replay.Data) {
+    t.Errorf("expected replay to be repeatable, got %#v", again.Data)
+  }
+}
+
+func TestJournalUpdate(t *testing.T) {
+  dir, err := ioutil.TempDir("", "journal")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer os.RemoveAll(dir)
+
+  tree := NewTree(newTestMirror())
+  j := NewJournal(tree, filepath.Join(dir, "state.json"), filepath.Join(dir, "state.log"))
+  defer j.Close()
+  tree.Watch(j)
+
+  historyRequest(tree, http.MethodPost, "phone", Path{"test", "integer"}, `5`)
+  err = tree.Update(func(data interface{}) error {
+    data.(*TestMirror).Streams = nil
+    data.(*TestMirror).Test.Visible = true
+    return nil
+  })
+  if err != nil {
+    t.Fatal(err)
+  }
+  last, _ := historyRequest(tree, http.MethodPost, "phone", Path{"test", "integer"}, `6`)
+
+  replay := replayed(t, j)
+  if !reflect.DeepEqual(replay.Data, tree.Data) {
+    t.Errorf("expected %#v, got %#v", tree.Data, replay.Data)
+  }
+  if v := replay.Version(Path{}); v != last.Version {
+    t.Errorf("expected version %d, got %d", last.Version, v)
+  }
+}
+
+func TestJournalMissingEntry(t *testing.T) {
+  dir, err := ioutil.TempDir("", "journal")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer os.RemoveAll(dir)
+
+  tree := NewTree(newTestMirror())
+  j := NewJournal(tree, filepath.Join(dir, "state.json"), filepath.Join(dir, "state.log"))
+  defer j.Close()
+  tree.Watch(j)
+
+  historyRequest(tree, http.MethodPost, "phone", Path{"test", "integer"}, `5`)
+  // the deleted stream goes missing, so the next delete would take the wrong one
+  historyRequest(tree, http.MethodDelete, "phone", Path{"streams", "0"}, "")
+  historyRequest(tree, MethodBatch, "phone", Path{}, `[{"method":"POST","path":["test","integer"],"body":6},{"method":"DELETE","path":["streams","0"]}]`)
+
+  b, err := ioutil.ReadFile(j.log)
+  if err != nil {
+    t.Fatal(err)
+  }
+  lines := bytes.SplitAfter(b, []byte("\n"))
+  if len(lines) < 3 {
+    t.Fatalf("expected three entries, got `%s`", b)
+  }
+  if err := ioutil.WriteFile(j.log, append(append([]byte{}, lines[0]...), lines[2]...), 0660); err != nil {
+    t.Fatal(err)
+  }
+
+  if err := NewJournal(NewTree(&TestMirror{}), j.snapshot, j.log).Replay(); err == nil {
+    t.Errorf("expected replay to fail without the second entry")
+  }
+}
+
+func TestJournalBehind(t *testing.T) {
+  dir, err := ioutil.TempDir("", "journal")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer os.RemoveAll(dir)
+
+  tree := NewTree(newTestMirror())
+  j := NewJournal(tree, filepath.Join(dir, "state.json"), filepath.Join(dir, "state.log"))
+  defer j.Close()
+  tree.Watch(j)
+
+  historyRequest(tree, http.MethodPost, "phone", Path{"test", "integer"}, `5`)
+
+  // the log can't be written for a while
+  j.Close()
+  log := j.log
+  j.log = filepath.Join(dir, "missing", "state.log")
+  if _, err := historyRequest(tree, http.MethodDelete, "phone", Path{"streams", "0"}, ""); Code(err) != CodeInternal {
+    t.Errorf("expected the requestor told the change wasn't logged, got %v", err)
+  }
+  j.log = log
+
+  // so the next entry holds the whole data
+  historyRequest(tree, http.MethodPost, "phone", Path{"test", "integer"}, `6`)
+  if replay := replayed(t, j); !reflect.DeepEqual(replay.Data, tree.Data) {
+    t.Errorf("expected %#v, got %#v", tree.Data, replay.Data)
+  }
+}
+
+func TestJournalWatch(t *testing.T) {
+  dir, err := ioutil.TempDir("", "journal")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer os.RemoveAll(dir)
+
+  tree := NewTree(newTestMirror())
+  j := NewJournal(tree, filepath.Join(dir, "state.json"), filepath.Join(dir, "state.log"))
+  defer j.Close()
+
+  // a fresh install has no snapshot to start from
+  if err := j.Replay(); err != nil {
+    t.Fatal(err)
+  }
+  tree.Watch(j)
+
+  req := &Request{Method: http.MethodPost, Path: Path{"test", "integer"}, Body: rawBody(`3`)}
+  tree.Request(req)
+  tree.Request(&Request{Method: http.MethodGet, Path: Path{"test"}})
+  tree.Request(&Request{Method: http.MethodPost, Path: Path{"test", "missing"}, Body: rawBody(`3`)})
+
+  // the journal is written before the request is answered
+  entries, err := j.entries()
+  if err != nil {
+    t.Fatal(err)
+  }
+  if len(entries) != 1 || entries[0].Version != req.Version || entries[0].Request.Method != http.MethodPost {
+    t.Errorf("expected only the successful mutation logged, got %v", entries)
+  }
+
+  // mutations made at once are logged in order
+  var wg sync.WaitGroup
+  for i := 0; i < 20; i++ {
+    wg.Add(1)
+    go func() {
+      defer wg.Done()
+      historyRequest(tree, http.MethodPut, "phone", Path{"streams", "-"}, `{"name":"attic"}`)
+      historyRequest(tree, http.MethodDelete, "phone", Path{"streams", "0"}, "")
+    }()
+  }
+  wg.Wait()
+
+  replay := NewTree(newTestMirror())
+  if err := NewJournal(replay, j.snapshot, j.log).Replay(); err != nil {
+    t.Fatal(err)
+  }
+  if !reflect.DeepEqual(replay.Data, tree.Data) {
+    t.Errorf("expected %#v, got %#v", tree.Data, replay.Data)
+  }
+}
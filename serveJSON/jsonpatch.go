@@ -0,0 +1,228 @@
+package serveJSON
+
+import (
+  "encoding/json"
+  "fmt"
+  "reflect"
+)
+
+const (
+  MergePatchType = "application/merge-patch+json"
+  JSONPatchType = "application/json-patch+json"
+)
+
+/*
+Operation is a single RFC 6902 JSON Patch operation.  Paths are JSON pointers
+relative to the path of the request carrying the patch.
+*/
+type Operation struct {
+  Op string `json:"op"`
+  Path string `json:"path"`
+  From string `json:"from,omitempty"`
+  Value *json.RawMessage `json:"value,omitempty"`
+}
+
+/*
+patchHelper applies a list of JSON Patch operations to the value pointed to by
+pv.  Either every operation is applied or the value is left untouched.
+*/
+func patchHelper(body []byte, pv reflect.Value) error {
+  var ops []Operation
+
+  if err := json.Unmarshal(body, &ops); err != nil {
+    return wrapError(CodeBadRequest, err)
+  }
+
+  return atomically(pv, Path{}, func() error {
+    for i, op := range ops {
+      if err := applyOperation(op, pv); err != nil {
+        return fmt.Errorf("operation %d (%s %s): %w", i, op.Op, op.Path, err)
+      }
+    }
+    return nil
+  })
+}
+
+func applyOperation(op Operation, pv reflect.Value) error {
+  path, err := ParsePointer(op.Path)
+  if err != nil {
+    return err
+  }
+
+  switch op.Op {
+  case "add", "replace", "test":
+    if op.Value == nil {
+      return newError(CodeBadRequest, "missing value")
+    }
+  }
+
+  switch op.Op {
+  case "add":
+    return addOperation(path, *op.Value, pv, false)
+  case "remove":
+    return removeOperation(path, pv)
+  case "replace":
+    target, commit, err := walk(path, pv, modeWrite)
+    if err != nil {
+      return err
+    }
+    if err := replaceHelper(*op.Value, target); err != nil {
+      return err
+    }
+    commit()
+    return nil
+  case "move", "copy":
+    from, err := ParsePointer(op.From)
+    if err != nil {
+      return err
+    }
+    if op.Op == "move" && len(path) > len(from) && hasPrefix(path, from) {
+      // RFC 6902 4.4: a value can't be moved into one of its children
+      return newError(CodeBadRequest, "cannot move '%v' into itself at '%v'", from, path)
+    }
+    source, err := helper(from, pv)
+    if err != nil {
+      return err
+    }
+    value, err := json.Marshal(source.Interface())
+    if err != nil {
+      return err
+    }
+    if op.Op == "move" {
+      if err := removeOperation(from, pv); err != nil {
+        return err
+      }
+    }
+    return addOperation(path, value, pv, true)
+  case "test":
+    target, err := helper(path, pv)
+    if err != nil {
+      return err
+    }
+    current, err := Marshal(target.Interface())
+    if err != nil {
+      return err
+    }
+    var a, b interface{}
+    if err := json.Unmarshal(current, &a); err != nil {
+      return err
+    }
+    if err := json.Unmarshal(*op.Value, &b); err != nil {
+      return err
+    }
+    if !reflect.DeepEqual(a, b) {
+      return newError(CodeConflict, "test failed, value is `%s`", current)
+    }
+    return nil
+  }
+  return newError(CodeBadRequest, "unsupported operation '%s'", op.Op)
+}
+
+func replaceHelper(value []byte, pv reflect.Value) error {
+  v := pv.Elem()
+
+  if !v.CanSet() {
+    return newError(CodeInternal, "cannot set value of type '%v'", v.Type())
+  }
+
+  item := reflect.New(v.Type())
+  if err := decode(value, item); err != nil {
+    return err
+  }
+  restoreProtected(item.Elem(), v)
+  v.Set(item.Elem())
+  return nil
+}
+
+/*
+addOperation adds value at path.  Values from clients are untrusted and have
+any read only fields of new elements cleared, while values moved or copied
+from elsewhere in the tree are kept intact.
+*/
+func addOperation(path []string, value []byte, pv reflect.Value, trusted bool) error {
+  if len(path) == 0 {
+    return replaceHelper(value, pv)
+  }
+
+  leaf := path[len(path)-1]
+  parent, commit, err := walk(path[:len(path)-1], pv, modeWrite)
+  if err != nil {
+    return err
+  }
+
+  if err := addHelper(leaf, value, parent, trusted); err != nil {
+    return err
+  }
+  commit()
+  return nil
+}
+
+func addHelper(leaf string, value []byte, parent reflect.Value, trusted bool) error {
+  parent, commit, err := unwrap(parent, true)
+  if err != nil {
+    return err
+  }
+  defer commit()
+
+  v := parent.Elem()
+
+  switch v.Kind() {
+  case reflect.Slice:
+    i, err := insertIndex(leaf, v)
+    if err != nil {
+      return err
+    }
+
+    item := reflect.New(v.Type().Elem())
+    if err := decode(value, item); err != nil {
+      return err
+    }
+    if !trusted {
+      restoreProtected(item.Elem(), reflect.Value{})
+    }
+
+    insert(v, i, item.Elem())
+    return nil
+  case reflect.Map:
+    key, err := mapKey(leaf, v.Type())
+    if err != nil {
+      return err
+    }
+    item := reflect.New(v.Type().Elem())
+    if err := decode(value, item); err != nil {
+      return err
+    }
+    if !trusted {
+      restoreProtected(item.Elem(), v.MapIndex(key))
+    }
+    if v.IsNil() {
+      v.Set(reflect.MakeMap(v.Type()))
+    }
+    v.SetMapIndex(key, item.Elem())
+    return nil
+  }
+
+  target, _, err := walk([]string{leaf}, parent, modeWrite)
+  if err != nil {
+    return err
+  }
+  return replaceHelper(value, target)
+}
+
+func removeOperation(path []string, pv reflect.Value) error {
+  if len(path) == 0 {
+    return newError(CodeMethodNotAllowed, "cannot remove the root")
+  }
+
+  leaf := path[len(path)-1]
+  parent, commit, err := walk(path[:len(path)-1], pv, modeWrite)
+  if err != nil {
+    return err
+  }
+
+  if _, _, err := deleteHelper(leaf, parent); err != nil {
+    return err
+  }
+  commit()
+  return nil
+}
@@ -0,0 +1,139 @@
+package serveJSON
+
+import (
+  "testing"
+  "net/http"
+  "encoding/json"
+)
+
+func jsonPatch(path []string, ops string, face interface{}) (*json.RawMessage, error) {
+  body := []byte(ops)
+  return ServeJSON(&Request{
+    Method: http.MethodPatch,
+    ContentType: JSONPatchType + "; charset=utf-8",
+    Body: (*json.RawMessage)(&body),
+    Path: path,
+  }, face)
+}
+
+func TestJSONPatchOperations(t *testing.T) {
+  patchTester := &TestStruct{
+    Visible: false,
+    Integer: 42,
+    Array: []string{"zero", "one", "two"},
+  }
+
+  output, err := jsonPatch([]string{}, `[
+    {"op": "test", "path": "/integer", "value": 42},
+    {"op": "replace", "path": "/integer", "value": 43},
+    {"op": "add", "path": "/array/1", "value": "half"},
+    {"op": "add", "path": "/array/-", "value": "three"},
+    {"op": "remove", "path": "/array/0"},
+    {"op": "move", "from": "/array/0", "path": "/array/2"},
+    {"op": "copy", "from": "/visible", "path": "/NoTag"}
+  ]`, patchTester)
+
+  var out TestStruct
+
+  if err != nil {
+    t.Error(err)
+  } else if output == nil {
+    t.Errorf("output is nil")
+  } else if err = json.Unmarshal(*output, &out); err != nil {
+    t.Error(err)
+  } else if out.Integer != 43 {
+    t.Errorf("expected integer 43, got %d", out.Integer)
+  } else if len(out.Array) != 4 || out.Array[0] != "one" || out.Array[1] != "two" || out.Array[2] != "half" || out.Array[3] != "three" {
+    t.Errorf("unexpected array `%v`", out.Array)
+  }
+}
+
+func TestJSONPatchAtomic(t *testing.T) {
+  patchTester := &TestStruct{
+    Integer: 42,
+    Array: []string{"zero", "one"},
+  }
+
+  _, err := jsonPatch([]string{}, `[
+    {"op": "replace", "path": "/integer", "value": 7},
+    {"op": "remove", "path": "/array/0"},
+    {"op": "test", "path": "/integer", "value": 8}
+  ]`, patchTester)
+
+  if err == nil {
+    t.Errorf("expected failed test error, got none")
+  } else if patchTester.Integer != 42 || len(patchTester.Array) != 2 || patchTester.Array[0] != "zero" {
+    t.Errorf("expected patch to be rolled back, got %#v", patchTester)
+  }
+
+  _, err = jsonPatch([]string{}, `[{"op": "frobnicate", "path": "/integer"}]`, patchTester)
+  if err == nil {
+    t.Errorf("expected unsupported operation error, got none")
+  }
+
+  _, err = jsonPatch([]string{}, `{"op": "replace"}`, patchTester)
+  if err == nil {
+    t.Errorf("expected invalid patch error, got none")
+  }
+}
+
+func TestJSONPatchRelativePath(t *testing.T) {
+  patchTester := &TestStruct{
+    Array: []string{"zero", "one"},
+  }
+
+  output, err := jsonPatch([]string{"array"}, `[{"op": "replace", "path": "/1", "value": "uno"}]`, patchTester)
+
+  if err != nil {
+    t.Error(err)
+  } else if output == nil || string(*output) != `["zero","uno"]` {
+    t.Errorf("unexpected output %v", output)
+  }
+}
+
+func TestJSONPatchEscapedPointer(t *testing.T) {
+  m := &map[string]int{"a/b": 1, "c~d": 2}
+
+  _, err := jsonPatch([]string{}, `[
+    {"op": "add", "path": "/a~1b", "value": 3},
+    {"op": "remove", "path": "/c~0d"}
+  ]`, m)
+
+  if err != nil {
+    t.Error(err)
+  } else if (*m)["a/b"] != 3 || len(*m) != 1 {
+    t.Errorf("unexpected result %v", *m)
+  }
+}
+
+func TestJSONPatchReplaceEscapedKey(t *testing.T) {
+  m := &map[string]int{"a/b": 1}
+
+  if _, err := jsonPatch([]string{}, `[{"op": "replace", "path": "/a~1b", "value": 3}]`, m); err != nil {
+    t.Error(err)
+  } else if (*m)["a/b"] != 3 || len(*m) != 1 {
+    t.Errorf("unexpected result %v", *m)
+  }
+
+  if _, err := jsonPatch([]string{}, `[{"op": "replace", "path": "/c~0d", "value": 3}]`, m); err == nil {
+    t.Errorf("expected replacing a missing key to fail, got %v", *m)
+  }
+}
+
+func TestJSONPatchMoveIntoItself(t *testing.T) {
+  tree := &map[string]map[string]int{"a": {"b": 1}}
+
+  _, err := jsonPatch([]string{}, `[{"op": "move", "from": "/a", "path": "/a/c"}]`, tree)
+  if Code(err) != CodeBadRequest {
+    t.Errorf("expected moving a value into itself to be refused, got %v", err)
+  } else if len(*tree) != 1 || (*tree)["a"]["b"] != 1 {
+    t.Errorf("expected nothing moved, got %v", *tree)
+  }
+
+  // moving a value to where it already is changes nothing
+  if _, err := jsonPatch([]string{}, `[{"op": "move", "from": "/a", "path": "/a"}]`, tree); err != nil {
+    t.Error(err)
+  } else if (*tree)["a"]["b"] != 1 {
+    t.Errorf("expected the value kept, got %v", *tree)
+  }
+}
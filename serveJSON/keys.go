@@ -0,0 +1,170 @@
+package serveJSON
+
+import (
+  "bytes"
+  "encoding/json"
+  "fmt"
+  "reflect"
+  "strconv"
+)
+
+/*
+Key marks the field that identifies an element of a slice or array, as in
+`serve:"key"`.  Path tokens naming a keyed element may give its key in place of
+its index, so "streams/kitchen" keeps addressing the same stream however the
+others are inserted, moved or deleted.  Keys must be unique within their
+container, although elements whose key is the zero value are left unkeyed.
+Tokens that are numbers are always indices, so an element keyed "0" is
+addressed by a selector such as "[name=0]" instead.
+*/
+const Key = "key"
+
+// keyField returns the index of the key field of elements of type t, if any
+func keyField(t reflect.Type) (int, bool) {
+  for t.Kind() == reflect.Ptr {
+    t = t.Elem()
+  }
+  if t.Kind() != reflect.Struct {
+    return 0, false
+  }
+
+  for i := 0; i < t.NumField(); i++ {
+    if f := t.Field(i); hasTag(f, Key) {
+      if _, ok := fieldName(f); ok {
+        return i, true
+      }
+    }
+  }
+  return 0, false
+}
+
+/*
+elementKey returns the marshalled name of the key field of the element e and
+its key as text, with strings unquoted.  It returns false if e has no key.
+*/
+func elementKey(e reflect.Value) (string, string, bool) {
+  i, ok := keyField(e.Type())
+  if !ok {
+    return "", "", false
+  }
+
+  for e.Kind() == reflect.Ptr {
+    if e.IsNil() {
+      return "", "", false
+    }
+    e = e.Elem()
+  }
+
+  f := e.Field(i)
+  if f.IsZero() {
+    return "", "", false
+  }
+
+  b, err := json.Marshal(f.Interface())
+  if err != nil {
+    return "", "", false
+  }
+
+  var str string
+  if bytes.HasPrefix(b, []byte(`"`)) && json.Unmarshal(b, &str) == nil {
+    b = []byte(str)
+  }
+
+  name, _ := fieldName(e.Type().Field(i))
+  return name, string(b), true
+}
+
+/*
+elementIndex resolves the token to the index of an element of the slice or
+array v, reading it as an index if it is a number and otherwise matching it
+against the keys of the elements.
+*/
+func elementIndex(token string, v reflect.Value) (int, error) {
+  i, err := strconv.Atoi(token)
+  if err != nil {
+    if _, ok := keyField(v.Type().Elem()); ok {
+      for i := 0; i < v.Len(); i++ {
+        if _, key, ok := elementKey(v.Index(i)); ok && key == token {
+          return i, nil
+        }
+      }
+    }
+    return i, &Error{Code: CodeNotFound, Message: fmt.Sprintf("invalid index '%s'", token), Err: err}
+  }
+  if i < 0 || i >= v.Len() {
+    return i, newError(CodeNotFound, "index '%d' out of bounds", i)
+  }
+  return i, nil
+}
+
+// checkKeys returns a conflict if two elements of the slice or array v share a key
+func checkKeys(v reflect.Value, path Path) error {
+  if _, ok := keyField(v.Type().Elem()); !ok {
+    return nil
+  }
+
+  seen := make(map[string]int)
+  for i := 0; i < v.Len(); i++ {
+    _, key, ok := elementKey(v.Index(i))
+    if !ok {
+      continue
+    }
+    if j, dup := seen[key]; dup {
+      return &Error{
+        Code: CodeConflict,
+        Message: fmt.Sprintf("elements %d and %d share the key '%s'", j, i, key),
+        Path: append(append(Path{}, path...), strconv.Itoa(i)),
+      }
+    }
+    seen[key] = i
+  }
+  return nil
+}
+
+/*
+resolvePath follows path from pv as far as it exists and returns it twice:
+once with the keys of elements replaced by their indices, which is how versions
+and changes are tracked, and once with the indices of keyed elements replaced
+by selectors on their key, as in "[name=kitchen]", which stay valid for clients
+applying the change to their own copy.  Tokens past the end of the existing
+tree are left as they are.
+*/
+func resolvePath(path Path, pv reflect.Value) (indexed Path, keyed Path) {
+  indexed = append(Path{}, path...)
+  keyed = append(Path{}, path...)
+
+  if pv.Kind() != reflect.Ptr {
+    return indexed, keyed
+  }
+
+  for i, token := range path {
+    p, _, err := unwrap(pv, false)
+    if err != nil {
+      break
+    }
+    if _, ok := getter(p.Elem()); ok {
+      // computed values are only worked out when served
+      break
+    }
+
+    v := p.Elem()
+    if k := v.Kind(); k == reflect.Slice || k == reflect.Array {
+      n, err := elementIndex(token, v)
+      if err != nil {
+        break
+      }
+      indexed[i] = strconv.Itoa(n)
+      keyed[i] = indexed[i]
+      if name, key, ok := elementKey(v.Index(n)); ok {
+        keyed[i] = "[" + name + "=" + key + "]"
+      }
+      pv = v.Index(n).Addr()
+      continue
+    }
+
+    if pv, _, err = walk(Path{token}, p, modeInternal); err != nil {
+      break
+    }
+  }
+  return indexed, keyed
+}
@@ -0,0 +1,179 @@
+package serveJSON
+
+import (
+  "testing"
+  "net/http"
+  "net/http/httptest"
+  "encoding/json"
+  "strings"
+  "reflect"
+)
+
+type TestCamera struct {
+  Name string `json:"name" serve:"key"`
+  URL string `json:"url"`
+}
+
+type TestCameras struct {
+  Cameras []TestCamera `json:"cameras"`
+}
+
+func cameraNames(cameras []TestCamera) []string {
+  names := make([]string, len(cameras))
+  for i, c := range cameras {
+    names[i] = c.Name
+  }
+  return names
+}
+
+func TestResolvePath(t *testing.T) {
+  resetTesters()
+  cameras.Cameras = append(cameras.Cameras, TestCamera{URL: "rtsp://unnamed"})
+
+  for _, c := range []struct {
+    path Path
+    indexed Path
+    keyed Path
+  }{
+    {Path{"cameras", "porch", "url"}, Path{"cameras", "1", "url"}, Path{"cameras", "[name=porch]", "url"}},
+    {Path{"cameras", "2"}, Path{"cameras", "2"}, Path{"cameras", "[name=garage]"}},
+    {Path{"cameras", "3"}, Path{"cameras", "3"}, Path{"cameras", "3"}},
+    {Path{"cameras", "attic", "url"}, Path{"cameras", "attic", "url"}, Path{"cameras", "attic", "url"}},
+    {Path{"cameras", "-"}, Path{"cameras", "-"}, Path{"cameras", "-"}},
+  } {
+    indexed, keyed := resolvePath(c.path, reflect.ValueOf(cameras))
+    if !reflect.DeepEqual(indexed, c.indexed) || !reflect.DeepEqual(keyed, c.keyed) {
+      t.Errorf("'%v': expected %v and %v, got %v and %v", c.path, c.indexed, c.keyed, indexed, keyed)
+    }
+  }
+}
+
+func TestServeJSONKeys(t *testing.T) {
+  resetTesters()
+
+  if output, err := ServeJSON(&Request{Method: http.MethodGet, Path: Path{"cameras", "porch", "url"}}, cameras); err != nil {
+    t.Error(err)
+  } else if string(*output) != `"rtsp://porch"` {
+    t.Errorf("expected the porch url, got `%s`", *output)
+  }
+
+  req := &Request{Method: http.MethodDelete, Path: Path{"cameras", "kitchen"}}
+  if _, err := ServeJSON(req, cameras); err != nil {
+    t.Error(err)
+  } else if !reflect.DeepEqual(req.KeyPath, Path{"cameras", "[name=kitchen]"}) {
+    t.Errorf("expected the key of the deleted camera, got %v", req.KeyPath)
+  }
+
+  body := json.RawMessage(`"rtsp://back"`)
+  req = &Request{Method: http.MethodPost, Path: Path{"cameras", "1", "url"}, Body: &body}
+  if _, err := ServeJSON(req, cameras); err != nil {
+    t.Error(err)
+  } else if cameras.Cameras[1].URL != "rtsp://back" {
+    t.Errorf("expected garage to change, got %v", cameras.Cameras)
+  } else if !reflect.DeepEqual(req.KeyPath, Path{"cameras", "[name=garage]", "url"}) {
+    t.Errorf("expected the key of the changed camera, got %v", req.KeyPath)
+  }
+
+  if _, err := ServeJSON(&Request{Method: http.MethodPut, Path: Path{"cameras", "garage"}, Body: rawBody(`{"name": "shed"}`)}, cameras); err != nil {
+    t.Error(err)
+  } else if names := cameraNames(cameras.Cameras); !reflect.DeepEqual(names, []string{"porch", "shed", "garage"}) {
+    t.Errorf("expected an insert before garage, got %v", names)
+  }
+
+  if _, err := ServeJSON(&Request{Method: MethodMove, Path: Path{"cameras", "porch"}, Body: rawBody(`2`)}, cameras); err != nil {
+    t.Error(err)
+  } else if names := cameraNames(cameras.Cameras); !reflect.DeepEqual(names, []string{"shed", "garage", "porch"}) {
+    t.Errorf("expected porch to move to the end, got %v", names)
+  }
+
+  for _, c := range []struct {
+    method string
+    path Path
+    body string
+  }{
+    {http.MethodPut, Path{"cameras"}, `{"name": "shed"}`},
+    {http.MethodPut, Path{"cameras", "0"}, `{"name": "porch"}`},
+    {http.MethodPost, Path{"cameras", "garage", "name"}, `"porch"`},
+  } {
+    _, err := ServeJSON(&Request{Method: c.method, Path: c.path, Body: rawBody(c.body)}, cameras)
+    if Code(err) != CodeConflict {
+      t.Errorf("%s '%v': expected a duplicate key conflict, got %v", c.method, c.path, err)
+    } else if names := cameraNames(cameras.Cameras); !reflect.DeepEqual(names, []string{"shed", "garage", "porch"}) {
+      t.Errorf("%s '%v': expected the duplicate to be rolled back, got %v", c.method, c.path, names)
+    }
+  }
+
+  if _, err := jsonPatch([]string{"cameras"}, `[{"op": "add", "path": "/-", "value": {"name": "garage"}}]`, cameras); Code(err) != CodeConflict {
+    t.Errorf("expected a patch adding a duplicate key to conflict, got %v", err)
+  }
+
+  if _, err := ServeJSON(&Request{Method: http.MethodGet, Path: Path{"cameras", "kitchen"}}, cameras); Code(err) != CodeNotFound {
+    t.Errorf("expected a deleted key not to be found, got %v", err)
+  }
+}
+
+func TestNumericKeys(t *testing.T) {
+  resetTesters()
+  cameras.Cameras = append(cameras.Cameras, TestCamera{Name: "0", URL: "rtsp://zero"})
+
+  for _, c := range []struct {
+    path Path
+    expected string
+  }{
+    {Path{"cameras", "0", "url"}, `"rtsp://kitchen"`},
+    {Path{"cameras", "[name=0]", "url"}, `["rtsp://zero"]`},
+    {Path{"cameras", "3", "url"}, `"rtsp://zero"`},
+  } {
+    if output, err := ServeJSON(&Request{Method: http.MethodGet, Path: c.path}, cameras); err != nil {
+      t.Errorf("'%v': %v", c.path, err)
+    } else if string(*output) != c.expected {
+      t.Errorf("'%v': expected `%s`, got `%s`", c.path, c.expected, *output)
+    }
+  }
+
+  if _, keyed := resolvePath(Path{"cameras", "3"}, reflect.ValueOf(cameras)); !reflect.DeepEqual(keyed, Path{"cameras", "[name=0]"}) {
+    t.Errorf("expected the numeric key given by a selector, got %v", keyed)
+  }
+
+  if _, err := ServeJSON(&Request{Method: http.MethodDelete, Path: Path{"cameras", "0"}}, cameras); err != nil {
+    t.Error(err)
+  } else if names := cameraNames(cameras.Cameras); !reflect.DeepEqual(names, []string{"porch", "garage", "0"}) {
+    t.Errorf("expected the first camera deleted, got %v", names)
+  }
+}
+
+func TestTreeKeys(t *testing.T) {
+  resetTesters()
+  tree := NewTree(cameras)
+  counter := TestCounter{make(chan *Request, 2)}
+  tree.Watch(counter)
+
+  version := tree.Version(Path{"cameras", "porch"})
+  if version != tree.Version(Path{"cameras", "1"}) {
+    t.Errorf("expected a key and its index to share a version")
+  }
+
+  w := httptest.NewRecorder()
+  tree.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/cameras/porch/url", strings.NewReader(`"rtsp://back"`)))
+
+  if w.Code != 200 {
+    t.Fatalf("expected 200, got %d `%s`", w.Code, w.Body.String())
+  }
+
+  req := <-counter.count
+  if b, _ := json.Marshal(req); !strings.Contains(string(b), `"keyPath":["cameras","[name=porch]","url"]`) {
+    t.Errorf("expected the key path in the notification, got `%s`", b)
+  }
+  if v := tree.Version(Path{"cameras", "1"}); v == version {
+    t.Errorf("expected the keyed camera to change version")
+  }
+
+  w = httptest.NewRecorder()
+  r := httptest.NewRequest(http.MethodPost, "/cameras/porch/url", strings.NewReader(`"rtsp://front"`))
+  r.Header.Set("If-Match", ETag(tree.Version(Path{"cameras", "1", "url"})))
+  tree.ServeHTTP(w, r)
+
+  if w.Code != 200 {
+    t.Errorf("expected the version of the keyed path to match, got %d `%s`", w.Code, w.Body.String())
+  }
+}
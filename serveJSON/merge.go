@@ -0,0 +1,175 @@
+package serveJSON
+
+import (
+  "encoding/json"
+  "reflect"
+  "bytes"
+)
+
+var jsonNull = []byte("null")
+
+func isNull(raw []byte) bool {
+  return bytes.Equal(bytes.TrimSpace(raw), jsonNull)
+}
+
+func isObject(raw []byte) bool {
+  raw = bytes.TrimSpace(raw)
+  return len(raw) > 0 && raw[0] == '{'
+}
+
+var unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+/*
+mergeHelper applies an RFC 7396 JSON merge patch to the value pointed to by pv.
+Objects are merged recursively, nulls reset struct fields to their zero value
+and remove map keys, and anything else replaces the target outright.
+*/
+func mergeHelper(patch []byte, pv reflect.Value) error {
+  v := pv.Elem()
+
+  if !v.CanSet() {
+    return newError(CodeInternal, "cannot set value of type '%v'", v.Type())
+  }
+
+  if isNull(patch) {
+    v.Set(reflect.Zero(v.Type()))
+    return nil
+  }
+
+  if !isObject(patch) {
+    // non-object patches replace the target
+    item := reflect.New(v.Type())
+    if err := decode(patch, item); err != nil {
+      return err
+    }
+    v.Set(item.Elem())
+    return nil
+  }
+
+  if pv.Type().Implements(unmarshalerType) && v.Kind() != reflect.Ptr {
+    return mergeGeneric(patch, pv)
+  }
+
+  var fields map[string]json.RawMessage
+  if err := json.Unmarshal(patch, &fields); err != nil {
+    return err
+  }
+
+  switch v.Kind() {
+  case reflect.Ptr:
+    if v.IsNil() {
+      v.Set(reflect.New(v.Type().Elem()))
+    }
+    return mergeHelper(patch, v)
+  case reflect.Struct:
+    for name, raw := range fields {
+      f, field, err := struct_helper(name, v, v.Type())
+      if err != nil {
+        return err
+      }
+      if computed(field.Type) {
+        // computed values sent back from a GET are skipped, as by a POST
+        continue
+      }
+      if err := checkAccess(field, name, modeWrite); err != nil {
+        return err
+      }
+      if err := mergeHelper(raw, f.Addr()); err != nil {
+        return err
+      }
+    }
+    return nil
+  case reflect.Map:
+    return mergeMap(fields, v)
+  case reflect.Interface:
+    return mergeGeneric(patch, pv)
+  }
+
+  // the target isn't an object, so the patch replaces it
+  item := reflect.New(v.Type())
+  if err := decode(patch, item); err != nil {
+    return err
+  }
+  v.Set(item.Elem())
+  return nil
+}
+
+func mergeMap(fields map[string]json.RawMessage, v reflect.Value) error {
+  t := v.Type()
+
+  if v.IsNil() {
+    v.Set(reflect.MakeMap(t))
+  }
+
+  for name, raw := range fields {
+    key, err := mapKey(name, t)
+    if err != nil {
+      return err
+    }
+
+    if isNull(raw) {
+      v.SetMapIndex(key, reflect.Value{})
+      continue
+    }
+
+    item := reflect.New(t.Elem())
+    if existing := v.MapIndex(key); existing.IsValid() {
+      item.Elem().Set(existing)
+    }
+    if err := mergeHelper(raw, item); err != nil {
+      return err
+    }
+    v.SetMapIndex(key, item.Elem())
+  }
+  return nil
+}
+
+// mergeGeneric round trips the target through its JSON encoding for types
+// we can't walk field by field, such as custom (un)marshalers and interfaces.
+func mergeGeneric(patch []byte, pv reflect.Value) error {
+  var target, p interface{}
+
+  current, err := json.Marshal(pv.Interface())
+  if err != nil {
+    return err
+  }
+  if err := json.Unmarshal(current, &target); err != nil {
+    return err
+  }
+  if err := json.Unmarshal(patch, &p); err != nil {
+    return err
+  }
+
+  merged, err := json.Marshal(mergeValues(target, p))
+  if err != nil {
+    return err
+  }
+
+  item := reflect.New(pv.Elem().Type())
+  if err := json.Unmarshal(merged, item.Interface()); err != nil {
+    return err
+  }
+  pv.Elem().Set(item.Elem())
+  return nil
+}
+
+func mergeValues(target, patch interface{}) interface{} {
+  p, ok := patch.(map[string]interface{})
+  if !ok {
+    return patch
+  }
+
+  t, ok := target.(map[string]interface{})
+  if !ok {
+    t = make(map[string]interface{})
+  }
+
+  for k, v := range p {
+    if v == nil {
+      delete(t, k)
+    } else {
+      t[k] = mergeValues(t[k], v)
+    }
+  }
+  return t
+}
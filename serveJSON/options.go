@@ -0,0 +1,282 @@
+package serveJSON
+
+import (
+  "bytes"
+  "encoding/json"
+  "net/url"
+  "strconv"
+  "strings"
+)
+
+/*
+Options trim the response to a GET.  Fields keeps only the listed members of
+the requested value, or of each element if it is an array, and may name nested
+members as pointers relative to it such as "weather/high".  Values nested more
+than Depth levels below the requested value are left out, leaving their
+containers empty, and arrays longer than MaxItems are left out altogether.
+Zero values leave the response untouched.
+
+AsOf and Diff look into the past of a Tree keeping a timeline.  AsOf serves
+the value as it was at that moment, and Diff serves the JSON Patch taking the
+value as it was at Diff to the value as of AsOf, or as it is now.
+*/
+type Options struct {
+  Fields []string `json:"fields,omitempty"`
+  Depth int `json:"depth,omitempty"`
+  MaxItems int `json:"maxItems,omitempty"`
+  AsOf *Moment `json:"asOf,omitempty"`
+  Diff *Moment `json:"diff,omitempty"`
+}
+
+/*
+ParseOptions reads Options from URL query parameters, as in
+"?fields=weather,display&depth=2&maxItems=10" or "?diff=40&asOf=45".  It
+returns nil if none are given.
+*/
+func ParseOptions(query url.Values) (*Options, error) {
+  o := &Options{}
+  given := false
+
+  for _, fields := range query["fields"] {
+    for _, f := range strings.Split(fields, ",") {
+      if f = strings.TrimSpace(f); f != "" {
+        o.Fields = append(o.Fields, f)
+      }
+    }
+    given = true
+  }
+
+  for _, p := range []struct {
+    name string
+    value *int
+  }{
+    {"depth", &o.Depth},
+    {"maxItems", &o.MaxItems},
+  } {
+    s := query.Get(p.name)
+    if s == "" {
+      continue
+    }
+    n, err := strconv.Atoi(s)
+    if err != nil || n < 0 {
+      return nil, newError(CodeBadRequest, "invalid %s '%s'", p.name, s)
+    }
+    *p.value = n
+    given = true
+  }
+
+  for _, p := range []struct {
+    name string
+    value **Moment
+  }{
+    {"asOf", &o.AsOf},
+    {"diff", &o.Diff},
+  } {
+    s := query.Get(p.name)
+    if s == "" {
+      continue
+    }
+    m, err := ParseMoment(s)
+    if err != nil {
+      return nil, err
+    }
+    *p.value = m
+    given = true
+  }
+
+  if !given {
+    return nil, nil
+  }
+  return o, nil
+}
+
+// past reports whether o asks for the value at another moment than now
+func (o *Options) past() bool {
+  return o != nil && (o.AsOf != nil || o.Diff != nil)
+}
+
+// present returns o without the moments it asks for
+func (o *Options) present() *Options {
+  if o == nil {
+    return nil
+  }
+  p := *o
+  p.AsOf, p.Diff = nil, nil
+  return &p
+}
+
+// fieldTree holds the selected members at each level, where nil selects all
+type fieldTree map[string]fieldTree
+
+func (o *Options) fieldTree() (fieldTree, error) {
+  if len(o.Fields) == 0 {
+    return nil, nil
+  }
+
+  tree := make(fieldTree)
+  for _, f := range o.Fields {
+    path, err := ParsePointer("/" + strings.TrimPrefix(f, "/"))
+    if err != nil {
+      return nil, err
+    }
+
+    level := tree
+    for i, token := range path {
+      next, ok := level[token]
+      if ok && next == nil {
+        // an ancestor is already selected in full
+        break
+      }
+      if i == len(path)-1 {
+        level[token] = nil
+        break
+      }
+      if !ok {
+        next = make(fieldTree)
+        level[token] = next
+      }
+      level = next
+    }
+  }
+  return tree, nil
+}
+
+// apply trims the marshalled value raw according to o
+func (o *Options) apply(raw []byte) ([]byte, error) {
+  if o == nil {
+    return raw, nil
+  }
+
+  fields, err := o.fieldTree()
+  if err != nil {
+    return nil, err
+  }
+
+  var b bytes.Buffer
+  if _, err := o.filter(&b, raw, fields, 0, true); err != nil {
+    return nil, err
+  }
+  return b.Bytes(), nil
+}
+
+/*
+filter writes raw, found level levels below the requested value, to b with
+only the given fields.  It returns false without writing anything if raw is
+left out entirely.
+*/
+func (o *Options) filter(b *bytes.Buffer, raw []byte, fields fieldTree, level int, root bool) (bool, error) {
+  raw = bytes.TrimSpace(raw)
+  if len(raw) == 0 {
+    return false, nil
+  }
+
+  switch raw[0] {
+  case '{':
+    members, err := objectMembers(raw)
+    if err != nil {
+      return false, err
+    }
+
+    b.WriteByte('{')
+    first := true
+
+    for _, m := range members {
+      if o.Depth > 0 && level >= o.Depth {
+        break
+      }
+
+      var child fieldTree
+      if fields != nil {
+        var ok bool
+        if child, ok = fields[m.key]; !ok {
+          continue
+        }
+      }
+
+      mark := b.Len()
+      if !first {
+        b.WriteByte(',')
+      }
+      key, _ := json.Marshal(m.key)
+      b.Write(key)
+      b.WriteByte(':')
+
+      if ok, err := o.filter(b, m.value, child, level+1, false); err != nil {
+        return false, err
+      } else if !ok {
+        b.Truncate(mark)
+        continue
+      }
+      first = false
+    }
+
+    b.WriteByte('}')
+    return true, nil
+  case '[':
+    var items []json.RawMessage
+    if err := json.Unmarshal(raw, &items); err != nil {
+      return false, err
+    }
+    if !root && o.MaxItems > 0 && len(items) > o.MaxItems {
+      return false, nil
+    }
+
+    b.WriteByte('[')
+    first := true
+
+    for _, item := range items {
+      if o.Depth > 0 && level >= o.Depth {
+        break
+      }
+
+      mark := b.Len()
+      if !first {
+        b.WriteByte(',')
+      }
+
+      // fields select the members of each element
+      if ok, err := o.filter(b, item, fields, level+1, false); err != nil {
+        return false, err
+      } else if !ok {
+        b.Truncate(mark)
+        continue
+      }
+      first = false
+    }
+
+    b.WriteByte(']')
+    return true, nil
+  }
+
+  b.Write(raw)
+  return true, nil
+}
+
+type member struct {
+  key string
+  value json.RawMessage
+}
+
+// objectMembers decodes a JSON object, keeping the order of its members
+func objectMembers(raw []byte) ([]member, error) {
+  dec := json.NewDecoder(bytes.NewReader(raw))
+
+  if _, err := dec.Token(); err != nil {
+    return nil, err
+  }
+
+  var members []member
+  for dec.More() {
+    token, err := dec.Token()
+    if err != nil {
+      return nil, err
+    }
+
+    m := member{key: token.(string)}
+    if err := dec.Decode(&m.value); err != nil {
+      return nil, err
+    }
+    members = append(members, m)
+  }
+  return members, nil
+}
@@ -0,0 +1,91 @@
+package serveJSON
+
+import (
+  "testing"
+  "net/http"
+  "net/http/httptest"
+  "encoding/json"
+  "net/url"
+  "reflect"
+)
+
+func TestServeJSONOptions(t *testing.T) {
+  mirror := newTestMirror()
+  mirror.Test.Array = []string{"a"}
+
+  for _, c := range []struct {
+    path Path
+    options Options
+    expected string
+  }{
+    {Path{}, Options{Fields: []string{"streams"}}, `{"streams":[{"name":"kitchen","url":"rtsp://kitchen"},{"name":"porch","url":"rtsp://porch"}]}`},
+    {Path{}, Options{Fields: []string{"test/integer", "test/array"}}, `{"test":{"integer":42,"array":["a"]}}`},
+    {Path{}, Options{Fields: []string{"test/integer", "test"}}, `{"test":{"visible":false,"integer":42,"array":["a"],"NoTag":false}}`},
+    {Path{"streams"}, Options{Fields: []string{"name"}}, `[{"name":"kitchen"},{"name":"porch"}]`},
+    {Path{}, Options{Depth: 1}, `{"streams":[],"test":{}}`},
+    {Path{}, Options{Depth: 2, Fields: []string{"test"}}, `{"test":{"visible":false,"integer":42,"array":[],"NoTag":false}}`},
+    {Path{}, Options{MaxItems: 1, Fields: []string{"streams", "test"}}, `{"test":{"visible":false,"integer":42,"array":["a"],"NoTag":false}}`},
+    {Path{"streams"}, Options{MaxItems: 1, Depth: 1}, `[{},{}]`},
+    {Path{"test", "integer"}, Options{Depth: 1}, `42`},
+  } {
+    options := c.options
+    output, err := ServeJSON(&Request{Method: http.MethodGet, Path: c.path, Options: &options}, mirror)
+
+    if err != nil {
+      t.Errorf("%#v: %v", c.options, err)
+    } else if string(*output) != c.expected {
+      t.Errorf("%#v: expected `%s`, got `%s`", c.options, c.expected, *output)
+    }
+  }
+
+  var req Request
+  if err := json.Unmarshal([]byte(`{"method": "GET", "path": [], "options": {"fields": ["test/integer"], "depth": 1}}`), &req); err != nil {
+    t.Fatal(err)
+  } else if output, err := ServeJSON(&req, mirror); err != nil {
+    t.Error(err)
+  } else if string(*output) != `{"test":{}}` {
+    t.Errorf("expected websocket options to apply, got `%s`", *output)
+  }
+}
+
+func TestParseOptions(t *testing.T) {
+  for _, c := range []struct {
+    query string
+    expected *Options
+  }{
+    {"", nil},
+    {"other=1", nil},
+    {"fields=weather,display&fields=streams", &Options{Fields: []string{"weather", "display", "streams"}}},
+    {"depth=2&maxItems=10", &Options{Depth: 2, MaxItems: 10}},
+  } {
+    query, _ := url.ParseQuery(c.query)
+    if o, err := ParseOptions(query); err != nil {
+      t.Errorf("'%s': %v", c.query, err)
+    } else if !reflect.DeepEqual(o, c.expected) {
+      t.Errorf("'%s': expected %#v, got %#v", c.query, c.expected, o)
+    }
+  }
+
+  for _, q := range []string{"depth=x", "maxItems=-1"} {
+    query, _ := url.ParseQuery(q)
+    if _, err := ParseOptions(query); Code(err) != CodeBadRequest {
+      t.Errorf("'%s': expected bad request, got %v", q, err)
+    }
+  }
+
+  tree := NewTree(newTestMirror())
+
+  w := httptest.NewRecorder()
+  tree.ServeHTTP(w, httptest.NewRequest("GET", "/?fields=test/visible,test/integer", nil))
+
+  if w.Code != 200 || w.Body.String() != `{"test":{"visible":false,"integer":42}}` {
+    t.Errorf("expected fields from the query, got %d `%s`", w.Code, w.Body.String())
+  }
+
+  w = httptest.NewRecorder()
+  tree.ServeHTTP(w, httptest.NewRequest("GET", "/?depth=nope", nil))
+
+  if w.Code != http.StatusBadRequest {
+    t.Errorf("expected 400, got %d", w.Code)
+  }
+}
@@ -0,0 +1,120 @@
+package serveJSON
+
+import (
+  "bytes"
+  "encoding/json"
+  "net/url"
+  "strings"
+)
+
+/*
+Path is a list of unescaped reference tokens addressing a value in the served
+tree.  It formats as an RFC 6901 JSON pointer and marshals as an array of
+tokens, but can be unmarshalled from either form.
+*/
+type Path []string
+
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+var pointerUnescaper = strings.NewReplacer("~1", "/", "~0", "~")
+
+func unescapeToken(token string) (string, error) {
+  for i := 0; i < len(token); i++ {
+    if token[i] != '~' {
+      continue
+    }
+    if i+1 >= len(token) || (token[i+1] != '0' && token[i+1] != '1') {
+      return "", newError(CodeBadRequest, "invalid escape in pointer token '%s'", token)
+    }
+    i++
+  }
+  return pointerUnescaper.Replace(token), nil
+}
+
+/*
+ParsePointer parses an RFC 6901 JSON pointer such as "/streams/0/name".  The
+URI fragment form ("#/streams/0") is percent-decoded first.
+*/
+func ParsePointer(pointer string) (Path, error) {
+  if strings.HasPrefix(pointer, "#") {
+    unescaped, err := url.PathUnescape(pointer[1:])
+    if err != nil {
+      return nil, err
+    }
+    pointer = unescaped
+  }
+
+  if pointer == "" {
+    return Path{}, nil
+  }
+  if !strings.HasPrefix(pointer, "/") {
+    return nil, newError(CodeBadRequest, "invalid pointer '%s', must start with '/'", pointer)
+  }
+
+  path := Path(strings.Split(pointer[1:], "/"))
+  for i, p := range path {
+    token, err := unescapeToken(p)
+    if err != nil {
+      return nil, err
+    }
+    path[i] = token
+  }
+  return path, nil
+}
+
+/*
+ParseURLPath parses an escaped URL path, such as the one returned by
+url.URL.EscapedPath, into a Path.  Segments are percent-decoded after
+splitting so "%2F" and "~1" both address keys containing a slash.  The leading
+slash is optional and an empty path addresses the root.
+*/
+func ParseURLPath(escaped string) (Path, error) {
+  escaped = strings.TrimPrefix(escaped, "/")
+
+  if escaped == "" {
+    return Path{}, nil
+  }
+
+  path := Path(strings.Split(escaped, "/"))
+  for i, p := range path {
+    unescaped, err := url.PathUnescape(p)
+    if err != nil {
+      return nil, err
+    }
+    if path[i], err = unescapeToken(unescaped); err != nil {
+      return nil, err
+    }
+  }
+  return path, nil
+}
+
+// String formats the path as an RFC 6901 JSON pointer
+func (p Path) String() string {
+  var b strings.Builder
+  for _, token := range p {
+    b.WriteByte('/')
+    b.WriteString(pointerEscaper.Replace(token))
+  }
+  return b.String()
+}
+
+func (p *Path) UnmarshalJSON(data []byte) error {
+  if bytes.HasPrefix(bytes.TrimSpace(data), []byte(`"`)) {
+    var pointer string
+    if err := json.Unmarshal(data, &pointer); err != nil {
+      return err
+    }
+    parsed, err := ParsePointer(pointer)
+    if err != nil {
+      return err
+    }
+    *p = parsed
+    return nil
+  }
+
+  var tokens []string
+  if err := json.Unmarshal(data, &tokens); err != nil {
+    return err
+  }
+  *p = Path(tokens)
+  return nil
+}
@@ -0,0 +1,109 @@
+package serveJSON
+
+import (
+  "testing"
+  "encoding/json"
+  "net/http/httptest"
+  "reflect"
+)
+
+func TestParsePointer(t *testing.T) {
+  cases := map[string]Path{
+    "": Path{},
+    "/": Path{""},
+    "/streams/0": Path{"streams", "0"},
+    "/a~1b/c~0d": Path{"a/b", "c~d"},
+    "/~01": Path{"~1"},
+    "#/a%20b/c~1d": Path{"a b", "c/d"},
+  }
+
+  for pointer, expected := range cases {
+    if p, err := ParsePointer(pointer); err != nil {
+      t.Errorf("'%s': %v", pointer, err)
+    } else if !reflect.DeepEqual(p, expected) {
+      t.Errorf("'%s': expected %#v, got %#v", pointer, expected, p)
+    }
+  }
+
+  for _, pointer := range []string{"streams", "/a~2", "/a~"} {
+    if _, err := ParsePointer(pointer); err == nil {
+      t.Errorf("'%s': expected invalid pointer error, got none", pointer)
+    }
+  }
+}
+
+func TestPathString(t *testing.T) {
+  p := Path{"a/b", "c~d", "0"}
+
+  if s := p.String(); s != "/a~1b/c~0d/0" {
+    t.Errorf("expected '/a~1b/c~0d/0', got '%s'", s)
+  } else if parsed, err := ParsePointer(s); err != nil {
+    t.Error(err)
+  } else if !reflect.DeepEqual(parsed, p) {
+    t.Errorf("expected %#v, got %#v", p, parsed)
+  }
+}
+
+func TestParseURLPath(t *testing.T) {
+  cases := map[string]Path{
+    "": Path{},
+    "/": Path{},
+    "/streams/0": Path{"streams", "0"},
+    "streams/0": Path{"streams", "0"},
+    "/a%2Fb/a~1b": Path{"a/b", "a/b"},
+    "/a%7E0b": Path{"a~b"},
+  }
+
+  for escaped, expected := range cases {
+    if p, err := ParseURLPath(escaped); err != nil {
+      t.Errorf("'%s': %v", escaped, err)
+    } else if !reflect.DeepEqual(p, expected) {
+      t.Errorf("'%s': expected %#v, got %#v", escaped, expected, p)
+    }
+  }
+
+  if _, err := ParseURLPath("/a%zz"); err == nil {
+    t.Errorf("expected invalid escape error, got none")
+  }
+}
+
+func TestPathJSON(t *testing.T) {
+  var req Request
+
+  if err := json.Unmarshal([]byte(`{"method": "GET", "path": "/a~1b/0"}`), &req); err != nil {
+    t.Error(err)
+  } else if !reflect.DeepEqual(req.Path, Path{"a/b", "0"}) {
+    t.Errorf("expected pointer to be parsed, got %#v", req.Path)
+  }
+
+  if err := json.Unmarshal([]byte(`{"method": "GET", "path": ["a/b", "0"]}`), &req); err != nil {
+    t.Error(err)
+  } else if !reflect.DeepEqual(req.Path, Path{"a/b", "0"}) {
+    t.Errorf("expected token array to be parsed, got %#v", req.Path)
+  }
+
+  if b, err := json.Marshal(Path{"a/b", "0"}); err != nil {
+    t.Error(err)
+  } else if string(b) != `["a/b","0"]` {
+    t.Errorf("expected paths to marshal as arrays, got `%s`", b)
+  }
+}
+
+func TestHandlerJSONEscapedPath(t *testing.T) {
+  m := &map[string]int{"a/b": 1}
+  h := HandlerJSON{Wrapped: m}
+
+  w := httptest.NewRecorder()
+  h.ServeHTTP(w, httptest.NewRequest("GET", "/a~1b", nil))
+
+  if w.Code != 200 || w.Body.String() != "1" {
+    t.Errorf("expected '1', got %d `%s`", w.Code, w.Body.String())
+  }
+
+  w = httptest.NewRecorder()
+  h.ServeHTTP(w, httptest.NewRequest("GET", "/a%2Fb", nil))
+
+  if w.Code != 200 || w.Body.String() != "1" {
+    t.Errorf("expected '1', got %d `%s`", w.Code, w.Body.String())
+  }
+}
@@ -0,0 +1,313 @@
+package serveJSON
+
+import (
+  "encoding/json"
+  "net/http"
+  "sort"
+  "sync"
+)
+
+/*
+Handler serves requests whose paths are relative to where it is mounted.  A
+Tree is a Handler, as is a Router itself.
+*/
+type Handler interface {
+  Request(req *Request) (*json.RawMessage, error)
+}
+
+/*
+Watchable handlers pass the requests they serve on to their watchers.  A
+Router watches each of its Watchable mounts and passes their requests on to its
+own watchers with the mount prefix added back to the paths.
+*/
+type Watchable interface {
+  Watch(watcher Notifier)
+  Unwatch(watcher Notifier)
+}
+
+type mount struct {
+  prefix Path
+  handler Handler
+  forward *forwarder
+}
+
+/*
+Router mounts several handlers, each with its own data, persistence and
+watchers, at path prefixes and serves them as one tree.  Requests go to the
+mount with the longest prefix of their path, while a GET above any mounts
+combines everything mounted beneath it into one value.  Batches must stay
+within a single mount, since they can't be applied atomically across them.
+*/
+type Router struct {
+  lock sync.RWMutex
+  mounts []*mount
+  watchersLock sync.Mutex
+  watchers []Notifier
+}
+
+func NewRouter() *Router {
+  return &Router{}
+}
+
+/*
+Mount serves the handler at prefix, which may be empty to mount it at the
+root.  Like http.ServeMux, it panics if the prefix is already mounted.
+*/
+func (r *Router) Mount(prefix Path, handler Handler) {
+  r.lock.Lock()
+  defer r.lock.Unlock()
+
+  prefix = cleanPath(prefix)
+  for _, m := range r.mounts {
+    if m.prefix.String() == prefix.String() {
+      panic("serveJSON: multiple mounts at " + prefix.String())
+    }
+  }
+
+  m := &mount{prefix: prefix, handler: handler, forward: &forwarder{r, prefix}}
+  if w, ok := handler.(Watchable); ok {
+    w.Watch(m.forward)
+  }
+
+  r.mounts = append(r.mounts, m)
+  // the longest prefixes are matched first
+  sort.SliceStable(r.mounts, func(i, j int) bool {
+    return len(r.mounts[i].prefix) > len(r.mounts[j].prefix)
+  })
+}
+
+// Unmount removes the handler mounted at prefix, if any
+func (r *Router) Unmount(prefix Path) {
+  r.lock.Lock()
+  defer r.lock.Unlock()
+
+  prefix = cleanPath(prefix)
+  for i, m := range r.mounts {
+    if m.prefix.String() != prefix.String() {
+      continue
+    }
+    if w, ok := m.handler.(Watchable); ok {
+      w.Unwatch(m.forward)
+    }
+    r.mounts = append(r.mounts[:i], r.mounts[i+1:]...)
+    return
+  }
+}
+
+func (r *Router) Watch(watcher Notifier) {
+  r.watchersLock.Lock()
+  defer r.watchersLock.Unlock()
+
+  r.watchers = append(r.watchers, watcher)
+}
+
+func (r *Router) Unwatch(watcher Notifier) {
+  r.watchersLock.Lock()
+  defer r.watchersLock.Unlock()
+
+  for i, n := range r.watchers {
+    if n == watcher {
+      r.watchers = append(r.watchers[:i], r.watchers[i+1:]...)
+      return
+    }
+  }
+}
+
+func (r *Router) notify(req *Request) {
+  r.watchersLock.Lock()
+  defer r.watchersLock.Unlock()
+
+  for _, n := range r.watchers {
+    go n.Notify(req)
+  }
+}
+
+// match returns the mount with the longest prefix of path and those beneath path
+func (r *Router) match(path Path) (owner *mount, beneath []*mount) {
+  for _, m := range r.mounts {
+    if len(m.prefix) > len(path) && hasPrefix(m.prefix, path) {
+      beneath = append(beneath, m)
+    } else if owner == nil && hasPrefix(path, m.prefix) {
+      owner = m
+    }
+  }
+  return owner, beneath
+}
+
+/*
+Request serves req with the handler mounted at the longest prefix of its path.
+The paths the handler reports back in req are from the root of the router.
+*/
+func (r *Router) Request(req *Request) (*json.RawMessage, error) {
+  r.lock.RLock()
+  defer r.lock.RUnlock()
+
+  path := cleanPath(req.Path)
+  owner, beneath := r.match(path)
+
+  if req.Method == http.MethodGet && len(beneath) > 0 {
+    req.Response, req.Error = r.combine(req, owner, beneath)
+    if req.Error == nil {
+      r.notify(req)
+    }
+    return req.Response, req.Error
+  }
+
+  if owner == nil {
+    req.Response, req.Error = nil, newError(CodeNotFound, "nothing mounted at '%v'", path)
+    return nil, req.Error
+  }
+
+  if req.Method == MethodBatch {
+    if err := r.checkBatch(req, path, owner); err != nil {
+      req.Response, req.Error = nil, err
+      return nil, err
+    }
+  }
+
+  sub := *req
+  sub.Path = path[len(owner.prefix):]
+
+  // watchers may already hold sub, so the results are copied out of it
+  res, err := owner.handler.Request(&sub)
+  result := owner.forward.mounted(&sub)
+  result.Response, result.Error = res, err
+
+  if _, ok := owner.handler.(Watchable); !ok && err == nil {
+    // handlers that can't be watched are announced here instead
+    announced := *result
+    r.notify(&announced)
+  }
+
+  *req = *result
+  return req.Response, req.Error
+}
+
+// checkBatch ensures that every request batched by req falls within owner
+func (r *Router) checkBatch(req *Request, path Path, owner *mount) error {
+  batch, err := req.requests()
+  if err != nil {
+    return err
+  }
+  for _, sub := range batch {
+    if m, _ := r.match(append(append(Path{}, path...), cleanPath(sub.Path)...)); m != owner {
+      return newError(CodeBadRequest, "batched request '%v' is outside the mount at '%v'", sub.Path, owner.prefix)
+    }
+  }
+  return nil
+}
+
+/*
+combine serves a GET of a path above the mounts beneath it by placing their
+values into the value of the owner, if any, at their relative prefixes.  Each
+is asked for the same time, if the GET asks for one.
+*/
+func (r *Router) combine(req *Request, owner *mount, beneath []*mount) (*json.RawMessage, error) {
+  path := cleanPath(req.Path)
+  var combined interface{} = map[string]interface{}{}
+
+  // each tree has its own versions, so only a time means the same to all of them
+  var past *Options
+  if o := req.Options; o.past() {
+    if o.Diff != nil || o.AsOf.Time.IsZero() {
+      return nil, newError(CodeBadRequest, "only a time may be asked for of '%v', which spans mounts", path)
+    }
+    past = &Options{AsOf: o.AsOf}
+  }
+
+  if owner != nil {
+    sub := &Request{Method: http.MethodGet, Requestor: req.Requestor, Path: path[len(owner.prefix):], Options: past, partial: true}
+    res, err := owner.handler.Request(sub)
+    if err != nil && Code(err) != CodeNotFound {
+      return nil, err
+    }
+    // the owner need not have anything where the mounts beneath it go
+    if err == nil && res != nil {
+      if err := json.Unmarshal(*res, &combined); err != nil {
+        return nil, err
+      }
+    }
+    req.Version = sub.Version
+  }
+
+  // shorter prefixes first so that deeper mounts are placed within them
+  for i := len(beneath) - 1; i >= 0; i-- {
+    m := beneath[i]
+    sub := &Request{Method: http.MethodGet, Requestor: req.Requestor, Path: Path{}, Options: past, partial: true}
+    res, err := m.handler.Request(sub)
+    if err != nil {
+      return nil, err
+    }
+
+    var value interface{}
+    if res != nil {
+      if err := json.Unmarshal(*res, &value); err != nil {
+        return nil, err
+      }
+    }
+    combined = place(combined, m.prefix[len(path):], value)
+  }
+
+  bytes, err := json.Marshal(combined)
+  if err != nil {
+    return nil, err
+  }
+  if bytes, err = req.Options.apply(bytes); err != nil {
+    return nil, err
+  }
+  return (*json.RawMessage)(&bytes), nil
+}
+
+// place sets the value at path within the decoded JSON v, adding objects as needed
+func place(v interface{}, path Path, value interface{}) interface{} {
+  if len(path) == 0 {
+    return value
+  }
+
+  obj, ok := v.(map[string]interface{})
+  if !ok {
+    obj = map[string]interface{}{}
+  }
+  obj[path[0]] = place(obj[path[0]], path[1:], value)
+  return obj
+}
+
+func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+  serveHTTP(w, req, r)
+}
+
+// forwarder passes the requests of a mounted handler on to the watchers of the router
+type forwarder struct {
+  router *Router
+  prefix Path
+}
+
+func (f *forwarder) Notify(req *Request) error {
+  if req.partial {
+    // the combined response is announced instead
+    return nil
+  }
+  f.router.notify(f.mounted(req))
+  return nil
+}
+
+// mounted returns a copy of req with the paths it reports from the root of the router
+func (f *forwarder) mounted(req *Request) *Request {
+  c := *req
+  c.Path = f.within(req.Path)
+
+  if req.KeyPath != nil {
+    c.KeyPath = f.within(req.KeyPath)
+  }
+  if req.Matches != nil {
+    c.Matches = make([]Path, len(req.Matches))
+    for i, m := range req.Matches {
+      c.Matches[i] = f.within(m)
+    }
+  }
+  return &c
+}
+
+func (f *forwarder) within(path Path) Path {
+  return append(append(Path{}, f.prefix...), cleanPath(path)...)
+}
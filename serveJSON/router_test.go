@@ -0,0 +1,186 @@
+package serveJSON
+
+import (
+  "testing"
+  "net/http"
+  "net/http/httptest"
+  "encoding/json"
+  "strings"
+  "reflect"
+  "time"
+)
+
+type TestPlugin struct {
+  requests int
+}
+
+func (p *TestPlugin) Request(req *Request) (*json.RawMessage, error) {
+  p.requests++
+  if req.Method != http.MethodGet {
+    return nil, newError(CodeMethodNotAllowed, "plugin is read only")
+  }
+  b := []byte(`{"enabled":true}`)
+  return (*json.RawMessage)(&b), nil
+}
+
+func newTestRouter() (*Router, *Tree, *Tree) {
+  mirror := NewTree(newTestMirror())
+  system := NewTree(newTestMirror())
+
+  router := NewRouter()
+  router.Mount(Path{}, mirror)
+  router.Mount(Path{"system"}, system)
+  router.Mount(Path{"plugins", "x"}, &TestPlugin{})
+  return router, mirror, system
+}
+
+func TestRouterRequest(t *testing.T) {
+  router, _, _ := newTestRouter()
+
+  for _, c := range []struct {
+    path Path
+    expected string
+  }{
+    {Path{"system", "test", "integer"}, `42`},
+    {Path{"streams", "1", "name"}, `"porch"`},
+    {Path{"plugins"}, `{"x":{"enabled":true}}`},
+    {Path{"plugins", "x", "enabled"}, `{"enabled":true}`},
+  } {
+    req := &Request{Method: http.MethodGet, Path: c.path}
+    if output, err := router.Request(req); err != nil {
+      t.Errorf("'%v': %v", c.path, err)
+    } else if string(*output) != c.expected {
+      t.Errorf("'%v': expected `%s`, got `%s`", c.path, c.expected, *output)
+    } else if !reflect.DeepEqual(req.Path, c.path) {
+      t.Errorf("'%v': expected the path to be kept, got %v", c.path, req.Path)
+    }
+  }
+
+  output, err := router.Request(&Request{Method: http.MethodGet, Path: Path{}})
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  var combined map[string]interface{}
+  if err := json.Unmarshal(*output, &combined); err != nil {
+    t.Fatal(err)
+  }
+  for _, name := range []string{"streams", "test", "system", "plugins"} {
+    if _, ok := combined[name]; !ok {
+      t.Errorf("expected '%s' in the combined tree, got `%s`", name, *output)
+    }
+  }
+  if system, _ := combined["system"].(map[string]interface{}); system["test"] == nil {
+    t.Errorf("expected the system mount within the tree, got %v", combined["system"])
+  }
+
+  for _, c := range []struct {
+    req *Request
+    code ErrorCode
+  }{
+    {&Request{Method: http.MethodPost, Path: Path{"plugins", "x"}}, CodeMethodNotAllowed},
+    {&Request{Method: http.MethodGet, Path: Path{"nowhere"}}, CodeNotFound},
+    {&Request{Method: MethodBatch, Path: Path{}, Batch: []*Request{
+      &Request{Method: http.MethodGet, Path: Path{"streams"}},
+      &Request{Method: http.MethodGet, Path: Path{"system", "test"}},
+    }}, CodeBadRequest},
+  } {
+    if _, err := router.Request(c.req); Code(err) != c.code {
+      t.Errorf("%s '%v': expected %s, got %v", c.req.Method, c.req.Path, c.code, err)
+    } else if Code(c.req.Error) != c.code {
+      t.Errorf("%s '%v': expected the error in the request, got %v", c.req.Method, c.req.Path, c.req.Error)
+    }
+  }
+
+  unrouted := NewRouter()
+  unrouted.Mount(Path{"system"}, NewTree(newTestMirror()))
+  if _, err := unrouted.Request(&Request{Method: http.MethodGet, Path: Path{"streams"}}); Code(err) != CodeNotFound {
+    t.Errorf("expected nothing mounted, got %v", err)
+  }
+
+  defer func() {
+    if recover() == nil {
+      t.Errorf("expected mounting twice to panic")
+    }
+  }()
+  router.Mount(Path{"system"}, NewTree(newTestMirror()))
+}
+
+func TestRouterWatchers(t *testing.T) {
+  router, _, system := newTestRouter()
+
+  all := TestCounter{make(chan *Request, 4)}
+  router.Watch(all)
+  own := TestCounter{make(chan *Request, 4)}
+  system.Watch(own)
+
+  w := httptest.NewRecorder()
+  router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/system/streams/1/url", strings.NewReader(`"rtsp://back"`)))
+
+  if w.Code != 200 || w.Header().Get("ETag") != ETag(system.Version(Path{"streams", "1", "url"})) {
+    t.Fatalf("expected 200 with the version of the mount, got %d `%s` %s", w.Code, w.Body.String(), w.Header().Get("ETag"))
+  }
+
+  if req := <-own.count; !reflect.DeepEqual(req.Path, Path{"streams", "1", "url"}) {
+    t.Errorf("expected the mount to see its own paths, got %v", req.Path)
+  }
+
+  req := <-all.count
+  if !reflect.DeepEqual(req.Path, Path{"system", "streams", "1", "url"}) {
+    t.Errorf("expected the router to see paths from its root, got %v", req.Path)
+  } else if !reflect.DeepEqual(req.KeyPath, Path(nil)) {
+    t.Errorf("expected no key path for unkeyed streams, got %v", req.KeyPath)
+  }
+
+  router.Request(&Request{Method: http.MethodGet, Requestor: "client", Path: Path{}})
+  if req := <-all.count; len(req.Path) != 0 || req.Requestor != "client" {
+    t.Errorf("expected only the combined response, got %v", req)
+  }
+  time.Sleep(10 * time.Millisecond)
+  if n := len(all.count); n != 0 {
+    t.Errorf("expected the parts of the combined response to go unannounced, got %d", n)
+  }
+
+  router.Unmount(Path{"system"})
+  body := json.RawMessage(`7`)
+  system.Request(&Request{Method: http.MethodPost, Path: Path{"test", "integer"}, Body: &body})
+  <-own.count
+
+  time.Sleep(10 * time.Millisecond)
+  if n := len(all.count); n != 0 {
+    t.Errorf("expected an unmounted tree to go unannounced, got %d", n)
+  }
+}
+
+func TestRouterAsOf(t *testing.T) {
+  router, mirror, system := newTestRouter()
+  mirror.KeepTimeline(time.Hour, 100)
+  system.KeepTimeline(time.Hour, 100)
+
+  time.Sleep(time.Millisecond)
+  before := time.Now()
+  time.Sleep(time.Millisecond)
+
+  body := json.RawMessage(`"garage"`)
+  if _, err := router.Request(&Request{Method: http.MethodPost, Path: Path{"streams", "0", "name"}, Body: &body}); err != nil {
+    t.Fatal(err)
+  }
+
+  // every mount is asked for the same time
+  req := &Request{Method: http.MethodGet, Path: Path{}, Options: &Options{AsOf: &Moment{Time: before}, Fields: []string{"streams/name", "system/test"}}}
+  if output, err := router.Request(req); err != nil {
+    t.Fatal(err)
+  } else if !strings.Contains(string(*output), `"name":"kitchen"`) || !strings.Contains(string(*output), `"integer":42`) {
+    t.Errorf("expected the kitchen and the system as they were, got `%s`", *output)
+  }
+
+  req = &Request{Method: http.MethodGet, Path: Path{}, Options: &Options{AsOf: &Moment{Version: 1}}}
+  if _, err := router.Request(req); Code(err) != CodeBadRequest {
+    t.Errorf("expected a version across mounts refused, got %v", err)
+  }
+
+  req = &Request{Method: http.MethodGet, Path: Path{"streams", "0", "name"}, Options: &Options{AsOf: &Moment{Version: 1}}}
+  if output, err := router.Request(req); err != nil || string(*output) != `"kitchen"` {
+    t.Errorf("expected the kitchen as of the start of the mirror, got %v", err)
+  }
+}
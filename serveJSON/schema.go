@@ -0,0 +1,482 @@
+package serveJSON
+
+import (
+  "encoding"
+  "encoding/json"
+  "io/ioutil"
+  "net/http"
+  "reflect"
+  "strconv"
+  "strings"
+  "time"
+)
+
+const SchemaVersion = "http://json-schema.org/draft-07/schema#"
+
+/*
+Schema is a JSON Schema (draft 7) describing the JSON a served value
+marshals to.
+*/
+type Schema struct {
+  Schema string `json:"$schema,omitempty"`
+  Ref string `json:"$ref,omitempty"`
+  Type SchemaTypes `json:"type,omitempty"`
+  Format string `json:"format,omitempty"`
+  ContentEncoding string `json:"contentEncoding,omitempty"`
+  Properties map[string]*Schema `json:"properties,omitempty"`
+  Required []string `json:"required,omitempty"`
+  AdditionalProperties *Schema `json:"additionalProperties,omitempty"`
+  Items *Schema `json:"items,omitempty"`
+  AnyOf []*Schema `json:"anyOf,omitempty"`
+  Enum []interface{} `json:"enum,omitempty"`
+  Minimum *float64 `json:"minimum,omitempty"`
+  Maximum *float64 `json:"maximum,omitempty"`
+  MinLength *float64 `json:"minLength,omitempty"`
+  MaxLength *float64 `json:"maxLength,omitempty"`
+  MinItems *float64 `json:"minItems,omitempty"`
+  MaxItems *float64 `json:"maxItems,omitempty"`
+  MinProperties *float64 `json:"minProperties,omitempty"`
+  MaxProperties *float64 `json:"maxProperties,omitempty"`
+  ReadOnly bool `json:"readOnly,omitempty"`
+  WriteOnly bool `json:"writeOnly,omitempty"`
+  Definitions map[string]*Schema `json:"definitions,omitempty"`
+}
+
+// SchemaTypes marshals as a single type name, or an array of them
+type SchemaTypes []string
+
+func (t SchemaTypes) MarshalJSON() ([]byte, error) {
+  if len(t) == 1 {
+    return json.Marshal(t[0])
+  }
+  return json.Marshal([]string(t))
+}
+
+func (t *SchemaTypes) UnmarshalJSON(b []byte) error {
+  var name string
+  if err := json.Unmarshal(b, &name); err == nil {
+    *t = SchemaTypes{name}
+    return nil
+  }
+  return json.Unmarshal(b, (*[]string)(t))
+}
+
+/*
+Schemer is implemented by types that describe their own JSON Schema, usually
+because they have a custom MarshalJSON.  Types with a MarshalJSON that aren't
+Schemers are described by marshalling their zero value.
+*/
+type Schemer interface {
+  JSONSchema() *Schema
+}
+
+var schemerType = reflect.TypeOf((*Schemer)(nil)).Elem()
+var textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+var timeType = reflect.TypeOf(time.Time{})
+
+/*
+JSONSchema returns the schema of the value at path within face, following the
+same json and serve tags as ServeJSON.  Hidden fields are left out, read and
+write only fields are marked as such, validate tags become constraints, and
+fields that aren't omitempty are required.  Values beneath interfaces can be
+anything, so they have an empty schema.
+*/
+func JSONSchema(face interface{}, path Path) (*Schema, error) {
+  t := reflect.TypeOf(face)
+  if t == nil {
+    return nil, newError(CodeInternal, "cannot describe a nil interface")
+  }
+
+  g := &schemaGenerator{
+    visiting: make(map[reflect.Type]bool),
+    recursive: make(map[reflect.Type]bool),
+    definitions: make(map[string]*Schema),
+  }
+
+  var field *reflect.StructField
+
+  for _, token := range cleanPath(path) {
+    for t.Kind() == reflect.Ptr {
+      t = t.Elem()
+    }
+
+    if t.Kind() == reflect.Interface || t == rawMessageType {
+      // nothing is known about what lies beneath
+      return &Schema{Schema: SchemaVersion}, nil
+    }
+
+    switch t.Kind() {
+    case reflect.Struct:
+      f, err := structField(token, t)
+      if err != nil {
+        return nil, err
+      }
+      if hasTag(f, Hidden) {
+        return nil, newError(CodeNotFound, "field name not found: '%s'", token)
+      }
+      field, t = &f, f.Type
+      continue
+    case reflect.Slice, reflect.Array:
+      i, err := strconv.Atoi(token)
+      if _, keyed := keyField(t.Elem()); err != nil && keyed {
+        // any key may name an element, as only the data knows which exist
+        break
+      }
+      if err != nil || i < 0 || (t.Kind() == reflect.Array && i >= t.Len()) {
+        return nil, newError(CodeNotFound, "invalid index '%s'", token)
+      }
+    case reflect.Map:
+      if _, err := mapKey(token, t); err != nil {
+        return nil, err
+      }
+    default:
+      return nil, newError(CodeNotFound, "path not found '%s', '%v'", Path{token}, t.Kind())
+    }
+    field, t = nil, t.Elem()
+  }
+
+  var s *Schema
+  if field != nil {
+    s = g.field(*field)
+  } else {
+    s = g.schema(t)
+  }
+  if g.err != nil {
+    return nil, g.err
+  }
+  if s == nil {
+    return nil, newError(CodeInternal, "cannot describe values of type '%v'", t)
+  }
+
+  s.Schema = SchemaVersion
+  if len(g.definitions) > 0 {
+    s.Definitions = g.definitions
+  }
+  return s, nil
+}
+
+type schemaGenerator struct {
+  visiting map[reflect.Type]bool
+  recursive map[reflect.Type]bool
+  definitions map[string]*Schema
+  // the first malformed validate tag met
+  err error
+}
+
+func (g *schemaGenerator) fail(err error) {
+  if g.err == nil {
+    g.err = err
+  }
+}
+
+func definitionRef(t reflect.Type) string {
+  return "#" + Path{"definitions", t.String()}.String()
+}
+
+// schema describes values of type t, or returns nil if they can't be marshalled
+func (g *schemaGenerator) schema(t reflect.Type) *Schema {
+  switch {
+  case t.Kind() == reflect.Ptr:
+    return g.schema(t.Elem())
+  case t == timeType:
+    return &Schema{Type: SchemaTypes{"string"}, Format: "date-time"}
+  case t == rawMessageType:
+    return &Schema{}
+  case t.Kind() != reflect.Interface && t.Implements(schemerType):
+    return reflect.Zero(t).Interface().(Schemer).JSONSchema()
+  case reflect.PtrTo(t).Implements(schemerType):
+    return reflect.New(t).Interface().(Schemer).JSONSchema()
+  case computed(t):
+    return g.computedSchema(t)
+  case reflect.PtrTo(t).Implements(marshalerType):
+    return sampleSchema(t)
+  case reflect.PtrTo(t).Implements(textMarshalerType):
+    return &Schema{Type: SchemaTypes{"string"}}
+  }
+
+  switch t.Kind() {
+  case reflect.Bool:
+    return &Schema{Type: SchemaTypes{"boolean"}}
+  case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+    return &Schema{Type: SchemaTypes{"integer"}}
+  case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+    zero := 0.
+    return &Schema{Type: SchemaTypes{"integer"}, Minimum: &zero}
+  case reflect.Float32, reflect.Float64:
+    return &Schema{Type: SchemaTypes{"number"}}
+  case reflect.String:
+    return &Schema{Type: SchemaTypes{"string"}}
+  case reflect.Interface:
+    return &Schema{}
+  case reflect.Slice, reflect.Array:
+    if t.Elem().Kind() == reflect.Uint8 && t.Kind() == reflect.Slice {
+      return &Schema{Type: SchemaTypes{"string"}, ContentEncoding: "base64"}
+    }
+    s := &Schema{Type: SchemaTypes{"array"}, Items: g.schema(t.Elem())}
+    if t.Kind() == reflect.Array {
+      n := float64(t.Len())
+      s.MinItems, s.MaxItems = &n, &n
+    }
+    return s
+  case reflect.Map:
+    return &Schema{Type: SchemaTypes{"object"}, AdditionalProperties: g.schema(t.Elem())}
+  case reflect.Struct:
+    return g.structSchema(t)
+  }
+  return nil
+}
+
+func (g *schemaGenerator) structSchema(t reflect.Type) *Schema {
+  if g.visiting[t] {
+    g.recursive[t] = true
+    return &Schema{Ref: definitionRef(t)}
+  }
+  g.visiting[t] = true
+  defer delete(g.visiting, t)
+
+  s := &Schema{Type: SchemaTypes{"object"}, Properties: make(map[string]*Schema)}
+  var embedded []*Schema
+
+  for i := 0; i < t.NumField(); i++ {
+    f := t.Field(i)
+
+    if hasTag(f, Hidden) {
+      continue
+    }
+    if promoted(f) {
+      // embedded structs are flattened into their parent
+      ft := f.Type
+      if ft.Kind() == reflect.Ptr {
+        ft = ft.Elem()
+      }
+      if inner := g.schema(ft); inner != nil {
+        embedded = append(embedded, inner)
+      }
+      continue
+    }
+
+    name, ok := fieldName(f)
+    if !ok {
+      continue
+    }
+
+    property := g.field(f)
+    if property == nil {
+      continue
+    }
+    s.Properties[name] = property
+
+    c, err := parseConstraints(f)
+    if err != nil {
+      g.fail(err)
+      continue
+    }
+    if c.required || (!omitEmpty(f) && !hasTag(f, WriteOnly)) {
+      s.Required = append(s.Required, name)
+    }
+  }
+
+  // fields of the parent take precedence over those it embeds
+  for _, inner := range embedded {
+    for name, property := range inner.Properties {
+      if _, ok := s.Properties[name]; !ok {
+        s.Properties[name] = property
+      }
+    }
+    for _, name := range inner.Required {
+      s.Required = append(s.Required, name)
+    }
+  }
+
+  if g.recursive[t] {
+    g.definitions[t.String()] = s
+  }
+  return s
+}
+
+// field describes a struct field along with its access and validate tags
+func (g *schemaGenerator) field(f reflect.StructField) *Schema {
+  s := g.schema(f.Type)
+  if s == nil {
+    return nil
+  }
+  // schemas of recursive types are shared, so annotate a copy
+  c := *s
+  s = &c
+
+  if f.Type.Kind() == reflect.Ptr && !omitEmpty(f) {
+    // nil pointers are sent as null
+    if s.Ref != "" || len(s.Type) == 0 {
+      s = &Schema{AnyOf: []*Schema{s, &Schema{Type: SchemaTypes{"null"}}}}
+    } else {
+      s.Type = append(s.Type, "null")
+    }
+  }
+
+  s.ReadOnly = s.ReadOnly || hasTag(f, ReadOnly)
+  s.WriteOnly = s.WriteOnly || hasTag(f, WriteOnly)
+
+  constraints, err := parseConstraints(f)
+  if err != nil {
+    g.fail(err)
+    return s
+  }
+
+  t := f.Type
+  for t.Kind() == reflect.Ptr {
+    t = t.Elem()
+  }
+
+  switch t.Kind() {
+  case reflect.String:
+    s.MinLength, s.MaxLength = constraints.min, constraints.max
+  case reflect.Slice, reflect.Array:
+    if constraints.min != nil {
+      s.MinItems = constraints.min
+    }
+    if constraints.max != nil {
+      s.MaxItems = constraints.max
+    }
+  case reflect.Map:
+    s.MinProperties, s.MaxProperties = constraints.min, constraints.max
+  default:
+    if constraints.min != nil {
+      s.Minimum = constraints.min
+    }
+    if constraints.max != nil {
+      s.Maximum = constraints.max
+    }
+  }
+
+  for _, e := range constraints.enum {
+    s.Enum = append(s.Enum, enumValue(e, t))
+  }
+  return s
+}
+
+// enumValue converts an option of an enum validate tag to the JSON value it matches
+func enumValue(e string, t reflect.Type) interface{} {
+  switch t.Kind() {
+  case reflect.Bool:
+    if b, err := strconv.ParseBool(e); err == nil {
+      return b
+    }
+  case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+    reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+    reflect.Float32, reflect.Float64:
+    if n, err := strconv.ParseFloat(e, 64); err == nil {
+      return n
+    }
+  }
+  return e
+}
+
+func omitEmpty(f reflect.StructField) bool {
+  return strings.Contains(f.Tag.Get("json"), ",omitempty")
+}
+
+/*
+computedSchema describes a Getter by the type of the value its zero value
+computes, which is read only unless it is also a Setter.
+*/
+func (g *schemaGenerator) computedSchema(t reflect.Type) (s *Schema) {
+  defer func() {
+    if recover() != nil {
+      s = &Schema{}
+    }
+  }()
+
+  pv := reflect.New(t)
+  value, err := pv.Interface().(Getter).Get()
+  if err != nil || value == nil {
+    return &Schema{}
+  }
+
+  if s = g.schema(reflect.TypeOf(value)); s == nil {
+    return &Schema{}
+  }
+  _, settable := pv.Interface().(Setter)
+  s.ReadOnly = s.ReadOnly || !settable
+  return s
+}
+
+/*
+sampleSchema describes a type with a custom MarshalJSON by marshalling its
+zero value and describing the result.  If that fails the schema is left empty.
+*/
+func sampleSchema(t reflect.Type) (s *Schema) {
+  defer func() {
+    if recover() != nil {
+      s = &Schema{}
+    }
+  }()
+
+  b, err := json.Marshal(reflect.New(t).Interface())
+  if err != nil {
+    return &Schema{}
+  }
+
+  var sample interface{}
+  if err := json.Unmarshal(b, &sample); err != nil {
+    return &Schema{}
+  }
+  return inferSchema(sample)
+}
+
+func inferSchema(sample interface{}) *Schema {
+  switch v := sample.(type) {
+  case bool:
+    return &Schema{Type: SchemaTypes{"boolean"}}
+  case float64:
+    return &Schema{Type: SchemaTypes{"number"}}
+  case string:
+    return &Schema{Type: SchemaTypes{"string"}}
+  case []interface{}:
+    s := &Schema{Type: SchemaTypes{"array"}}
+    if len(v) > 0 {
+      s.Items = inferSchema(v[0])
+    }
+    return s
+  case map[string]interface{}:
+    s := &Schema{Type: SchemaTypes{"object"}, Properties: make(map[string]*Schema)}
+    for name, value := range v {
+      s.Properties[name] = inferSchema(value)
+    }
+    return s
+  }
+  return &Schema{}
+}
+
+/*
+SchemaHandler serves the JSON Schema of the value at the request path within
+Wrapped.  Only the type of Wrapped is used, so it is safe to share with a
+Tree.
+*/
+type SchemaHandler struct {
+  Wrapped interface{}
+}
+
+func (h SchemaHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+  ioutil.ReadAll(r.Body)
+
+  if r.Method != http.MethodGet {
+    writeError(w, newError(CodeMethodNotAllowed, "unsuppored method '%s'", r.Method))
+    return
+  }
+
+  path, err := ParseURLPath(r.URL.EscapedPath())
+  if err != nil {
+    writeError(w, wrapError(CodeBadRequest, err))
+    return
+  }
+
+  s, err := JSONSchema(h.Wrapped, path)
+  if err != nil {
+    writeError(w, err)
+    return
+  }
+
+  b, _ := json.Marshal(s)
+  w.Header().Set("Content-Type", "application/schema+json")
+  w.Write(b)
+}
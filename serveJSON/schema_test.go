@@ -0,0 +1,196 @@
+package serveJSON
+
+import (
+  "testing"
+  "net/http"
+  "net/http/httptest"
+  "encoding/json"
+  "time"
+  "reflect"
+)
+
+type TestPower struct {
+  Status string
+}
+
+func (p *TestPower) MarshalJSON() ([]byte, error) {
+  return json.Marshal(map[string]string{"powerStatus": p.Status})
+}
+
+type TestCustom struct {
+  Status string
+}
+
+func (c *TestCustom) MarshalJSON() ([]byte, error) {
+  return json.Marshal(c.Status)
+}
+
+func (c *TestCustom) JSONSchema() *Schema {
+  return &Schema{Type: SchemaTypes{"string"}, Enum: []interface{}{"on", "off"}}
+}
+
+type TestTree struct {
+  Name string `json:"name" validate:"required,max=8"`
+  Children []*TestTree `json:"children,omitempty"`
+}
+
+type TestSchema struct {
+  TestRange
+  Threshold float32 `json:"threshold" validate:"min=0,max=1"`
+  Mode string `json:"mode,omitempty" validate:"enum=on|off"`
+  Count uint `json:"count,omitempty" serve:"readonly"`
+  Secret string `json:"secret" serve:"hidden"`
+  Password string `json:"password" serve:"writeonly"`
+  Power TestPower `json:"power"`
+  Custom TestCustom `json:"custom"`
+  Seen *time.Time `json:"seen"`
+  Names map[string][]string `json:"names,omitempty"`
+  Anything interface{} `json:"anything,omitempty"`
+  Tree TestTree `json:"tree"`
+}
+
+func schemaJSON(s *Schema) map[string]interface{} {
+  b, _ := json.Marshal(s)
+  var m map[string]interface{}
+  json.Unmarshal(b, &m)
+  return m
+}
+
+func TestJSONSchema(t *testing.T) {
+  s, err := JSONSchema(&TestSchema{}, Path{})
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  if s.Schema != SchemaVersion || !reflect.DeepEqual(s.Type, SchemaTypes{"object"}) {
+    t.Errorf("expected root object schema, got %#v", s)
+  }
+
+  for _, name := range []string{"high", "low", "threshold", "mode", "count", "password", "power", "custom", "seen", "names", "anything", "tree"} {
+    if s.Properties[name] == nil {
+      t.Errorf("expected property '%s'", name)
+    }
+  }
+  if s.Properties["secret"] != nil {
+    t.Errorf("expected hidden property to be left out")
+  }
+
+  required := make(map[string]bool)
+  for _, name := range s.Required {
+    required[name] = true
+  }
+  if !required["high"] || !required["threshold"] || required["mode"] || required["password"] {
+    t.Errorf("unexpected required properties %v", s.Required)
+  }
+
+  p := s.Properties
+  if m := p["threshold"]; m.Minimum == nil || *m.Minimum != 0 || m.Maximum == nil || *m.Maximum != 1 {
+    t.Errorf("expected threshold bounds, got %#v", m)
+  }
+  if m := p["mode"]; !reflect.DeepEqual(m.Enum, []interface{}{"on", "off"}) {
+    t.Errorf("expected mode enum, got %#v", m)
+  }
+  if !p["count"].ReadOnly || !p["password"].WriteOnly {
+    t.Errorf("expected access tags to be marked")
+  }
+  if m := p["power"]; m.Properties["powerStatus"] == nil || m.Properties["Status"] != nil {
+    t.Errorf("expected schema of the marshalled form, got %#v", schemaJSON(m))
+  }
+  if m := p["custom"]; !reflect.DeepEqual(m.Type, SchemaTypes{"string"}) || len(m.Enum) != 2 {
+    t.Errorf("expected schema from JSONSchema, got %#v", schemaJSON(m))
+  }
+  if m := p["seen"]; !reflect.DeepEqual(m.Type, SchemaTypes{"string", "null"}) || m.Format != "date-time" {
+    t.Errorf("expected nullable date-time, got %#v", schemaJSON(m))
+  }
+  if m := p["names"]; m.AdditionalProperties == nil || m.AdditionalProperties.Items == nil {
+    t.Errorf("expected map of arrays, got %#v", schemaJSON(m))
+  }
+
+  tree := p["tree"]
+  if tree.Properties["children"] == nil || tree.Properties["children"].Items.Ref != "#/definitions/serveJSON.TestTree" {
+    t.Errorf("expected recursive reference, got %#v", schemaJSON(tree))
+  } else if s.Definitions["serveJSON.TestTree"] == nil {
+    t.Errorf("expected definition of recursive type, got %#v", schemaJSON(s))
+  }
+}
+
+func TestJSONSchemaPath(t *testing.T) {
+  s, err := JSONSchema(&TestSchema{}, Path{"tree", "children", "3", "name"})
+  if err != nil {
+    t.Fatal(err)
+  } else if !reflect.DeepEqual(s.Type, SchemaTypes{"string"}) || s.MaxLength == nil || *s.MaxLength != 8 {
+    t.Errorf("expected name schema, got %#v", schemaJSON(s))
+  }
+
+  if s, err = JSONSchema(&TestCameras{}, Path{"cameras", "porch", "url"}); err != nil || !reflect.DeepEqual(s.Type, SchemaTypes{"string"}) {
+    t.Errorf("expected the schema of a keyed element's field, got %v %v", s, err)
+  }
+
+  if s, err = JSONSchema(&TestSchema{}, Path{"anything", "deep"}); err != nil || len(s.Type) != 0 {
+    t.Errorf("expected empty schema beneath an interface, got %v %v", s, err)
+  }
+
+  for _, path := range []Path{
+    Path{"secret"},
+    Path{"missing"},
+    Path{"tree", "children", "x"},
+    Path{"threshold", "x"},
+  } {
+    if _, err := JSONSchema(&TestSchema{}, path); Code(err) != CodeNotFound {
+      t.Errorf("'%v': expected not found, got %v", path, err)
+    }
+  }
+
+  w := httptest.NewRecorder()
+  SchemaHandler{&TestSchema{}}.ServeHTTP(w, httptest.NewRequest("GET", "/threshold", nil))
+
+  var m map[string]interface{}
+  if w.Code != 200 {
+    t.Errorf("expected 200, got %d `%s`", w.Code, w.Body.String())
+  } else if err := json.Unmarshal(w.Body.Bytes(), &m); err != nil {
+    t.Error(err)
+  } else if m["type"] != "number" || m["maximum"] != 1. {
+    t.Errorf("unexpected schema `%s`", w.Body.String())
+  }
+
+  w = httptest.NewRecorder()
+  SchemaHandler{&TestSchema{}}.ServeHTTP(w, httptest.NewRequest("POST", "/threshold", nil))
+  if w.Code != http.StatusMethodNotAllowed {
+    t.Errorf("expected 405, got %d", w.Code)
+  }
+}
+
+type TestMalformed struct {
+  Level int `json:"level" validate:"min=low"`
+}
+
+type TestUnknownTag struct {
+  Tree TestTree `json:"tree"`
+  Level int `json:"level" validate:"between=0|1"`
+}
+
+func TestValidateTags(t *testing.T) {
+  // the tags only describe the schema
+  schema := &TestSchema{}
+  if err := validatedPost(Path{"threshold"}, `2`, schema); err != nil || schema.Threshold != 2 {
+    t.Errorf("expected the write accepted, got %v", err)
+  }
+
+  for _, face := range []interface{}{&TestMalformed{}, &TestUnknownTag{}} {
+    if _, err := JSONSchema(face, Path{}); Code(err) != CodeInternal {
+      t.Errorf("%T: expected the malformed tag reported, got %v", face, err)
+    }
+  }
+  if _, err := JSONSchema(&TestMalformed{}, Path{"level"}); Code(err) != CodeInternal {
+    t.Errorf("expected the malformed tag of the field reported, got %v", err)
+  }
+  if _, err := JSONSchema(&TestUnknownTag{}, Path{"tree"}); err != nil {
+    t.Errorf("expected fields without malformed tags described, got %v", err)
+  }
+
+  w := httptest.NewRecorder()
+  SchemaHandler{Wrapped: &TestMalformed{}}.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+  if w.Code != http.StatusInternalServerError {
+    t.Errorf("expected 500, got %d", w.Code)
+  }
+}
@@ -0,0 +1,254 @@
+package serveJSON
+
+import (
+  "bytes"
+  "encoding/json"
+  "net/http"
+  "reflect"
+  "regexp"
+  "strconv"
+  "strings"
+)
+
+var predicateParser = regexp.MustCompile(`^(.*)\[([^=\]]+)=(.*)\]$`)
+var rangeParser = regexp.MustCompile(`^([0-9]*):([0-9]*)$`)
+
+/*
+selector picks elements of a slice or array, either those whose field equals
+a value, as in "streams[name=kitchen]", or a range of indices with an exclusive
+end, as in "1:3", "2:" or ":2".  Tokens are only read as selectors where they
+pick from a slice or array, so map keys such as "10:30" are left alone, and a
+token starting with a backslash is never a selector: "\a[b=c]" names the key
+"a[b=c]" and "\\x" the key "\x".
+*/
+type selector struct {
+  field string
+  value string
+  start int
+  end int
+  ranged bool
+}
+
+/*
+parseSelector splits a path token into the name of the field holding the
+elements, which may be empty, and the selector.  It returns false if the token
+has no selector.
+*/
+func parseSelector(token string) (string, *selector, bool) {
+  if strings.HasPrefix(token, `\`) {
+    return "", nil, false
+  }
+  if m := rangeParser.FindStringSubmatch(token); m != nil {
+    s := &selector{end: -1, ranged: true}
+    if m[1] != "" {
+      s.start, _ = strconv.Atoi(m[1])
+    }
+    if m[2] != "" {
+      s.end, _ = strconv.Atoi(m[2])
+    }
+    return "", s, true
+  }
+  if m := predicateParser.FindStringSubmatch(token); m != nil {
+    return m[1], &selector{field: m[2], value: m[3]}, true
+  }
+  return "", nil, false
+}
+
+// unescape takes the backslash off the tokens of path escaped with one
+func unescape(path Path) Path {
+  var literal Path
+  for i, token := range path {
+    if strings.HasPrefix(token, `\`) {
+      if literal == nil {
+        literal = append(Path{}, path...)
+      }
+      literal[i] = token[1:]
+    }
+  }
+  if literal == nil {
+    return path
+  }
+  return literal
+}
+
+// selectorIndex returns the index of the first token of path selecting from a slice or array of pv
+func selectorIndex(path Path, pv reflect.Value) int {
+  for i, token := range path {
+    name, _, ok := parseSelector(token)
+    if !ok {
+      continue
+    }
+
+    container := unescape(path[:i])
+    if name != "" {
+      container = append(append(Path{}, container...), name)
+    }
+    if v, _, err := walk(container, pv, modeRead); err == nil {
+      if v, _, err = unwrap(v, false); err == nil {
+        if k := v.Elem().Kind(); k == reflect.Slice || k == reflect.Array {
+          return i
+        }
+      }
+    }
+  }
+  return -1
+}
+
+// selectorContainer returns the path, still escaped, of the elements the first selector picks from
+func selectorContainer(path Path, pv reflect.Value) Path {
+  i := selectorIndex(path, pv)
+  name, _, _ := parseSelector(path[i])
+
+  prefix := append(Path{}, path[:i]...)
+  if name != "" {
+    prefix = append(prefix, name)
+  }
+  return prefix
+}
+
+// matches returns the indices of the elements of the slice or array v it selects
+func (s *selector) matches(v reflect.Value) ([]int, error) {
+  var indices []int
+
+  if s.ranged {
+    end := s.end
+    if end < 0 || end > v.Len() {
+      end = v.Len()
+    }
+    for i := s.start; i < end; i++ {
+      indices = append(indices, i)
+    }
+    return indices, nil
+  }
+
+  for i := 0; i < v.Len(); i++ {
+    field, _, err := walk(Path{s.field}, v.Index(i).Addr(), modeRead)
+    if Code(err) == CodeNotFound {
+      continue
+    } else if err != nil {
+      return nil, err
+    }
+
+    b, err := Marshal(field.Interface())
+    if err != nil {
+      return nil, err
+    }
+
+    var str string
+    if bytes.HasPrefix(b, []byte(`"`)) && json.Unmarshal(b, &str) == nil {
+      b = []byte(str)
+    }
+    if string(b) == s.value {
+      indices = append(indices, i)
+    }
+  }
+  return indices, nil
+}
+
+// expand replaces the selectors in path with the indices of the elements they select
+func expand(path Path, pv reflect.Value) ([]Path, error) {
+  i := selectorIndex(path, pv)
+  if i < 0 {
+    return []Path{path}, nil
+  }
+
+  _, s, _ := parseSelector(path[i])
+  prefix := selectorContainer(path, pv)
+
+  container, _, err := walk(unescape(prefix), pv, modeRead)
+  if err != nil {
+    return nil, err
+  }
+  container, _, err = unwrap(container, false)
+  if err != nil {
+    return nil, err
+  }
+  if k := container.Elem().Kind(); k != reflect.Slice && k != reflect.Array {
+    return nil, newError(CodeBadRequest, "cannot select from type of '%v'", k)
+  }
+
+  indices, err := s.matches(container.Elem())
+  if err != nil {
+    return nil, err
+  }
+
+  var paths []Path
+  for _, index := range indices {
+    p := append(append(append(Path{}, prefix...), strconv.Itoa(index)), path[i+1:]...)
+
+    expanded, err := expand(p, pv)
+    if err != nil {
+      return nil, err
+    }
+    paths = append(paths, expanded...)
+  }
+  return paths, nil
+}
+
+/*
+serveSelected serves a request whose path has selectors by serving it at each
+path they expand to, in reverse for DELETE so earlier indices stay valid.  The
+responses, and values removed by DELETE, are returned as arrays in the order of
+r.Matches, unless every match was removed outright.  PUT and MOVE need exactly
+one match.
+*/
+func serveSelected(r *Request, path Path, pv reflect.Value) (*json.RawMessage, error) {
+  paths, err := expand(path, pv)
+  if err != nil {
+    return nil, err
+  }
+
+  switch r.Method {
+  case http.MethodPut, MethodMove:
+    if len(paths) == 0 {
+      return nil, newError(CodeNotFound, "nothing matches '%v'", path)
+    } else if len(paths) > 1 {
+      return nil, newError(CodeConflict, "%d values match '%v', expected one", len(paths), path)
+    }
+  case http.MethodDelete:
+    for i, j := 0, len(paths)-1; i < j; i, j = i+1, j-1 {
+      paths[i], paths[j] = paths[j], paths[i]
+    }
+  }
+
+  responses := make([]*json.RawMessage, len(paths))
+  removed := make([]*json.RawMessage, 0, len(paths))
+
+  for i, p := range paths {
+    sub := &Request{
+      Method: r.Method,
+      ContentType: r.ContentType,
+      Path: p,
+      Body: r.Body,
+      Options: r.Options,
+      unvalidated: r.unvalidated,
+    }
+
+    if responses[i], err = serve(sub, Path{}, pv); err != nil {
+      return nil, err
+    }
+    removed = append(removed, sub.Removed)
+  }
+
+  r.Matches = paths
+  if r.Method != http.MethodGet {
+    r.changed, _ = resolvePath(unescape(selectorContainer(path, pv)), pv)
+  }
+
+  if r.Method == http.MethodDelete {
+    bytes, _ := json.Marshal(removed)
+    r.Removed = (*json.RawMessage)(&bytes)
+
+    reset := false
+    for _, res := range responses {
+      reset = reset || res != nil
+    }
+    if !reset {
+      // nothing is left of removed elements
+      return nil, nil
+    }
+  }
+
+  bytes, _ := json.Marshal(responses)
+  return (*json.RawMessage)(&bytes), nil
+}
@@ -0,0 +1,190 @@
+package serveJSON
+
+import (
+  "testing"
+  "net/http"
+  "net/http/httptest"
+  "encoding/json"
+  "reflect"
+  "strings"
+)
+
+func TestParseSelector(t *testing.T) {
+  for _, c := range []struct {
+    token string
+    name string
+    expected *selector
+  }{
+    {"streams[name=kitchen]", "streams", &selector{field: "name", value: "kitchen"}},
+    {"[url=rtsp://a=b]", "", &selector{field: "url", value: "rtsp://a=b"}},
+    {"1:3", "", &selector{start: 1, end: 3, ranged: true}},
+    {"2:", "", &selector{start: 2, end: -1, ranged: true}},
+    {":", "", &selector{start: 0, end: -1, ranged: true}},
+    {"streams", "", nil},
+    {"1", "", nil},
+    {"[name]", "", nil},
+    {`\1:3`, "", nil},
+    {`\[name=kitchen]`, "", nil},
+  } {
+    name, s, ok := parseSelector(c.token)
+    if ok != (c.expected != nil) || name != c.name || !reflect.DeepEqual(s, c.expected) {
+      t.Errorf("'%s': expected '%s' %#v, got '%s' %#v", c.token, c.name, c.expected, name, s)
+    }
+  }
+}
+
+func TestServeJSONSelectors(t *testing.T) {
+  mirror := newTestMirror()
+  mirror.Streams = append(mirror.Streams, TestStream{Name: "garage", URL: "rtsp://garage"}, TestStream{Name: "kitchen", URL: "rtsp://kitchen2"})
+  mirror.Test.Array = []string{"a"}
+
+  for _, c := range []struct {
+    path Path
+    expected string
+    matches []Path
+  }{
+    {Path{"streams[name=kitchen]", "url"}, `["rtsp://kitchen","rtsp://kitchen2"]`, []Path{Path{"streams", "0", "url"}, Path{"streams", "3", "url"}}},
+    {Path{"streams", "[name=porch]"}, `[{"name":"porch","url":"rtsp://porch"}]`, []Path{Path{"streams", "1"}}},
+    {Path{"streams", "1:3", "name"}, `["porch","garage"]`, []Path{Path{"streams", "1", "name"}, Path{"streams", "2", "name"}}},
+    {Path{"streams", "3:9", "name"}, `["kitchen"]`, []Path{Path{"streams", "3", "name"}}},
+    {Path{"streams[name=attic]"}, `[]`, nil},
+    {Path{"test", "array", ":"}, `["a"]`, []Path{Path{"test", "array", "0"}}},
+  } {
+    req := &Request{Method: http.MethodGet, Path: c.path}
+    output, err := ServeJSON(req, mirror)
+
+    if err != nil {
+      t.Errorf("'%v': %v", c.path, err)
+    } else if string(*output) != c.expected {
+      t.Errorf("'%v': expected `%s`, got `%s`", c.path, c.expected, *output)
+    } else if !reflect.DeepEqual(req.Matches, c.matches) {
+      t.Errorf("'%v': expected matches %v, got %v", c.path, c.matches, req.Matches)
+    }
+  }
+
+  // only slices and arrays are selected from, so this is the name of a field
+  if _, err := ServeJSON(&Request{Method: http.MethodGet, Path: Path{"test[a=b]"}}, mirror); Code(err) != CodeNotFound {
+    t.Errorf("expected no such field, got %v", err)
+  }
+
+  if _, err := ServeJSON(&Request{Method: http.MethodPost, Path: Path{"streams[name=kitchen]", "url"}, Body: rawBody(`"rtsp://new"`)}, mirror); err != nil {
+    t.Error(err)
+  } else if mirror.Streams[0].URL != "rtsp://new" || mirror.Streams[3].URL != "rtsp://new" || mirror.Streams[1].URL != "rtsp://porch" {
+    t.Errorf("expected every match to be updated, got %v", mirror.Streams)
+  }
+
+  if _, err := ServeJSON(&Request{Method: MethodMove, Path: Path{"streams[name=garage]"}, Body: rawBody(`0`)}, mirror); err != nil {
+    t.Error(err)
+  } else if names := streamNames(mirror.Streams); !reflect.DeepEqual(names, []string{"garage", "kitchen", "porch", "kitchen"}) {
+    t.Errorf("expected the single match to move, got %v", names)
+  }
+
+  if _, err := ServeJSON(&Request{Method: MethodMove, Path: Path{"streams[name=kitchen]"}, Body: rawBody(`0`)}, mirror); Code(err) != CodeConflict {
+    t.Errorf("expected moving several matches to conflict, got %v", err)
+  }
+
+  req := &Request{Method: http.MethodDelete, Path: Path{"streams[name=kitchen]"}}
+  if output, err := ServeJSON(req, mirror); err != nil {
+    t.Error(err)
+  } else if output != nil {
+    t.Errorf("expected no response, got `%s`", *output)
+  } else if names := streamNames(mirror.Streams); !reflect.DeepEqual(names, []string{"garage", "porch"}) {
+    t.Errorf("expected every match to be deleted, got %v", names)
+  } else if !reflect.DeepEqual(req.Matches, []Path{Path{"streams", "3"}, Path{"streams", "1"}}) {
+    t.Errorf("expected deletes from the end first, got %v", req.Matches)
+  } else if !strings.Contains(string(*req.Removed), "rtsp://new") {
+    t.Errorf("expected removed values, got `%s`", *req.Removed)
+  }
+
+  if _, err := ServeJSON(&Request{Method: http.MethodPost, Path: Path{"streams", "0:2", "name"}, Body: rawBody(`7`)}, mirror); err == nil {
+    t.Errorf("expected bad body error, got none")
+  } else if names := streamNames(mirror.Streams); !reflect.DeepEqual(names, []string{"garage", "porch"}) {
+    t.Errorf("expected failed selection to be rolled back, got %v", names)
+  }
+}
+
+type TestSchedule struct {
+  Slots map[string]string `json:"slots"`
+  Lists map[string][]string `json:"lists"`
+}
+
+func TestSelectorMapKeys(t *testing.T) {
+  schedule := &TestSchedule{
+    Slots: map[string]string{"10:30": "coffee", ":": "colon", "a[b=c]": "literal", `\x`: "backslash"},
+    Lists: map[string][]string{"a": []string{"x", "y"}, "a[b=c]": []string{"z"}},
+  }
+
+  for _, c := range []struct {
+    path Path
+    expected string
+  }{
+    {Path{"slots", "10:30"}, `"coffee"`},
+    {Path{"slots", ":"}, `"colon"`},
+    {Path{"slots", "a[b=c]"}, `"literal"`},
+    {Path{"slots", `\10:30`}, `"coffee"`},
+    {Path{"slots", `\\x`}, `"backslash"`},
+    {Path{"lists", "a", "0:1"}, `["x"]`},
+    {Path{"lists", "a[b=c]"}, `[]`},
+    {Path{"lists", `\a[b=c]`}, `["z"]`},
+    {Path{"lists", `\a[b=c]`, ":"}, `["z"]`},
+  } {
+    if output, err := ServeJSON(&Request{Method: http.MethodGet, Path: c.path}, schedule); err != nil {
+      t.Errorf("'%v': %v", c.path, err)
+    } else if string(*output) != c.expected {
+      t.Errorf("'%v': expected `%s`, got `%s`", c.path, c.expected, *output)
+    }
+  }
+
+  if _, err := ServeJSON(&Request{Method: http.MethodDelete, Path: Path{"slots", ":"}}, schedule); err != nil {
+    t.Error(err)
+  } else if _, ok := schedule.Slots[":"]; ok || len(schedule.Slots) != 3 {
+    t.Errorf("expected only the key ':' deleted, got %v", schedule.Slots)
+  }
+
+  if _, err := ServeJSON(&Request{Method: http.MethodPost, Path: Path{"slots", "12:00"}, Body: rawBody(`"lunch"`)}, schedule); err != nil {
+    t.Error(err)
+  } else if schedule.Slots["12:00"] != "lunch" {
+    t.Errorf("expected the key '12:00' added, got %v", schedule.Slots)
+  }
+
+  if _, err := ServeJSON(&Request{Method: http.MethodPost, Path: Path{"lists", `\a[b=c]`, "0"}, Body: rawBody(`"w"`)}, schedule); err != nil {
+    t.Error(err)
+  } else if !reflect.DeepEqual(schedule.Lists["a[b=c]"], []string{"w"}) || !reflect.DeepEqual(schedule.Lists["a"], []string{"x", "y"}) {
+    t.Errorf("expected only the escaped key changed, got %v", schedule.Lists)
+  }
+
+  tree := NewTree(schedule)
+  w := httptest.NewRecorder()
+  tree.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/slots/10:30", nil))
+  if w.Code != 200 || schedule.Slots["a[b=c]"] != "literal" {
+    t.Errorf("expected 200, got %d `%s`", w.Code, w.Body.String())
+  } else if _, ok := schedule.Slots["10:30"]; ok {
+    t.Errorf("expected the key '10:30' deleted, got %v", schedule.Slots)
+  }
+}
+
+func TestTreeSelectors(t *testing.T) {
+  tree := NewTree(newTestMirror())
+  counter := TestCounter{make(chan *Request, 2)}
+  tree.Watch(counter)
+
+  version := tree.Version(Path{"test"})
+
+  w := httptest.NewRecorder()
+  tree.ServeHTTP(w, httptest.NewRequest("POST", "/streams%5Bname=porch%5D/url", strings.NewReader(`"rtsp://back"`)))
+
+  if w.Code != 200 || w.Body.String() != `["rtsp://back"]` {
+    t.Fatalf("expected 200, got %d `%s`", w.Code, w.Body.String())
+  }
+
+  req := <-counter.count
+  if b, _ := json.Marshal(req); !strings.Contains(string(b), `"matches":[["streams","1","url"]]`) {
+    t.Errorf("expected matches in the notification, got `%s`", b)
+  }
+  if v := tree.Version(Path{"test"}); v != version {
+    t.Errorf("expected unrelated nodes to keep their version")
+  }
+  if v := tree.Version(Path{"streams", "1"}); v == version {
+    t.Errorf("expected the selected node to change version")
+  }
+}
@@ -2,25 +2,30 @@ package serveJSON
 
 import (
   "net/http"
+  "encoding"
   "encoding/json"
+  "mime"
   "io/ioutil"
   "regexp"
   "fmt"
   "reflect"
   "sync"
-  "strings"
   "strconv"
+  "strings"
 )
 
-var typeCacheLock sync.Locker
-var typeCache map[reflect.Type]map[string]reflect.StructField
+type fieldKey struct {
+  t reflect.Type
+  name string
+}
+
+// typeCache holds the struct fields found by structField under their fieldKey
+var typeCache sync.Map
 
 var jsonTagParser *regexp.Regexp
 var pathParser *regexp.Regexp
 
 func init() {
-  typeCacheLock = &sync.Mutex{}
-  typeCache = make(map[reflect.Type]map[string]reflect.StructField)
   jsonTagParser = regexp.MustCompile("^([^,]+)(,.*)?$")
   pathParser = regexp.MustCompile("^/?([^/]+)(/.*)?$")
 }
@@ -32,14 +37,28 @@ type HandlerJSON struct {
 func (h HandlerJSON) ServeHTTP(w http.ResponseWriter, r *http.Request) {
   body, _ := ioutil.ReadAll(r.Body)
 
+  path, err := ParseURLPath(r.URL.EscapedPath())
+  if err != nil {
+    writeError(w, wrapError(CodeBadRequest, err))
+    return
+  }
+
+  options, err := ParseOptions(r.URL.Query())
+  if err != nil {
+    writeError(w, err)
+    return
+  }
+
   res, err := ServeJSON(&Request{
     Method: r.Method,
-    Path: strings.Split(r.URL.Path, "/")[1:], // the path always starts with a '/'
+    ContentType: r.Header.Get("Content-Type"),
+    Path: path,
+    Options: options,
     Body: (*json.RawMessage)(&body),
   }, h.Wrapped)
 
   if err != nil {
-    http.Error(w, err.Error(), 500)
+    writeError(w, err)
     return
   }
 
@@ -53,10 +72,31 @@ func (h HandlerJSON) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 type Request struct {
   Requestor string `json:"-"`
   Method string `json:"method,omitempty"`
-  Path []string `json:"path,omitempty"`
+  ContentType string `json:"contentType,omitempty"`
+  Path Path `json:"path,omitempty"`
+  IfMatch uint64 `json:"ifMatch,omitempty"`
+  Version uint64 `json:"version,omitempty"`
   Error error `json:"error,omitempty"`
+  Batch []*Request `json:"batch,omitempty"`
+  Options *Options `json:"options,omitempty"`
   Body *json.RawMessage `json:"body"`
   Response *json.RawMessage `json:"response,omitempty"`
+  // the value taken out by a DELETE
+  Removed *json.RawMessage `json:"removed,omitempty"`
+  // the paths from the root matched by any selectors in Path
+  Matches []Path `json:"matches,omitempty"`
+  // the path from the root with keyed elements given as selectors on their key
+  KeyPath Path `json:"keyPath,omitempty"`
+  // the path of the node changed by a successful mutation
+  changed Path
+  // part of a GET a Router combines from several mounts
+  partial bool
+  // served within a batch, which is validated once as a whole
+  unvalidated bool
+  // values of the request that watchers mustn't see, as they may be write only
+  concealed, concealedBody bool
+  // the whole node put back by an undo or redo, hidden fields and all
+  restored json.RawMessage
 }
 
 type Notifier interface {
@@ -72,98 +112,302 @@ func (m Muxer) Notify(req *Request) {
   }
 }
 
+func mediaType(contentType string) string {
+  if t, _, err := mime.ParseMediaType(contentType); err == nil {
+    return t
+  }
+  return contentType
+}
+
 func locked(lock sync.Locker, f func()) {
   lock.Lock()
   defer lock.Unlock()
   f()
 }
 
-func ServeJSON(r *Request, face interface{}) (*json.RawMessage, error) {
-  path := make([]string, len(r.Path))
-  copy(path, r.Path)
-  leaf := ""
+// cleanPath copies path, treating a single empty token as the root
+func cleanPath(p Path) Path {
+  path := make(Path, len(p))
+  copy(path, p)
 
   if len(path) == 1 && path[0] == "" {
     path = path[1:]
   }
+  return path
+}
+
+func ServeJSON(r *Request, face interface{}) (*json.RawMessage, error) {
+  if d, ok := face.(Dispatcher); ok && dispatchGenerated {
+    if res, err := dispatch(r, d); err != ErrNotGenerated {
+      return res, err
+    }
+  }
+
+  pv := reflect.ValueOf(face)
+
+  if pv.Kind() != reflect.Ptr {
+    return nil, newError(CodeInternal, "interface passed must be a ptr")
+  }
+
+  if r.Method == http.MethodGet {
+    return serve(r, Path{}, pv)
+  }
+
+  var res *json.RawMessage
+
+  // roll back anything the mutation or validation rejects, copying only what it may change
+  err := atomically(pv, changing(r.Method, cleanPath(r.Path), pv), func() (err error) {
+    res, err = serve(r, Path{}, pv)
+    return
+  })
+
+  if err != nil {
+    return nil, err
+  }
+  return res, nil
+}
+
+// serve handles r relative to base, leaving any rollback to the caller
+func serve(r *Request, base Path, pv reflect.Value) (*json.RawMessage, error) {
+  path := append(cleanPath(base), cleanPath(r.Path)...)
+  leaf := ""
+
+  if r.Method != MethodBatch && selectorIndex(path, pv) >= 0 {
+    return serveSelected(r, path, pv)
+  }
+
+  if r.Method != MethodBatch {
+    // later mutations by others may shift indices, so clients are told keys
+    var keyed Path
+    path, keyed = resolvePath(unescape(path), pv)
+
+    r.KeyPath = nil
+    if r.Method != http.MethodGet && !reflect.DeepEqual(path, keyed) {
+      r.KeyPath = keyed
+    }
+  }
 
   switch r.Method {
   case http.MethodGet:
   case http.MethodPost:
   case http.MethodPut:
-  case http.MethodDelete:
+    if inserting(path, pv) {
+      leaf, path = path[len(path)-1], path[:len(path)-1]
+    }
+  case http.MethodPatch:
+  case http.MethodDelete, MethodMove:
     if len(path) == 0 {
-      return nil, fmt.Errorf("unsuppored empty delete path")
+      return nil, newError(CodeMethodNotAllowed, "unsuppored empty %s path", strings.ToLower(r.Method))
     }
     leaf, path = path[len(path)-1], path[:len(path)-1]
+  case MethodBatch:
+    return serveBatch(r, path, pv)
   default:
-    return nil, fmt.Errorf("unsuppored method '%s'", r.Method)
+    return nil, newError(CodeMethodNotAllowed, "unsuppored method '%s'", r.Method)
   }
 
-  pv := reflect.ValueOf(face)
+  if r.Method == http.MethodGet {
+    if pe, err := helper(path, pv); err != nil {
+      return nil, err
+    } else if bytes, err := Marshal(pe.Interface()); err != nil {
+      return nil, err
+    } else if bytes, err = r.Options.apply(bytes); err != nil {
+      return nil, err
+    } else {
+      return (*json.RawMessage)(&bytes), nil
+    }
+  }
 
-  if pv.Kind() != reflect.Ptr {
-    return nil, fmt.Errorf("interface passed must be a ptr")
+  m := modeWrite
+  if r.Method != http.MethodDelete && r.Method != MethodMove {
+    m |= modeCreate
   }
 
-  if pe, err := helper(path, pv); err != nil {
+  pe, commit, err := walk(path, pv, m)
+  if err != nil {
     return nil, err
-  } else {
-    switch r.Method {
-    case http.MethodPost:
-      if r.Body == nil || len(*r.Body) == 0 {
-        return nil, fmt.Errorf("body is empty")
-      }
+  }
 
-      if err := json.Unmarshal(*r.Body, pe.Interface()); err != nil {
-        return nil, err
-      }
-    case http.MethodPut:
-      if inserted, err := putHelper(r.Body, pe); err != nil {
-        return nil, err
-      } else {
-        pe = inserted
-      }
-    case http.MethodDelete:
-      if err := deleteHelper(leaf, pe); err != nil {
-        return nil, err
-      }
-      // don't return anything on delete
-      return nil, nil
+  r.Removed = nil
+
+  res, err := mutate(r, leaf, pe)
+  if err != nil {
+    return nil, err
+  }
+  commit()
+
+  if !r.unvalidated {
+    if err := validate(pv, path); err != nil {
+      r.Removed = nil
+      return nil, err
+    }
+  }
+  r.changed = path
+
+  if !res.IsValid() {
+    // nothing is left of removed slice elements and map entries
+    return nil, nil
+  }
+
+  bytes, _ := Marshal(res.Interface())
+  return (*json.RawMessage)(&bytes), nil
+}
+
+// mutate applies a POST, PUT, PATCH, DELETE or MOVE to pe and returns the result
+func mutate(r *Request, leaf string, pe reflect.Value) (reflect.Value, error) {
+  node := pe
+  if r.Method == http.MethodDelete || r.Method == MethodMove {
+    // these change the child named leaf rather than pe
+    if child, _, err := walk(Path{leaf}, pe, modeInternal); err == nil {
+      node = child
+    }
+  }
+  if g, ok := getter(node.Elem()); ok {
+    return pe, setComputed(r, g)
+  }
+
+  switch r.Method {
+  case http.MethodPost:
+    if r.Body == nil || len(*r.Body) == 0 {
+      return pe, newError(CodeBadRequest, "body is empty")
     }
 
-    bytes, _ := json.Marshal(pe.Interface())
-    return (*json.RawMessage)(&bytes), nil
+    err := protect(pe, func() error {
+      return decode(*r.Body, pe)
+    })
+    if err != nil {
+      return pe, wrapError(CodeBadRequest, err)
+    }
+  case http.MethodPatch:
+    if r.Body == nil || len(*r.Body) == 0 {
+      return pe, newError(CodeBadRequest, "body is empty")
+    }
+
+    // anything the patches fail on without a code came from decoding the body
+    if mediaType(r.ContentType) == JSONPatchType {
+      if err := patchHelper(*r.Body, pe); err != nil {
+        return pe, wrapError(CodeBadRequest, err)
+      }
+    } else if err := protect(pe, func() error { return mergeHelper(*r.Body, pe) }); err != nil {
+      return pe, wrapError(CodeBadRequest, err)
+    }
+  case http.MethodPut:
+    return putHelper(r.Body, leaf, pe)
+  case MethodMove:
+    return moveHelper(r.Body, leaf, pe)
+  case http.MethodDelete:
+    removed, reset, err := deleteHelper(leaf, pe)
+    if err != nil {
+      return pe, err
+    }
+    if removed.IsValid() {
+      bytes, _ := Marshal(removed.Interface())
+      r.Removed = (*json.RawMessage)(&bytes)
+    }
+    return reset, nil
   }
+  return pe, nil
 }
 
-func deleteHelper(leaf string, pv reflect.Value) error {
+/*
+deleteHelper removes leaf from the value pointed to by pv.  Slice elements and
+map entries are removed outright, while struct fields and array elements are
+reset to their zero value, keeping any read only or hidden fields, and a
+pointer to them is returned as reset.  The removed value is returned unless it
+is write only.
+*/
+func deleteHelper(leaf string, pv reflect.Value) (removed reflect.Value, reset reflect.Value, err error) {
+  pv, commit, err := unwrap(pv, false)
+  if err != nil {
+    return
+  }
+  defer commit()
+
   v := pv.Elem()
   t := v.Type()
 
-  if t.Kind() != reflect.Slice {
-    return fmt.Errorf("cannot delete from type of '%v'", t.Kind())
-  }
+  var target reflect.Value
+  hidden := false
 
-  if i, err := strconv.Atoi(leaf); err != nil {
-    return err
-  } else {
-    if i < 0 || i >= v.Len() {
-      return fmt.Errorf("index out of bounds: %d", i)
+  switch t.Kind() {
+  case reflect.Map:
+    key, err := mapKey(leaf, t)
+    if err != nil {
+      return removed, reset, err
+    }
+    if removed = v.MapIndex(key); !removed.IsValid() {
+      return removed, reset, newError(CodeNotFound, "key not found: '%s'", leaf)
+    }
+    v.SetMapIndex(key, reflect.Value{})
+    return removed, reset, nil
+  case reflect.Slice:
+    i, err := elementIndex(leaf, v)
+    if err != nil {
+      return removed, reset, err
     }
 
+    removed = reflect.New(t.Elem()).Elem()
+    removed.Set(v.Index(i))
+
     dex := reflect.Copy(v.Slice(i, v.Len()), v.Slice(i+1, v.Len()))
     v.Set(v.Slice(0,i+dex))
+    return removed, reset, nil
+  case reflect.Array:
+    if target, err = array_helper(leaf, v); err != nil {
+      return
+    }
+  case reflect.Struct:
+    var f reflect.StructField
+    if target, f, err = struct_helper(leaf, v, t); err != nil {
+      return
+    }
+    if err = checkAccess(f, leaf, modeWrite); err != nil {
+      return
+    }
+    hidden = hasTag(f, WriteOnly)
+  default:
+    return removed, reset, newError(CodeMethodNotAllowed, "cannot delete from type of '%v'", t.Kind())
   }
-  return nil
+
+  if !target.CanSet() {
+    return removed, reset, newError(CodeInternal, "cannot set value of type '%v'", target.Type())
+  }
+
+  removed = reflect.New(target.Type()).Elem()
+  removed.Set(target)
+  target.Set(reflect.Zero(target.Type()))
+  restoreProtected(target, removed)
+
+  if hidden {
+    return reflect.Value{}, target.Addr(), nil
+  }
+  return removed, target.Addr(), nil
 }
 
-func putHelper(body *json.RawMessage, pv reflect.Value) (reflect.Value, error) {
+/*
+putHelper adds the body to the slice pointed to by pv, inserting it before the
+index leaf or appending it if leaf is empty or "-".
+*/
+func putHelper(body *json.RawMessage, leaf string, pv reflect.Value) (reflect.Value, error) {
+  if body == nil || len(*body) == 0 {
+    return pv, newError(CodeBadRequest, "body is empty")
+  }
+
+  pv, commit, err := unwrap(pv, true)
+  if err != nil {
+    return pv, err
+  }
+
   v := pv.Elem() // pointer
   t := v.Type()
 
   if t.Kind() != reflect.Slice {
-    return pv, fmt.Errorf("cannot put to type of '%v'", t.Kind())
+    return pv, newError(CodeMethodNotAllowed, "cannot put to type of '%v'", t.Kind())
+  }
+
+  i, err := insertIndex(leaf, v)
+  if err != nil {
+    return pv, err
   }
 
   elemType := t.Elem()
@@ -172,13 +416,15 @@ func putHelper(body *json.RawMessage, pv reflect.Value) (reflect.Value, error) {
 
   // log.Printf("body: `%s`, item: %v", *body, item.Elem().Kind())
 
-  if err := json.Unmarshal(*body, item.Interface()); err != nil {
-    return pv, err
+  if err := decode(*body, item); err != nil {
+    return pv, wrapError(CodeBadRequest, err)
   }
+  restoreProtected(item.Elem(), reflect.Value{})
 
   // log.Printf("item: `%v`", item.Elem().Interface())
 
-  v.Set(reflect.Append(v, item.Elem()))
+  insert(v, i, item.Elem())
+  commit()
 
   // log.Printf("array: %v", pv.Interface())
   // pv.Set(v)
@@ -186,113 +432,275 @@ func putHelper(body *json.RawMessage, pv reflect.Value) (reflect.Value, error) {
   return item.Elem(), nil
 }
 
+// mode controls how walk treats the values it passes through
+type mode int
+
+const modeRead mode = 0
+
+const (
+  // refuse read only fields rather than write only ones
+  modeWrite mode = 1 << iota
+  // allocate nil values and add missing map keys
+  modeCreate
+  // ignore access tags, for use by serveJSON itself
+  modeInternal
+)
+
 func helper(path []string, pv reflect.Value) (reflect.Value, error) {
+  pe, _, err := walk(path, pv, modeRead)
+  return pe, err
+}
+
+/*
+walk resolves path starting at pv and returns a pointer to the addressed value.
+Map entries aren't addressable so they are copied out, and the returned commit
+function writes them back; call it after modifying the result.  Fields are
+checked against their access tags according to m.
+*/
+func walk(path []string, pv reflect.Value, m mode) (reflect.Value, func(), error) {
+  if c := compiled(pv.Type(), path); c != nil {
+    if pe, commit, err := c.walk(pv, m); err == nil {
+      return pe, commit, nil
+    }
+    // the reflective walk explains what is missing
+  }
+  return walkReflect(path, pv, m)
+}
+
+// walkReflect resolves path by looking up each token in the type it reaches
+func walkReflect(path []string, pv reflect.Value, m mode) (reflect.Value, func(), error) {
+  create := m&modeCreate != 0
+
+  var commits []func()
+
+  commit := func() {
+    for i := len(commits) - 1; i >= 0; i-- {
+      commits[i]()
+    }
+  }
+
   for len(path) > 0 {
+    var err error
+
+    var c func()
+    if pv, c, err = unwrap(pv, create); err != nil {
+      return pv, commit, fmt.Errorf("path not found '%s', %w", Path(path), err)
+    }
+    commits = append(commits, c)
+
+    if g, ok := getter(pv.Elem()); ok {
+      // paths below computed nodes read into the computed value
+      if m&modeWrite != 0 {
+        return pv, commit, newError(CodeMethodNotAllowed, "cannot write below computed value at '%s'", Path(path))
+      }
+      if pv, err = getValue(g); err != nil {
+        return pv, commit, err
+      }
+      if pv, _, err = unwrap(pv, false); err != nil {
+        return pv, commit, err
+      }
+    }
+
     v := pv.Elem()
     t := v.Type()
 
-    var err error
-
     switch t.Kind() {
     case reflect.Struct:
-      pv, err = struct_helper(path[0], v, t)
+      var f reflect.StructField
+      if pv, f, err = struct_helper(path[0], v, t); err == nil && m&modeInternal == 0 {
+        err = checkAccess(f, path[0], m)
+      }
     case reflect.Array:
       pv, err = array_helper(path[0], v)
     case reflect.Slice:
       pv, err = array_helper(path[0], v)
-    // case reflect.Map:
-    //   pv, err = map_helper(path[0], v)
+    case reflect.Map:
+      if pv, c, err = map_helper(path[0], v, create); err == nil {
+        commits = append(commits, c)
+      }
     default:
-      err = fmt.Errorf("path not found '%s', '%v'", strings.Join(path, "/"), t.Kind())
+      err = newError(CodeNotFound, "path not found '%s', '%v'", Path(path), t.Kind())
     }
     if err != nil {
-      return pv, err
+      return pv, commit, err
     }
 
     path = path[1:]
 
-    if pv.Kind() != reflect.Ptr {
+    if pv.CanAddr() {
       pv = pv.Addr()
     }
   }
-  return pv, nil
+  return pv, commit, nil
 }
 
-// func map_helper(index string, v reflect.Value) (reflect.Value, error) {
-//   keyType := v.Type().Key()
-//   valType := v.Type().Elem()
-//
-//   if keyType.Kind() != reflect.String {
-//     return v, fmt.Errorf("only maps with key type of string are supported")
-//   }
-//
-//
-// }
+/*
+deref follows pv through any pointers it points to so that the result points
+at a non-pointer value.  Nil pointers are allocated when create is set.
+*/
+func deref(pv reflect.Value, create bool) (reflect.Value, error) {
+  for pv.Elem().Kind() == reflect.Ptr {
+    p := pv.Elem()
+
+    if p.IsNil() {
+      if !create {
+        return pv, newError(CodeNotFound, "value is nil")
+      }
+      if !p.CanSet() {
+        return pv, newError(CodeInternal, "cannot allocate value of type '%v'", p.Type())
+      }
+      p.Set(reflect.New(p.Type().Elem()))
+    }
+    pv = p
+  }
+  return pv, nil
+}
 
-func array_helper(index string, v reflect.Value) (reflect.Value, error) {
-  var err error
-  i := 0
+func map_helper(index string, v reflect.Value, create bool) (reflect.Value, func(), error) {
+  t := v.Type()
 
-  if i, err = strconv.Atoi(index); err != nil {
-    return v, err
+  key, err := mapKey(index, t)
+  if err != nil {
+    return v, nil, err
   }
+  return mapEntry(key, index, v, create)
+}
 
-  if i < 0 || i >= v.Len() {
-    return v, fmt.Errorf("index '%d' out of bounds", i)
-  }
+// mapEntry copies the entry of the map v at key out, as map_helper does
+func mapEntry(key reflect.Value, index string, v reflect.Value, create bool) (reflect.Value, func(), error) {
+  t := v.Type()
+  item := reflect.New(t.Elem())
 
-  v = v.Index(i)
+  if existing := v.MapIndex(key); existing.IsValid() {
+    item.Elem().Set(existing)
+  } else if !create {
+    return v, nil, newError(CodeNotFound, "key not found: '%s'", index)
+  }
 
-  return v, nil
+  return item, func() {
+    if v.IsNil() {
+      v.Set(reflect.MakeMap(t))
+    }
+    v.SetMapIndex(key, item.Elem())
+  }, nil
 }
 
-func struct_helper(fieldName string, v reflect.Value, t reflect.Type) (reflect.Value, error) {
-  var fields map[string]reflect.StructField
-  ok := false
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
 
-  var f reflect.StructField
-  found := false
+// mapKey converts a path segment into a key for maps of type t, following the
+// same rules encoding/json uses for object keys.
+func mapKey(index string, t reflect.Type) (reflect.Value, error) {
+  kt := t.Key()
 
-  locked(typeCacheLock, func() {
-    if fields, ok = typeCache[t]; !ok {
-      fields = make(map[string]reflect.StructField)
-      typeCache[t] = fields
+  if reflect.PtrTo(kt).Implements(textUnmarshalerType) {
+    key := reflect.New(kt)
+    if err := key.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(index)); err != nil {
+      return reflect.Value{}, &Error{Code: CodeNotFound, Message: fmt.Sprintf("invalid key '%s'", index), Err: err}
     }
-    f, found = fields[fieldName]
-  })
+    return key.Elem(), nil
+  }
 
-  inCache := found
-
-  for i := 0; i < t.NumField() && !found; i++ {
-    f = t.Field(i)
-
-    if json, ok := f.Tag.Lookup("json"); ok {
-      if parsed := jsonTagParser.FindStringSubmatch(json); len(parsed) > 1 {
-        if parsed[1] == fieldName {
-          found = true
-          break
-        } else if len(parsed) > 2 || parsed[1] != "" {
-          // our fieldName doesn't match the named json field, don't check the field.Name
-          continue
-        }
-        // else fallthrough
-      }
+  switch kt.Kind() {
+  case reflect.String:
+    return reflect.ValueOf(index).Convert(kt), nil
+  case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+    i, err := strconv.ParseInt(index, 10, kt.Bits())
+    if err != nil {
+      return reflect.Value{}, &Error{Code: CodeNotFound, Message: fmt.Sprintf("invalid key '%s'", index), Err: err}
+    }
+    return reflect.ValueOf(i).Convert(kt), nil
+  case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+    i, err := strconv.ParseUint(index, 10, kt.Bits())
+    if err != nil {
+      return reflect.Value{}, &Error{Code: CodeNotFound, Message: fmt.Sprintf("invalid key '%s'", index), Err: err}
     }
+    return reflect.ValueOf(i).Convert(kt), nil
+  }
+  return reflect.Value{}, newError(CodeInternal, "unsupported map key type '%v'", kt)
+}
+
+func array_helper(index string, v reflect.Value) (reflect.Value, error) {
+  i, err := elementIndex(index, v)
+  if err != nil {
+    return v, err
+  }
+
+  return v.Index(i), nil
+}
 
-    if f.Name == fieldName {
-      found = true
-      break
+// fieldName returns the name a struct field is marshalled under, if any
+func fieldName(f reflect.StructField) (string, bool) {
+  if f.PkgPath != "" {
+    return "", false
+  }
+
+  if json, ok := f.Tag.Lookup("json"); ok {
+    if json == "-" {
+      return "", false
+    }
+    if parsed := jsonTagParser.FindStringSubmatch(json); len(parsed) > 1 && parsed[1] != "" {
+      return parsed[1], true
     }
   }
+  return f.Name, true
+}
 
-  if !found {
-    return v, fmt.Errorf("field name not found: '%s'", fieldName)
+/*
+promoted reports whether f is an embedded struct without a JSON name, whose
+fields are marshalled as its parent's.  Like encoding/json, the fields of an
+unexported one can only be reached that way, and only if it isn't a pointer.
+*/
+func promoted(f reflect.StructField) bool {
+  if !f.Anonymous {
+    return false
   }
-  if !inCache {
-    locked(typeCacheLock, func() {
-      fields[fieldName] = f
-    })
+  if tag := f.Tag.Get("json"); tag == "-" || strings.Split(tag, ",")[0] != "" {
+    return false
+  }
+
+  t := f.Type
+  if t.Kind() == reflect.Ptr && f.PkgPath == "" {
+    t = t.Elem()
   }
+  return t.Kind() == reflect.Struct
+}
 
-  return v.FieldByIndex(f.Index), nil
+func struct_helper(name string, v reflect.Value, t reflect.Type) (reflect.Value, reflect.StructField, error) {
+  f, err := structField(name, t)
+  if err != nil {
+    return v, f, err
+  }
+  return v.FieldByIndex(f.Index), f, nil
+}
+
+// structField looks up the field of struct type t marshalled under name
+func structField(name string, t reflect.Type) (reflect.StructField, error) {
+  key := fieldKey{t, name}
+  if f, ok := typeCache.Load(key); ok {
+    return f.(reflect.StructField), nil
+  }
+
+  for i := 0; i < t.NumField(); i++ {
+    f := t.Field(i)
+
+    if n, ok := fieldName(f); ok && n == name {
+      typeCache.Store(key, f)
+      return f, nil
+    }
+  }
+
+  // then the fields promoted from embedded structs that can be followed
+  for i := 0; i < t.NumField(); i++ {
+    f := t.Field(i)
+    if !promoted(f) || f.Type.Kind() != reflect.Struct {
+      continue
+    }
+
+    if inner, err := structField(name, f.Type); err == nil {
+      inner.Index = append([]int{i}, inner.Index...)
+      typeCache.Store(key, inner)
+      return inner, nil
+    }
+  }
+  return reflect.StructField{}, newError(CodeNotFound, "field name not found: '%s'", name)
 }
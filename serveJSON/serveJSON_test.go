@@ -4,6 +4,8 @@ import (
   "testing"
   "net/http"
   "encoding/json"
+  "strings"
+  "fmt"
 )
 
 type TestStruct struct {
@@ -19,8 +21,16 @@ type TestStruct2 struct {
 
 var tester *TestStruct
 var tester2 *TestStruct2
+var accounts *TestAccounts
+var cameras *TestCameras
+var home *TestHome
 
 func init() {
+  resetTesters()
+}
+
+// resetTesters sets the shared test data back to how the tests expect it
+func resetTesters() {
   tester = &TestStruct{
     Visible: false,
     Integer: 42,
@@ -31,6 +41,42 @@ func init() {
   tester2 = &TestStruct2{
     Test: tester,
   }
+
+  accounts = &TestAccounts{
+    Accounts: []TestAccount{
+      TestAccount{Name: "a", Owner: "root", Password: "pa", Secret: "sa", Ignored: "ia"},
+    },
+    Primary: TestAccount{Name: "p", Owner: "root", Password: "pp", Secret: "sp"},
+    Server: &TestStruct{Integer: 1},
+  }
+
+  cameras = &TestCameras{
+    Cameras: []TestCamera{
+      TestCamera{Name: "kitchen", URL: "rtsp://kitchen"},
+      TestCamera{Name: "porch", URL: "rtsp://porch"},
+      TestCamera{Name: "garage", URL: "rtsp://garage"},
+    },
+  }
+
+  home = &TestHome{
+    Faces: TestFaces{
+      Seen: &TestFace{Name: "don", Probability: 0.9},
+      History: []TestFace{TestFace{Name: "a"}, TestFace{Name: "b"}},
+      Counts: map[string]int{"don": 3},
+    },
+    Mirror: newTestMirror(),
+    Extra: map[string]interface{}{"x": 1.},
+    Cameras: []TestCamera{TestCamera{Name: "porch", URL: "rtsp://porch"}},
+  }
+}
+
+// rawBody returns s as the body of a request, or nil if it is empty
+func rawBody(s string) *json.RawMessage {
+  if s == "" {
+    return nil
+  }
+  b := json.RawMessage(s)
+  return &b
 }
 
 func TestServeJSONGET(t *testing.T) {
@@ -242,6 +288,8 @@ func TestServeJSONPUT(t *testing.T) {
 }
 
 func TestServeJSONDelete(t *testing.T) {
+  resetTesters()
+
   deleteTest := &TestStruct{
     Visible: false,
     Integer: 42,
@@ -277,13 +325,18 @@ func TestServeJSONDelete(t *testing.T) {
     t.Errorf("expected `%v` got `%v`", []string{"one","two","three"}, o)
   }
 
-  output, err = ServeJSON(&Request{
+  req := &Request{
     Method: http.MethodDelete,
-    Path: []string{"visible"},
-  }, deleteTest)
+    Path: []string{"integer"},
+  }
+  output, err = ServeJSON(req, deleteTest)
 
-  if err == nil {
-    t.Errorf("expected error, delete from non-slice, got none")
+  if err != nil {
+    t.Error(err)
+  } else if deleteTest.Integer != 0 || output == nil || string(*output) != "0" {
+    t.Errorf("expected field to be reset to zero, got %d and %v", deleteTest.Integer, output)
+  } else if req.Removed == nil || string(*req.Removed) != "42" {
+    t.Errorf("expected removed value to be reported, got %v", req.Removed)
   }
 
   output, err = ServeJSON(&Request{
@@ -390,13 +443,567 @@ func TestServeJSONPOSTEmptyBody(t *testing.T) {
 }
 
 func TestServeJSONPatch(t *testing.T) {
+  body := []byte(`{"integer": 7, "visible": null, "array": ["a"]}`)
+
+  patchTester := &TestStruct{
+    Visible: true,
+    Integer: 42,
+    Array: []string{"zero", "one"},
+    NoTag: true,
+  }
+
+  output, err := ServeJSON(&Request{
+    Method: http.MethodPatch,
+    Body: (*json.RawMessage)(&body),
+    Path: []string{},
+  }, patchTester)
+
+  var out TestStruct
+
+  if err != nil {
+    t.Error(err)
+  } else if output == nil {
+    t.Errorf("output is nil")
+  } else if err = json.Unmarshal(*output, &out); err != nil {
+    t.Error(err)
+  } else if out.Integer != 7 || out.Visible || !out.NoTag || len(out.Array) != 1 || out.Array[0] != "a" {
+    t.Errorf("unexpected merge result `%s`", *output)
+  }
+
+  nested := []byte(`{"test": {"integer": 9}}`)
+  patchTester2 := &TestStruct2{Test: patchTester}
+
+  output, err = ServeJSON(&Request{
+    Method: http.MethodPatch,
+    Body: (*json.RawMessage)(&nested),
+    Path: []string{},
+  }, patchTester2)
+
+  if err != nil {
+    t.Error(err)
+  } else if patchTester.Integer != 9 || !patchTester.NoTag {
+    t.Errorf("expected nested merge to keep other fields, got %#v", patchTester)
+  }
+
+  scalar := []byte(`true`)
+
+  output, err = ServeJSON(&Request{
+    Method: http.MethodPatch,
+    Body: (*json.RawMessage)(&scalar),
+    Path: []string{"visible"},
+  }, patchTester)
+
+  if err != nil {
+    t.Error(err)
+  } else if output == nil || string(*output) != "true" {
+    t.Errorf("expected 'true' got '%v'", output)
+  }
+}
+
+func TestServeJSONPatchMap(t *testing.T) {
+  m := &map[string]int{"one": 1, "two": 2}
+  body := []byte(`{"one": null, "three": 3}`)
+
+  _, err := ServeJSON(&Request{
+    Method: http.MethodPatch,
+    Body: (*json.RawMessage)(&body),
+    Path: []string{},
+  }, m)
+
+  if err != nil {
+    t.Error(err)
+  } else if _, ok := (*m)["one"]; ok || (*m)["two"] != 2 || (*m)["three"] != 3 {
+    t.Errorf("unexpected merge result %v", *m)
+  }
+}
+
+func TestServeJSONPatchErrors(t *testing.T) {
   _, err := ServeJSON(&Request{
     Method: http.MethodPatch,
     Body: nil,
     Path: []string{"visible"},
   }, tester)
 
+  if err == nil {
+    t.Errorf("expected empty body error, got none")
+  }
+
+  body := []byte(`{"unknown": 1}`)
+
+  _, err = ServeJSON(&Request{
+    Method: http.MethodPatch,
+    Body: (*json.RawMessage)(&body),
+    Path: []string{},
+  }, tester)
+
+  if err == nil {
+    t.Errorf("expected unknown field error, got none")
+  }
+}
+
+func TestServeJSONInvalidMethod(t *testing.T) {
+  _, err := ServeJSON(&Request{
+    Method: http.MethodOptions,
+    Body: nil,
+    Path: []string{"visible"},
+  }, tester)
+
   if err == nil {
     t.Errorf("expected invalid method error, got no such error")
   }
 }
+
+type TestKey struct {
+  A, B string
+}
+
+func (k *TestKey) UnmarshalText(text []byte) error {
+  parts := strings.SplitN(string(text), ":", 2)
+  if len(parts) != 2 {
+    return fmt.Errorf("invalid key '%s'", text)
+  }
+  k.A, k.B = parts[0], parts[1]
+  return nil
+}
+
+func (k TestKey) MarshalText() ([]byte, error) {
+  return []byte(k.A + ":" + k.B), nil
+}
+
+type TestMapStruct struct {
+  Named map[string]TestStruct `json:"named"`
+  Numbered map[int]string `json:"numbered"`
+  Keyed map[TestKey]int `json:"keyed"`
+}
+
+func TestServeJSONMap(t *testing.T) {
+  mapTester := &TestMapStruct{
+    Named: map[string]TestStruct{
+      "first": TestStruct{Integer: 1, Array: []string{"a", "b"}},
+    },
+    Numbered: map[int]string{1: "one"},
+    Keyed: map[TestKey]int{TestKey{"x", "y"}: 5},
+  }
+
+  output, err := ServeJSON(&Request{
+    Method: http.MethodGet,
+    Path: []string{"named", "first", "integer"},
+  }, mapTester)
+
+  if err != nil {
+    t.Error(err)
+  } else if output == nil || string(*output) != "1" {
+    t.Errorf("expected '1', got %v", output)
+  }
+
+  output, err = ServeJSON(&Request{
+    Method: http.MethodGet,
+    Path: []string{"numbered", "1"},
+  }, mapTester)
+
+  if err != nil {
+    t.Error(err)
+  } else if output == nil || string(*output) != `"one"` {
+    t.Errorf("expected '\"one\"', got %v", output)
+  }
+
+  output, err = ServeJSON(&Request{
+    Method: http.MethodGet,
+    Path: []string{"keyed", "x:y"},
+  }, mapTester)
+
+  if err != nil {
+    t.Error(err)
+  } else if output == nil || string(*output) != "5" {
+    t.Errorf("expected '5', got %v", output)
+  }
+
+  _, err = ServeJSON(&Request{
+    Method: http.MethodGet,
+    Path: []string{"named", "second"},
+  }, mapTester)
+
+  if err == nil {
+    t.Errorf("expected key not found error, got none")
+  }
+
+  _, err = ServeJSON(&Request{
+    Method: http.MethodGet,
+    Path: []string{"numbered", "one"},
+  }, mapTester)
+
+  if err == nil {
+    t.Errorf("expected invalid key error, got none")
+  }
+}
+
+func TestServeJSONMapPOST(t *testing.T) {
+  mapTester := &TestMapStruct{
+    Named: map[string]TestStruct{
+      "first": TestStruct{Integer: 1, Array: []string{"a", "b"}},
+    },
+  }
+
+  body := []byte(`7`)
+
+  _, err := ServeJSON(&Request{
+    Method: http.MethodPost,
+    Body: (*json.RawMessage)(&body),
+    Path: []string{"named", "first", "integer"},
+  }, mapTester)
+
+  if err != nil {
+    t.Error(err)
+  } else if mapTester.Named["first"].Integer != 7 {
+    t.Errorf("expected nested map value to be updated, got %v", mapTester.Named["first"])
+  }
+
+  body = []byte(`{"integer": 2}`)
+
+  _, err = ServeJSON(&Request{
+    Method: http.MethodPost,
+    Body: (*json.RawMessage)(&body),
+    Path: []string{"named", "second"},
+  }, mapTester)
+
+  if err != nil {
+    t.Error(err)
+  } else if mapTester.Named["second"].Integer != 2 {
+    t.Errorf("expected new key to be created, got %v", mapTester.Named)
+  }
+
+  body = []byte(`"two"`)
+
+  _, err = ServeJSON(&Request{
+    Method: http.MethodPost,
+    Body: (*json.RawMessage)(&body),
+    Path: []string{"numbered", "2"},
+  }, mapTester)
+
+  if err != nil {
+    t.Error(err)
+  } else if mapTester.Numbered[2] != "two" {
+    t.Errorf("expected nil map to be allocated with new key, got %v", mapTester.Numbered)
+  }
+
+  body = []byte(`"c"`)
+
+  _, err = ServeJSON(&Request{
+    Method: http.MethodPut,
+    Body: (*json.RawMessage)(&body),
+    Path: []string{"named", "first", "array"},
+  }, mapTester)
+
+  if err != nil {
+    t.Error(err)
+  } else if a := mapTester.Named["first"].Array; len(a) != 3 || a[2] != "c" {
+    t.Errorf("expected nested map array to be appended to, got %v", a)
+  }
+}
+
+func TestServeJSONMapDelete(t *testing.T) {
+  mapTester := &TestMapStruct{
+    Named: map[string]TestStruct{
+      "first": TestStruct{Integer: 1, Array: []string{"a", "b"}},
+      "second": TestStruct{Integer: 2},
+    },
+  }
+
+  _, err := ServeJSON(&Request{
+    Method: http.MethodDelete,
+    Path: []string{"named", "second"},
+  }, mapTester)
+
+  if err != nil {
+    t.Error(err)
+  } else if _, ok := mapTester.Named["second"]; ok || len(mapTester.Named) != 1 {
+    t.Errorf("expected key to be removed, got %v", mapTester.Named)
+  }
+
+  _, err = ServeJSON(&Request{
+    Method: http.MethodDelete,
+    Path: []string{"named", "first", "array", "0"},
+  }, mapTester)
+
+  if err != nil {
+    t.Error(err)
+  } else if a := mapTester.Named["first"].Array; len(a) != 1 || a[0] != "b" {
+    t.Errorf("expected nested array element to be removed, got %v", a)
+  }
+
+  _, err = ServeJSON(&Request{
+    Method: http.MethodDelete,
+    Path: []string{"named", "missing"},
+  }, mapTester)
+
+  if err == nil {
+    t.Errorf("expected key not found error, got none")
+  }
+}
+
+func TestServeJSONNilPointer(t *testing.T) {
+  nilTester := &TestStruct2{}
+
+  output, err := ServeJSON(&Request{
+    Method: http.MethodGet,
+    Path: []string{"test"},
+  }, nilTester)
+
+  if err != nil {
+    t.Error(err)
+  } else if output == nil || string(*output) != "null" {
+    t.Errorf("expected 'null', got %v", output)
+  }
+
+  _, err = ServeJSON(&Request{
+    Method: http.MethodGet,
+    Path: []string{"test", "visible"},
+  }, nilTester)
+
+  if err == nil {
+    t.Errorf("expected not found error reading through nil, got none")
+  }
+
+  _, err = ServeJSON(&Request{
+    Method: http.MethodDelete,
+    Path: []string{"test", "array", "0"},
+  }, nilTester)
+
+  if err == nil {
+    t.Errorf("expected not found error deleting through nil, got none")
+  } else if nilTester.Test != nil {
+    t.Errorf("expected delete not to allocate, got %#v", nilTester.Test)
+  }
+
+  body := []byte(`true`)
+
+  _, err = ServeJSON(&Request{
+    Method: http.MethodPost,
+    Body: (*json.RawMessage)(&body),
+    Path: []string{"test", "visible"},
+  }, nilTester)
+
+  if err != nil {
+    t.Error(err)
+  } else if nilTester.Test == nil || !nilTester.Test.Visible {
+    t.Errorf("expected pointer to be allocated and written, got %#v", nilTester.Test)
+  }
+
+  body = []byte(`null`)
+
+  _, err = ServeJSON(&Request{
+    Method: http.MethodPost,
+    Body: (*json.RawMessage)(&body),
+    Path: []string{"test"},
+  }, nilTester)
+
+  if err != nil {
+    t.Error(err)
+  } else if nilTester.Test != nil {
+    t.Errorf("expected pointer to be reset to nil, got %#v", nilTester.Test)
+  }
+
+  body = []byte(`"first"`)
+
+  _, err = ServeJSON(&Request{
+    Method: http.MethodPut,
+    Body: (*json.RawMessage)(&body),
+    Path: []string{"test", "array"},
+  }, nilTester)
+
+  if err != nil {
+    t.Error(err)
+  } else if nilTester.Test == nil || len(nilTester.Test.Array) != 1 {
+    t.Errorf("expected nil pointer and slice to be allocated, got %#v", nilTester.Test)
+  }
+}
+
+func TestServeJSONNilMapOfPointers(t *testing.T) {
+  m := &map[string]*TestStruct{}
+  body := []byte(`3`)
+
+  _, err := ServeJSON(&Request{
+    Method: http.MethodPost,
+    Body: (*json.RawMessage)(&body),
+    Path: []string{"new", "integer"},
+  }, m)
+
+  if err != nil {
+    t.Error(err)
+  } else if p := (*m)["new"]; p == nil || p.Integer != 3 {
+    t.Errorf("expected map entry to be allocated, got %v", *m)
+  }
+}
+
+type TestDynamic struct {
+  Config interface{} `json:"config"`
+  Blob json.RawMessage `json:"blob"`
+  Plugins map[string]interface{} `json:"plugins"`
+}
+
+func TestServeJSONDynamic(t *testing.T) {
+  dynamicTester := &TestDynamic{
+    Config: map[string]interface{}{
+      "name": "kitchen",
+      "list": []interface{}{"a", "b"},
+    },
+    Blob: json.RawMessage(`{"nested": {"value": 1}, "items": [1, 2]}`),
+    Plugins: map[string]interface{}{
+      "weather": &TestStruct{Integer: 3},
+    },
+  }
+
+  output, err := ServeJSON(&Request{
+    Method: http.MethodGet,
+    Path: []string{"config", "list", "1"},
+  }, dynamicTester)
+
+  if err != nil {
+    t.Error(err)
+  } else if output == nil || string(*output) != `"b"` {
+    t.Errorf("expected '\"b\"', got %v", output)
+  }
+
+  output, err = ServeJSON(&Request{
+    Method: http.MethodGet,
+    Path: []string{"blob", "nested", "value"},
+  }, dynamicTester)
+
+  if err != nil {
+    t.Error(err)
+  } else if output == nil || string(*output) != "1" {
+    t.Errorf("expected '1', got %v", output)
+  }
+
+  output, err = ServeJSON(&Request{
+    Method: http.MethodGet,
+    Path: []string{"plugins", "weather", "integer"},
+  }, dynamicTester)
+
+  if err != nil {
+    t.Error(err)
+  } else if output == nil || string(*output) != "3" {
+    t.Errorf("expected '3', got %v", output)
+  }
+
+  body := []byte(`"c"`)
+
+  _, err = ServeJSON(&Request{
+    Method: http.MethodPut,
+    Body: (*json.RawMessage)(&body),
+    Path: []string{"config", "list"},
+  }, dynamicTester)
+
+  if err != nil {
+    t.Error(err)
+  } else if l := dynamicTester.Config.(map[string]interface{})["list"].([]interface{}); len(l) != 3 || l[2] != "c" {
+    t.Errorf("expected list to be appended to, got %v", l)
+  }
+
+  body = []byte(`2`)
+
+  _, err = ServeJSON(&Request{
+    Method: http.MethodPost,
+    Body: (*json.RawMessage)(&body),
+    Path: []string{"blob", "nested", "value"},
+  }, dynamicTester)
+
+  var blob struct {
+    Nested struct {
+      Value int `json:"value"`
+    } `json:"nested"`
+  }
+
+  if err != nil {
+    t.Error(err)
+  } else if err = json.Unmarshal(dynamicTester.Blob, &blob); err != nil {
+    t.Error(err)
+  } else if blob.Nested.Value != 2 {
+    t.Errorf("expected blob to be re-encoded, got `%s`", dynamicTester.Blob)
+  }
+
+  _, err = ServeJSON(&Request{
+    Method: http.MethodDelete,
+    Path: []string{"blob", "items", "0"},
+  }, dynamicTester)
+
+  if err != nil {
+    t.Error(err)
+  } else if !strings.Contains(string(dynamicTester.Blob), `"items":[2]`) {
+    t.Errorf("expected blob item to be deleted, got `%s`", dynamicTester.Blob)
+  }
+
+  body = []byte(`true`)
+
+  _, err = ServeJSON(&Request{
+    Method: http.MethodPost,
+    Body: (*json.RawMessage)(&body),
+    Path: []string{"plugins", "new", "enabled"},
+  }, dynamicTester)
+
+  if err != nil {
+    t.Error(err)
+  } else if p, ok := dynamicTester.Plugins["new"].(map[string]interface{}); !ok || p["enabled"] != true {
+    t.Errorf("expected nil interface to become an object, got %v", dynamicTester.Plugins["new"])
+  }
+
+  _, err = ServeJSON(&Request{
+    Method: http.MethodGet,
+    Path: []string{"config", "name", "extra"},
+  }, dynamicTester)
+
+  if err == nil {
+    t.Errorf("expected path not found error, got none")
+  }
+}
+
+func TestServeJSONDeleteReset(t *testing.T) {
+  resetTester := &TestStruct2{Test: &TestStruct{Integer: 7, Array: []string{"a"}}}
+
+  req := &Request{Method: http.MethodDelete, Path: Path{"test"}}
+  output, err := ServeJSON(req, resetTester)
+
+  if err != nil {
+    t.Error(err)
+  } else if resetTester.Test != nil || output == nil || string(*output) != "null" {
+    t.Errorf("expected pointer to be reset to nil, got %#v", resetTester.Test)
+  } else if req.Removed == nil || string(*req.Removed) != `{"visible":false,"integer":7,"array":["a"],"NoTag":false}` {
+    t.Errorf("expected removed value to be reported, got %v", req.Removed)
+  }
+
+  output, _ = ServeJSON(&Request{Method: http.MethodGet, Path: Path{}}, resetTester)
+  if string(*output) != "{}" {
+    t.Errorf("expected omitempty to drop the nil pointer, got `%s`", *output)
+  }
+
+  mapTester := &TestMapStruct{Named: map[string]TestStruct{"first": TestStruct{Integer: 1}}}
+  req = &Request{Method: http.MethodDelete, Path: Path{"named", "first"}}
+
+  if output, err = ServeJSON(req, mapTester); err != nil {
+    t.Error(err)
+  } else if output != nil || len(mapTester.Named) != 0 {
+    t.Errorf("expected map key to be removed, got %v", mapTester.Named)
+  } else if req.Removed == nil || !strings.Contains(string(*req.Removed), `"integer":1`) {
+    t.Errorf("expected removed map value to be reported, got %v", req.Removed)
+  }
+
+  if _, err := ServeJSON(&Request{Method: http.MethodDelete, Path: Path{"primary", "owner"}}, accounts); Code(err) != CodeMethodNotAllowed {
+    t.Errorf("expected read only field not to be deleted, got %v", err)
+  }
+
+  req = &Request{Method: http.MethodDelete, Path: Path{"primary", "password"}}
+  if _, err := ServeJSON(req, accounts); err != nil {
+    t.Error(err)
+  } else if accounts.Primary.Password != "" || req.Removed != nil {
+    t.Errorf("expected write only field to be cleared without reporting it, got %v", req.Removed)
+  }
+
+  req = &Request{Method: http.MethodDelete, Path: Path{"primary"}}
+  if _, err := ServeJSON(req, accounts); err != nil {
+    t.Error(err)
+  } else if accounts.Primary.Name != "" || req.Removed == nil || strings.Contains(string(*req.Removed), "secret") {
+    t.Errorf("expected struct to be reset without hidden fields reported, got %v", req.Removed)
+  } else if accounts.Primary.Owner != "root" || accounts.Primary.Secret != "sp" {
+    t.Errorf("expected protected fields to survive the reset, got %#v", accounts.Primary)
+  }
+}
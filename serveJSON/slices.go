@@ -0,0 +1,105 @@
+package serveJSON
+
+import (
+  "encoding/json"
+  "reflect"
+  "strconv"
+)
+
+/*
+MethodMove moves the slice element at the request path to the index given in
+the body, shifting the elements in between.  The response is the new index.
+*/
+const MethodMove = "MOVE"
+
+/*
+inserting reports whether a PUT to path inserts into a slice rather than
+appending to one, which is the case when the parent of path is a slice and the
+last token is an index, the key of an element or "-".
+*/
+func inserting(path Path, pv reflect.Value) bool {
+  if len(path) == 0 {
+    return false
+  }
+
+  parent, _, err := walk(path[:len(path)-1], pv, modeInternal)
+  if err != nil {
+    return false
+  }
+  if parent, _, err = unwrap(parent, false); err != nil || parent.Elem().Kind() != reflect.Slice {
+    return false
+  }
+
+  _, err = insertIndex(path[len(path)-1], parent.Elem())
+  return err == nil
+}
+
+/*
+insertIndex resolves the index before which to insert into the slice v.  An
+empty leaf or "-" is the end of the slice, otherwise leaf is the key or index of
+an element.
+*/
+func insertIndex(leaf string, v reflect.Value) (int, error) {
+  if leaf == "" || leaf == "-" {
+    return v.Len(), nil
+  }
+
+  i, err := elementIndex(leaf, v)
+  if err != nil && leaf == strconv.Itoa(v.Len()) {
+    return v.Len(), nil
+  }
+  return i, err
+}
+
+// insert puts item into slice v before index i
+func insert(v reflect.Value, i int, item reflect.Value) {
+  v.Set(reflect.Append(v, item))
+  reflect.Copy(v.Slice(i+1, v.Len()), v.Slice(i, v.Len()-1))
+  v.Index(i).Set(item)
+}
+
+/*
+moveHelper moves the element with the key or index leaf of the slice or array
+pointed to by pv to the index in body.
+*/
+func moveHelper(body *json.RawMessage, leaf string, pv reflect.Value) (reflect.Value, error) {
+  if body == nil || len(*body) == 0 {
+    return pv, newError(CodeBadRequest, "body is empty")
+  }
+
+  pv, commit, err := unwrap(pv, false)
+  if err != nil {
+    return pv, err
+  }
+
+  v := pv.Elem()
+  if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+    return pv, newError(CodeMethodNotAllowed, "cannot move within type of '%v'", v.Kind())
+  }
+
+  from, err := elementIndex(leaf, v)
+  if err != nil {
+    return pv, err
+  }
+
+  var to int
+  if err := json.Unmarshal(*body, &to); err != nil {
+    return pv, wrapError(CodeBadRequest, err)
+  }
+  if to < 0 || to >= v.Len() {
+    return pv, newError(CodeBadRequest, "destination index '%d' out of bounds", to)
+  }
+
+  item := reflect.New(v.Type().Elem()).Elem()
+  item.Set(v.Index(from))
+
+  if from < to {
+    reflect.Copy(v.Slice(from, to), v.Slice(from+1, to+1))
+  } else {
+    reflect.Copy(v.Slice(to+1, from+1), v.Slice(to, from))
+  }
+  v.Index(to).Set(item)
+  commit()
+
+  return reflect.ValueOf(to), nil
+}
@@ -0,0 +1,135 @@
+package serveJSON
+
+import (
+  "testing"
+  "net/http"
+  "net/http/httptest"
+  "encoding/json"
+  "strings"
+  "reflect"
+)
+
+func streamNames(streams []TestStream) []string {
+  names := make([]string, len(streams))
+  for i, s := range streams {
+    names[i] = s.Name
+  }
+  return names
+}
+
+func TestServeJSONInsert(t *testing.T) {
+  mirror := newTestMirror()
+
+  for _, c := range []struct {
+    path Path
+    name string
+    expected []string
+  }{
+    {Path{"streams", "0"}, "garage", []string{"garage", "kitchen", "porch"}},
+    {Path{"streams", "2"}, "shed", []string{"garage", "kitchen", "shed", "porch"}},
+    {Path{"streams", "4"}, "attic", []string{"garage", "kitchen", "shed", "porch", "attic"}},
+    {Path{"streams", "-"}, "yard", []string{"garage", "kitchen", "shed", "porch", "attic", "yard"}},
+    {Path{"streams"}, "hall", []string{"garage", "kitchen", "shed", "porch", "attic", "yard", "hall"}},
+  } {
+    _, err := ServeJSON(&Request{Method: http.MethodPut, Path: c.path, Body: rawBody(`{"name": "`+c.name+`"}`)}, mirror)
+    if err != nil {
+      t.Errorf("'%v': %v", c.path, err)
+    } else if names := streamNames(mirror.Streams); !reflect.DeepEqual(names, c.expected) {
+      t.Errorf("'%v': expected %v, got %v", c.path, c.expected, names)
+    }
+  }
+
+  for _, path := range []Path{Path{"streams", "9"}, Path{"streams", "-1"}} {
+    if _, err := ServeJSON(&Request{Method: http.MethodPut, Path: path, Body: rawBody(`{"name": "x"}`)}, mirror); Code(err) != CodeNotFound {
+      t.Errorf("'%v': expected not found, got %v", path, err)
+    }
+  }
+
+  if _, err := ServeJSON(&Request{Method: http.MethodPut, Path: Path{"streams"}}, mirror); Code(err) != CodeBadRequest {
+    t.Errorf("expected empty body error, got %v", err)
+  }
+}
+
+func TestServeJSONMove(t *testing.T) {
+  mirror := newTestMirror()
+  mirror.Streams = append(mirror.Streams, TestStream{Name: "garage"}, TestStream{Name: "shed"})
+
+  for _, c := range []struct {
+    from string
+    to string
+    expected []string
+  }{
+    {"0", "2", []string{"porch", "garage", "kitchen", "shed"}},
+    {"3", "0", []string{"shed", "porch", "garage", "kitchen"}},
+    {"1", "1", []string{"shed", "porch", "garage", "kitchen"}},
+    {"2", "3", []string{"shed", "porch", "kitchen", "garage"}},
+  } {
+    output, err := ServeJSON(&Request{Method: MethodMove, Path: Path{"streams", c.from}, Body: rawBody(c.to)}, mirror)
+    if err != nil {
+      t.Errorf("%s to %s: %v", c.from, c.to, err)
+    } else if string(*output) != c.to {
+      t.Errorf("%s to %s: expected the new index, got `%s`", c.from, c.to, *output)
+    } else if names := streamNames(mirror.Streams); !reflect.DeepEqual(names, c.expected) {
+      t.Errorf("%s to %s: expected %v, got %v", c.from, c.to, c.expected, names)
+    }
+  }
+
+  for _, c := range []struct {
+    path Path
+    body string
+    code ErrorCode
+  }{
+    {Path{"streams", "4"}, `0`, CodeNotFound},
+    {Path{"streams", "0"}, `4`, CodeBadRequest},
+    {Path{"streams", "0"}, `"x"`, CodeBadRequest},
+    {Path{"test", "integer"}, `0`, CodeMethodNotAllowed},
+    {Path{}, `0`, CodeMethodNotAllowed},
+  } {
+    if _, err := ServeJSON(&Request{Method: MethodMove, Path: c.path, Body: rawBody(c.body)}, mirror); Code(err) != c.code {
+      t.Errorf("'%v' %s: expected %s, got %v", c.path, c.body, c.code, err)
+    }
+  }
+}
+
+func TestTreeMove(t *testing.T) {
+  tree := NewTree(newTestMirror())
+  counter := TestCounter{make(chan *Request, 2)}
+  tree.Watch(counter)
+
+  version := tree.Version(Path{"streams", "1"})
+  testVersion := tree.Version(Path{"test"})
+
+  w := httptest.NewRecorder()
+  tree.ServeHTTP(w, httptest.NewRequest(MethodMove, "/streams/0", strings.NewReader(`1`)))
+
+  if w.Code != 200 || w.Body.String() != "1" {
+    t.Fatalf("expected 200, got %d `%s`", w.Code, w.Body.String())
+  }
+
+  req := <-counter.count
+  if b, _ := json.Marshal(req); string(b) != `{"method":"MOVE","path":["streams","0"],"version":2,"body":1,"response":1}` {
+    t.Errorf("expected a single compact notification, got `%s`", b)
+  }
+
+  if v := tree.Version(Path{"streams", "1"}); v == version {
+    t.Errorf("expected the moved over sibling to change version")
+  }
+  if v := tree.Version(Path{"test"}); v != testVersion {
+    t.Errorf("expected unrelated nodes to keep their version")
+  }
+
+  w = httptest.NewRecorder()
+  tree.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/streams/0", strings.NewReader(`{"name": "garage"}`)))
+  <-counter.count
+
+  if v := tree.Version(Path{"streams", "2"}); v <= version {
+    t.Errorf("expected shifted elements to change version")
+  }
+
+  tree.View(func(data interface{}) error {
+    if names := streamNames(data.(*TestMirror).Streams); !reflect.DeepEqual(names, []string{"garage", "porch", "kitchen"}) {
+      t.Errorf("unexpected streams %v", names)
+    }
+    return nil
+  })
+}
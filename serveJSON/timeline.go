@@ -0,0 +1,370 @@
+package serveJSON
+
+import (
+  "bytes"
+  "encoding/json"
+  "net/http"
+  "reflect"
+  "strconv"
+  "time"
+)
+
+/*
+Moment is a point in the timeline of a Tree, given either by the version of
+the tree or by the time.  It is written as the version number, or as an RFC
+3339 time such as "2026-10-16T21:30:00Z".
+*/
+type Moment struct {
+  Version uint64
+  Time time.Time
+}
+
+// ParseMoment parses a version number or an RFC 3339 time
+func ParseMoment(s string) (*Moment, error) {
+  if version, err := strconv.ParseUint(s, 10, 64); err == nil {
+    return &Moment{Version: version}, nil
+  }
+  t, err := time.Parse(time.RFC3339Nano, s)
+  if err != nil {
+    return nil, newError(CodeBadRequest, "invalid moment '%s', expected a version or an RFC 3339 time", s)
+  }
+  return &Moment{Time: t}, nil
+}
+
+func (m Moment) String() string {
+  if m.Time.IsZero() {
+    return strconv.FormatUint(m.Version, 10)
+  }
+  return m.Time.Format(time.RFC3339Nano)
+}
+
+func (m Moment) MarshalJSON() ([]byte, error) {
+  if m.Time.IsZero() {
+    return []byte(m.String()), nil
+  }
+  return json.Marshal(m.String())
+}
+
+func (m *Moment) UnmarshalJSON(data []byte) error {
+  s := string(bytes.TrimSpace(data))
+  if len(s) > 0 && s[0] == '"' {
+    if err := json.Unmarshal(data, &s); err != nil {
+      return err
+    }
+  }
+  parsed, err := ParseMoment(s)
+  if err != nil {
+    return err
+  }
+  *m = *parsed
+  return nil
+}
+
+/*
+timeline keeps the changes made to a Tree within the last retain, up to limit
+of them, oldest first, so the data can be wound back to any version since.
+Nothing from before version since, reached at sinceTime, is kept.
+*/
+type timeline struct {
+  retain time.Duration
+  limit int
+  changes []*change
+  since uint64
+  sinceTime time.Time
+}
+
+/*
+KeepTimeline has the tree remember the mutations made within retain, up to the
+latest limit of them, so that GETs may ask with Options.AsOf for a value as it
+was then, or with Options.Diff for what changed between two moments.  A retain
+or limit of zero forgets the timeline and turns it off.
+*/
+func (t *Tree) KeepTimeline(retain time.Duration, limit int) {
+  t.lock.Lock()
+  defer t.lock.Unlock()
+
+  if retain <= 0 || limit <= 0 {
+    t.timeline = nil
+    return
+  }
+  if t.timeline == nil {
+    t.timeline = &timeline{since: t.versions.node(Path{}), sinceTime: time.Now()}
+  }
+  t.timeline.retain, t.timeline.limit = retain, limit
+  t.timeline.prune(time.Now())
+}
+
+// record adds c, which left the tree at version
+func (l *timeline) record(c *change, version uint64) {
+  if l == nil {
+    return
+  }
+  c.version, c.at = version, time.Now()
+  l.changes = append(l.changes, c)
+  l.prune(c.at)
+}
+
+// prune forgets the changes made more than retain before now, and any beyond limit
+func (l *timeline) prune(now time.Time) {
+  i := 0
+  for ; i < len(l.changes) && (now.Sub(l.changes[i].at) > l.retain || len(l.changes)-i > l.limit); i++ {
+    l.since, l.sinceTime = l.changes[i].version, l.changes[i].at
+  }
+  if i > 0 {
+    l.changes = append([]*change{}, l.changes[i:]...)
+  }
+}
+
+// reset forgets every change, starting again from version
+func (l *timeline) reset(version uint64, at time.Time) {
+  if l == nil {
+    return
+  }
+  l.changes = nil
+  l.since, l.sinceTime = version, at
+}
+
+// stamp dates the latest changes, replayed from a journal, as made at
+func (l *timeline) stamp(at time.Time) {
+  if l == nil {
+    return
+  }
+  for i := len(l.changes) - 1; i >= 0 && l.changes[i].at.After(at); i-- {
+    l.changes[i].at = at
+  }
+  l.prune(time.Now())
+}
+
+// asOf returns the version the tree was at, at the time at
+func (l *timeline) asOf(at time.Time) uint64 {
+  version := l.since
+  for _, c := range l.changes {
+    if c.at.After(at) {
+      break
+    }
+    version = c.version
+  }
+  return version
+}
+
+// version resolves m to a version kept by the timeline, where nil is now
+func (l *timeline) version(m *Moment, now uint64) (uint64, error) {
+  switch {
+  case m == nil:
+    return now, nil
+  case !m.Time.IsZero():
+    if m.Time.Before(l.sinceTime) {
+      return 0, newError(CodeNotFound, "nothing from before %s is kept", l.sinceTime.Format(time.RFC3339))
+    }
+    return l.asOf(m.Time), nil
+  case m.Version < l.since:
+    return 0, newError(CodeNotFound, "nothing from before version %d is kept", l.since)
+  case m.Version > now:
+    return 0, newError(CodeNotFound, "version %d is still to come", m.Version)
+  }
+  return m.Version, nil
+}
+
+// rebase has the next n versions recorded end at version, if that is ahead
+func (t *Tree) rebase(version, n uint64) {
+  t.lock.Lock()
+  defer t.lock.Unlock()
+
+  if version > n && version-n > t.versions.current {
+    t.versions.current = version - n
+  }
+}
+
+/*
+rewind returns a copy of the data as it was at version by putting back, newest
+first, the nodes changed since.  The data itself is returned if it hasn't
+changed since.
+*/
+func (t *Tree) rewind(version uint64) (interface{}, error) {
+  pv := reflect.ValueOf(t.Data)
+  if version >= t.versions.current {
+    return t.Data, nil
+  }
+
+  c := reflect.New(pv.Elem().Type())
+  c.Elem().Set(deepCopy(pv.Elem()))
+
+  changes := t.timeline.changes
+  for i := len(changes) - 1; i >= 0 && changes[i].version > version; i-- {
+    pe, commit, err := walk(changes[i].path, c, modeInternal|modeWrite|modeCreate)
+    if err != nil {
+      return nil, err
+    }
+    restore(pe.Elem(), deepCopy(changes[i].before))
+    commit()
+  }
+  return c.Interface(), nil
+}
+
+// at serves the GET req against the data as it was at version
+func (t *Tree) at(req *Request, version uint64) (*json.RawMessage, error) {
+  data, err := t.rewind(version)
+  if err != nil {
+    return nil, err
+  }
+  sub := *req
+  sub.Options = req.Options.present()
+  return ServeJSON(&sub, data)
+}
+
+/*
+past serves a GET asking for another moment than now, returning the version it
+is as of.  A diff is the JSON Patch taking the value at Diff to the value as of
+AsOf, where a value missing at one of them was added or removed.
+*/
+func (t *Tree) past(req *Request) (uint64, *json.RawMessage, error) {
+  if t.timeline == nil {
+    return 0, nil, newError(CodeBadRequest, "no timeline is kept")
+  }
+
+  now := t.versions.node(Path{})
+  if t.versions.current > now {
+    now = t.versions.current
+  }
+
+  to, err := t.timeline.version(req.Options.AsOf, now)
+  if err != nil {
+    return 0, nil, err
+  }
+  after, err := t.at(req, to)
+  if req.Options.Diff == nil {
+    return to, after, err
+  }
+
+  from, err2 := t.timeline.version(req.Options.Diff, now)
+  if err2 != nil {
+    return 0, nil, err2
+  }
+  before, err2 := t.at(req, from)
+
+  if err != nil {
+    after = nil
+  }
+  if err2 != nil {
+    before = nil
+  }
+  switch {
+  case err != nil && Code(err) != CodeNotFound:
+    return 0, nil, err
+  case err2 != nil && Code(err2) != CodeNotFound:
+    return 0, nil, err2
+  case err != nil && err2 != nil:
+    return 0, nil, err
+  }
+
+  ops, err := diff([]Operation{}, Path{}, before, after)
+  if err != nil {
+    return 0, nil, err
+  }
+  bytes, err := json.Marshal(ops)
+  if err != nil {
+    return 0, nil, err
+  }
+  return to, (*json.RawMessage)(&bytes), nil
+}
+
+func operation(op string, path Path, value json.RawMessage) Operation {
+  o := Operation{Op: op, Path: path.String()}
+  if value != nil {
+    o.Value = &value
+  }
+  return o
+}
+
+/*
+diff appends to ops the operations taking the marshalled value a to b, where
+nil is missing.  Objects are compared member by member and arrays element by
+element, adding or removing elements at the end.
+*/
+func diff(ops []Operation, path Path, a, b *json.RawMessage) ([]Operation, error) {
+  switch {
+  case a == nil && b == nil:
+    return ops, nil
+  case a == nil:
+    return append(ops, operation("add", path, *b)), nil
+  case b == nil:
+    return append(ops, operation("remove", path, nil)), nil
+  }
+
+  x, y := bytes.TrimSpace(*a), bytes.TrimSpace(*b)
+  switch {
+  case bytes.Equal(x, y):
+    return ops, nil
+  case len(x) > 0 && len(y) > 0 && x[0] == '{' && y[0] == '{':
+    xm, err := objectMembers(x)
+    if err != nil {
+      return nil, err
+    }
+    ym, err := objectMembers(y)
+    if err != nil {
+      return nil, err
+    }
+
+    added := make(map[string]*json.RawMessage, len(ym))
+    for i := range ym {
+      added[ym[i].key] = &ym[i].value
+    }
+    for i := range xm {
+      key := xm[i].key
+      ops, err = diff(ops, append(append(Path{}, path...), key), &xm[i].value, added[key])
+      if err != nil {
+        return nil, err
+      }
+      delete(added, key)
+    }
+    for i := range ym {
+      if _, ok := added[ym[i].key]; ok {
+        ops = append(ops, operation("add", append(append(Path{}, path...), ym[i].key), ym[i].value))
+      }
+    }
+    return ops, nil
+  case len(x) > 0 && len(y) > 0 && x[0] == '[' && y[0] == '[':
+    var xs, ys []json.RawMessage
+    if err := json.Unmarshal(x, &xs); err != nil {
+      return nil, err
+    }
+    if err := json.Unmarshal(y, &ys); err != nil {
+      return nil, err
+    }
+
+    var err error
+    for i := 0; i < len(xs) && i < len(ys); i++ {
+      if ops, err = diff(ops, append(append(Path{}, path...), strconv.Itoa(i)), &xs[i], &ys[i]); err != nil {
+        return nil, err
+      }
+    }
+    // removed from the end first so that the indices hold
+    for i := len(xs) - 1; i >= len(ys); i-- {
+      ops = append(ops, operation("remove", append(append(Path{}, path...), strconv.Itoa(i)), nil))
+    }
+    for i := len(xs); i < len(ys); i++ {
+      ops = append(ops, operation("add", append(append(Path{}, path...), strconv.Itoa(i)), ys[i]))
+    }
+    return ops, nil
+  }
+  return append(ops, operation("replace", path, *b)), nil
+}
+
+// records counts the versions recorded by serving req
+func records(req *Request) uint64 {
+  switch req.Method {
+  case http.MethodGet:
+    return 0
+  case MethodBatch:
+    batch, err := req.requests()
+    if err != nil {
+      return 1
+    }
+    var n uint64
+    for _, sub := range batch {
+      n += records(sub)
+    }
+    return n
+  }
+  return 1
+}
@@ -0,0 +1,256 @@
+package serveJSON
+
+import (
+  "testing"
+  "net/http"
+  "net/http/httptest"
+  "encoding/json"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "reflect"
+  "time"
+)
+
+// pastRequest GETs path with options asking for another moment
+func pastRequest(tree *Tree, path Path, options *Options) (*Request, error) {
+  req := &Request{Method: http.MethodGet, Path: path, Options: options}
+  _, err := tree.Request(req)
+  return req, err
+}
+
+func TestTimelineAsOf(t *testing.T) {
+  mirror := newTestMirror()
+  tree := NewTree(mirror)
+
+  if _, err := pastRequest(tree, Path{}, &Options{AsOf: &Moment{Version: 1}}); Code(err) != CodeBadRequest {
+    t.Errorf("expected a GET of the past without a timeline to be refused, got %v", err)
+  }
+
+  tree.KeepTimeline(time.Hour, 100)
+  initial := tree.Version(Path{})
+
+  first, err := historyRequest(tree, http.MethodPost, "phone", Path{"streams", "0", "url"}, `"rtsp://garage"`)
+  if err != nil {
+    t.Fatal(err)
+  }
+  time.Sleep(time.Millisecond)
+  between := time.Now()
+  time.Sleep(time.Millisecond)
+
+  historyRequest(tree, http.MethodPut, "phone", Path{"streams", "0"}, `{"name":"attic"}`)
+  historyRequest(tree, MethodBatch, "phone", Path{"test"}, `[{"method":"POST","path":["integer"],"body":7},{"method":"DELETE","path":["array"]}]`)
+
+  for _, c := range []struct {
+    moment *Moment
+    path Path
+    expected string
+  }{
+    {&Moment{Version: initial}, Path{"streams"}, `[{"name":"kitchen","url":"rtsp://kitchen"},{"name":"porch","url":"rtsp://porch"}]`},
+    {&Moment{Version: first.Version}, Path{"streams", "0"}, `{"name":"kitchen","url":"rtsp://garage"}`},
+    {&Moment{Time: between}, Path{"streams", "0", "url"}, `"rtsp://garage"`},
+    {&Moment{Version: initial}, Path{"test", "integer"}, `42`},
+    {nil, Path{"test", "integer"}, `7`},
+  } {
+    req, err := pastRequest(tree, c.path, &Options{AsOf: c.moment})
+    if err != nil {
+      t.Errorf("as of %v '%v': %v", c.moment, c.path, err)
+    } else if string(*req.Response) != c.expected {
+      t.Errorf("as of %v '%v': expected `%s`, got `%s`", c.moment, c.path, c.expected, *req.Response)
+    }
+  }
+
+  if req, _ := pastRequest(tree, Path{}, &Options{AsOf: &Moment{Version: first.Version}}); req.Version != first.Version {
+    t.Errorf("expected the version asked for, got %d", req.Version)
+  }
+  if len(mirror.Streams) != 3 || mirror.Streams[0].Name != "attic" || mirror.Test.Integer != 7 {
+    t.Errorf("expected the data left as it is, got %v", mirror)
+  }
+
+  for _, m := range []*Moment{{Version: initial - 1}, {Version: 100}, {Time: between.Add(-time.Hour)}} {
+    if _, err := pastRequest(tree, Path{}, &Options{AsOf: m}); Code(err) != CodeNotFound {
+      t.Errorf("expected nothing as of %v, got %v", m, err)
+    }
+  }
+
+  // the attic wasn't there before it was added
+  if _, err := pastRequest(tree, Path{"streams", "2"}, &Options{AsOf: &Moment{Version: first.Version}}); Code(err) != CodeNotFound {
+    t.Errorf("expected no third stream, got %v", err)
+  }
+}
+
+func TestTimelineDiff(t *testing.T) {
+  tree := NewTree(newTestMirror())
+  tree.KeepTimeline(time.Hour, 100)
+  tree.KeepHistory(10)
+  initial := tree.Version(Path{})
+
+  historyRequest(tree, http.MethodPost, "phone", Path{"streams", "0", "url"}, `"rtsp://garage"`)
+  historyRequest(tree, http.MethodPut, "phone", Path{"streams", "2"}, `{"name":"attic"}`)
+  historyRequest(tree, http.MethodPost, "phone", Path{"test", "visible"}, `true`)
+  historyRequest(tree, MethodUndo, "phone", Path{}, "")
+
+  req, err := pastRequest(tree, Path{}, &Options{Diff: &Moment{Version: initial}})
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  var ops []Operation
+  if err := json.Unmarshal(*req.Response, &ops); err != nil {
+    t.Fatal(err)
+  }
+  if len(ops) != 2 || ops[0].Op != "replace" || ops[0].Path != "/streams/0/url" || ops[1].Op != "add" || ops[1].Path != "/streams/2" {
+    t.Errorf("expected the url replaced and the attic added, got `%s`", *req.Response)
+  }
+
+  // the patch takes the value then to the value now
+  then := NewTree(newTestMirror())
+  if _, err := then.Request(&Request{Method: http.MethodPatch, ContentType: JSONPatchType, Path: Path{}, Body: req.Response}); err != nil {
+    t.Fatal(err)
+  }
+  if !reflect.DeepEqual(then.Data, tree.Data) {
+    t.Errorf("expected %v, got %v", tree.Data, then.Data)
+  }
+
+  // and the other way around
+  req, err = pastRequest(tree, Path{"streams"}, &Options{AsOf: &Moment{Version: initial}, Diff: &Moment{Version: req.Version}})
+  if err != nil {
+    t.Fatal(err)
+  } else if string(*req.Response) != `[{"op":"replace","path":"/0/url","value":"rtsp://kitchen"},{"op":"remove","path":"/2"}]` {
+    t.Errorf("expected the url put back and the attic removed, got `%s`", *req.Response)
+  }
+
+  req, err = pastRequest(tree, Path{"streams", "2"}, &Options{Diff: &Moment{Version: initial}})
+  if err != nil {
+    t.Fatal(err)
+  } else if string(*req.Response) != `[{"op":"add","path":"","value":{"name":"attic","url":""}}]` {
+    t.Errorf("expected the attic added, got `%s`", *req.Response)
+  }
+}
+
+func TestTimelineRetain(t *testing.T) {
+  tree := NewTree(newTestMirror())
+  tree.KeepTimeline(10 * time.Millisecond, 100)
+
+  first, _ := historyRequest(tree, http.MethodPost, "", Path{"test", "integer"}, `1`)
+  time.Sleep(20 * time.Millisecond)
+  historyRequest(tree, http.MethodPost, "", Path{"test", "integer"}, `2`)
+
+  if _, err := pastRequest(tree, Path{}, &Options{AsOf: &Moment{Version: first.Version - 1}}); Code(err) != CodeNotFound {
+    t.Errorf("expected changes older than the timeline forgotten, got %v", err)
+  }
+  if req, err := pastRequest(tree, Path{"test", "integer"}, &Options{AsOf: &Moment{Version: first.Version}}); err != nil || string(*req.Response) != `1` {
+    t.Errorf("expected the value since, got %v", err)
+  }
+
+  // changes made by Update can't be wound back
+  tree.Update(func(data interface{}) error {
+    data.(*TestMirror).Test.Integer = 3
+    return nil
+  })
+  if _, err := pastRequest(tree, Path{}, &Options{AsOf: &Moment{Version: first.Version}}); Code(err) != CodeNotFound {
+    t.Errorf("expected nothing kept from before the update, got %v", err)
+  }
+}
+
+func TestTimelineLimit(t *testing.T) {
+  tree := NewTree(newTestMirror())
+  tree.KeepTimeline(time.Hour, 2)
+
+  first, _ := historyRequest(tree, http.MethodPost, "", Path{"test", "integer"}, `1`)
+  second, _ := historyRequest(tree, http.MethodPost, "", Path{"test", "integer"}, `2`)
+  historyRequest(tree, http.MethodPost, "", Path{"test", "integer"}, `3`)
+
+  // only the latest two changes are kept, however recent the first
+  if _, err := pastRequest(tree, Path{}, &Options{AsOf: &Moment{Version: first.Version - 1}}); Code(err) != CodeNotFound {
+    t.Errorf("expected changes beyond the limit forgotten, got %v", err)
+  }
+  if req, err := pastRequest(tree, Path{"test", "integer"}, &Options{AsOf: &Moment{Version: second.Version - 1}}); err != nil || string(*req.Response) != `1` {
+    t.Errorf("expected the value before the changes kept, got %v", err)
+  }
+}
+
+func TestTimelineHTTP(t *testing.T) {
+  tree := NewTree(newTestMirror())
+  tree.KeepTimeline(time.Hour, 100)
+  initial := tree.Version(Path{})
+
+  historyRequest(tree, http.MethodDelete, "", Path{"streams", "1"}, "")
+
+  w := httptest.NewRecorder()
+  tree.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/streams?diff=1&fields=name", nil))
+
+  if w.Code != 200 || w.Body.String() != `[{"op":"remove","path":"/1"}]` {
+    t.Errorf("expected the porch removed, got %d `%s`", w.Code, w.Body.String())
+  }
+
+  w = httptest.NewRecorder()
+  tree.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/streams/1/name?asOf=1", nil))
+
+  if w.Code != 200 || w.Body.String() != `"porch"` || w.Header().Get("ETag") != ETag(initial) {
+    t.Errorf("expected the porch as of the start, got %d `%s` %s", w.Code, w.Body.String(), w.Header().Get("ETag"))
+  }
+
+  w = httptest.NewRecorder()
+  tree.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/?asOf=yesterday", nil))
+
+  if w.Code != http.StatusBadRequest {
+    t.Errorf("expected an invalid moment refused, got %d", w.Code)
+  }
+}
+
+func TestTimelineJournal(t *testing.T) {
+  dir, err := ioutil.TempDir("", "journal")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer os.RemoveAll(dir)
+
+  tree := NewTree(newTestMirror())
+  tree.KeepTimeline(time.Hour, 100)
+  j := NewJournal(tree, filepath.Join(dir, "state.json"), filepath.Join(dir, "state.log"))
+  defer j.Close()
+  tree.Watch(j)
+
+  historyRequest(tree, http.MethodPost, "phone", Path{"streams", "0", "url"}, `"rtsp://garage"`)
+  historyRequest(tree, http.MethodDelete, "phone", Path{"streams", "1"}, "")
+  if err := j.Compact(); err != nil {
+    t.Fatal(err)
+  }
+  // the snapshot holds the timeline, so nothing is left to replay
+  if b, err := ioutil.ReadFile(j.log); err != nil || len(b) != 0 {
+    t.Errorf("expected an empty log once compacted, got `%s` %v", b, err)
+  }
+
+  historyRequest(tree, http.MethodPut, "phone", Path{"streams", "0"}, `{"name":"attic"}`)
+  last, _ := historyRequest(tree, http.MethodPost, "phone", Path{"test", "integer"}, `7`)
+
+  for _, compact := range []bool{false, true} {
+    if compact {
+      if err := j.Compact(); err != nil {
+        t.Fatal(err)
+      }
+    }
+
+    replay := NewTree(&TestMirror{})
+    replay.KeepTimeline(time.Hour, 100)
+    if err := NewJournal(replay, j.snapshot, j.log).Replay(); err != nil {
+      t.Fatal(err)
+    }
+
+    // the whole timeline is rebuilt at the same versions
+    for v := uint64(1); v <= last.Version; v++ {
+      expected, err := pastRequest(tree, Path{}, &Options{AsOf: &Moment{Version: v}})
+      if err != nil {
+        t.Fatal(err)
+      }
+      actual, err := pastRequest(replay, Path{}, &Options{AsOf: &Moment{Version: v}})
+      if err != nil {
+        t.Fatalf("as of %d: %v", v, err)
+      }
+      if string(*actual.Response) != string(*expected.Response) {
+        t.Errorf("as of %d: expected `%s`, got `%s`", v, *expected.Response, *actual.Response)
+      }
+    }
+  }
+}
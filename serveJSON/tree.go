@@ -0,0 +1,315 @@
+package serveJSON
+
+import (
+  "encoding/json"
+  "io/ioutil"
+  "net/http"
+  "reflect"
+  "sync"
+  "time"
+)
+
+/*
+Tree serves requests against Data, tracking the version of every node that
+changes and passing each request on to its watchers once it is complete.
+GETs run in parallel while mutations are serialized, so once a Tree is
+serving, Data should only be accessed through View and Update.
+*/
+type Tree struct {
+  Data interface{}
+  lock sync.RWMutex
+  versions versions
+  history *history
+  timeline *timeline
+  watchersLock sync.Mutex
+  watchers []Notifier
+  journal *Journal
+}
+
+func NewTree(data interface{}) *Tree {
+  return &Tree{Data: data}
+}
+
+/*
+Watch passes each successful request on to watcher once it is answered.  A
+Journal is instead passed each mutation in order, before it is answered.
+*/
+func (t *Tree) Watch(watcher Notifier) {
+  if j, ok := watcher.(*Journal); ok {
+    t.lock.Lock()
+    defer t.lock.Unlock()
+
+    t.journal = j
+    return
+  }
+
+  t.watchersLock.Lock()
+  defer t.watchersLock.Unlock()
+
+  t.watchers = append(t.watchers, watcher)
+}
+func (t *Tree) Unwatch(watcher Notifier) {
+  if j, ok := watcher.(*Journal); ok {
+    t.lock.Lock()
+    defer t.lock.Unlock()
+
+    if t.journal == j {
+      t.journal = nil
+    }
+    return
+  }
+
+  t.watchersLock.Lock()
+  defer t.watchersLock.Unlock()
+
+  found := len(t.watchers)
+  for i, n := range t.watchers {
+    if n == watcher {
+      found = i
+      break
+    }
+  }
+
+  if found < len(t.watchers) {
+    t.watchers[found] = t.watchers[len(t.watchers) - 1]
+    t.watchers = t.watchers[:len(t.watchers) - 1]
+  }
+}
+
+// Version returns the version of the node at path
+func (t *Tree) Version(path Path) uint64 {
+  t.lock.RLock()
+  defer t.lock.RUnlock()
+
+  return t.node(path)
+}
+
+// node returns the version of the node at path, which may give elements by key
+func (t *Tree) node(path Path) uint64 {
+  indexed, _ := resolvePath(unescape(cleanPath(path)), reflect.ValueOf(t.Data))
+  return t.versions.node(indexed)
+}
+
+// View calls f with the data while holding off any mutations
+func (t *Tree) View(f func(data interface{}) error) error {
+  t.lock.RLock()
+  defer t.lock.RUnlock()
+
+  return f(t.Data)
+}
+
+/*
+Update calls f with exclusive access to the data.  Changes made by f bypass
+the watchers and bump the version of the whole tree.  A Journal watching the
+tree logs the whole of the data as f leaves it.
+*/
+func (t *Tree) Update(f func(data interface{}) error) error {
+  t.lock.Lock()
+  defer t.lock.Unlock()
+
+  if err := f(t.Data); err != nil {
+    return err
+  }
+  // the timeline can't go back past changes it didn't see
+  version := t.versions.record(Path{})
+  t.timeline.reset(version, time.Now())
+
+  if t.journal != nil {
+    return t.journal.updated(version, t.Data)
+  }
+  return nil
+}
+
+func (t *Tree) notify(req *Request) {
+  t.watchersLock.Lock()
+  defer t.watchersLock.Unlock()
+
+  for _, n := range t.watchers {
+    go n.Notify(req)
+  }
+}
+
+/*
+Request serves req against the tree.  Mutations carrying an IfMatch version
+other than the current version of the addressed node fail with a
+*VersionError.  The version of the node after the request is stored in
+req.Version.  Only successful requests are passed on to the watchers; errors
+are left to the caller to report to the requestor.
+*/
+func (t *Tree) Request(req *Request) (*json.RawMessage, error) {
+  t.serve(req)
+
+  if req.Error == nil {
+    // the values of write only fields are kept from every watcher
+    conceal(req, reflect.TypeOf(t.Data), Path{})
+    t.notify(req)
+  }
+
+  return req.Response, req.Error
+}
+
+func (t *Tree) serve(req *Request) {
+  mutation := req.Method != http.MethodGet
+
+  if mutation {
+    t.lock.Lock()
+    defer t.lock.Unlock()
+    // logged once the version is known, before the next mutation
+    defer t.log(req)
+  } else {
+    t.lock.RLock()
+    defer t.lock.RUnlock()
+  }
+
+  // resolved before a DELETE takes the element and its key away
+  path, _ := resolvePath(unescape(cleanPath(req.Path)), reflect.ValueOf(t.Data))
+
+  // a GET of the past reports the version it is as of instead
+  var version uint64
+  defer func() {
+    if version == 0 {
+      version = t.versions.node(path)
+    }
+    req.Version = version
+  }()
+
+  if err := t.check(req, Path{}); err != nil {
+    req.Response, req.Error = nil, err
+    return
+  }
+
+  if req.Method == MethodUndo || req.Method == MethodRedo {
+    // the request reports the node put back rather than where it looked
+    path, req.Response, req.Error = t.step(req, path)
+    req.Path, req.KeyPath, req.Removed = path, nil, nil
+    return
+  }
+
+  if !mutation && req.Options.past() {
+    version, req.Response, req.Error = t.past(req)
+    return
+  }
+
+  var c *change
+  if mutation && (t.history != nil || t.timeline != nil) {
+    c = snapshot(req, cleanPath(req.Path), reflect.ValueOf(t.Data))
+  }
+
+  if req.Response, req.Error = ServeJSON(req, t.Data); req.Error == nil {
+    t.record(req, Path{})
+    if c != nil {
+      t.timeline.record(c, t.versions.current)
+    }
+    if c != nil && t.history != nil {
+      t.history.record(c, reflect.ValueOf(t.Data))
+    }
+  }
+}
+
+// log journals a successful mutation, failing it if it can't be kept
+func (t *Tree) log(req *Request) {
+  if t.journal == nil || req.Error != nil {
+    return
+  }
+  if err := t.journal.Notify(req); err != nil {
+    // the change is made, but the requestor is told it won't survive a restart
+    req.Response, req.Error = nil, wrapError(CodeInternal, err)
+  }
+}
+
+// check compares the IfMatch versions of req and any requests it batches
+func (t *Tree) check(req *Request, base Path) error {
+  path := append(cleanPath(base), cleanPath(req.Path)...)
+
+  if actual := t.node(path); req.Method != http.MethodGet && req.IfMatch != 0 && req.IfMatch != actual {
+    return &VersionError{Path: path, Expected: req.IfMatch, Actual: actual}
+  }
+
+  if req.Method == MethodBatch {
+    batch, err := req.requests()
+    if err != nil {
+      return err
+    }
+    for _, sub := range batch {
+      if err := t.check(sub, path); err != nil {
+        return err
+      }
+    }
+  }
+  return nil
+}
+
+// record bumps the versions of the nodes changed by a successful request
+func (t *Tree) record(req *Request, base Path) {
+  path := append(cleanPath(base), cleanPath(req.Path)...)
+
+  switch req.Method {
+  case http.MethodGet:
+  case MethodBatch:
+    for _, sub := range req.Batch {
+      t.record(sub, path)
+    }
+    for _, sub := range req.Batch {
+      sub.Version = t.node(append(cleanPath(path), cleanPath(sub.Path)...))
+    }
+  default:
+    // deletes, moves and inserts shift siblings, so serve reports the container
+    t.versions.record(req.changed)
+  }
+}
+
+func (t *Tree) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+  serveHTTP(w, r, t)
+}
+
+// serveHTTP serves an HTTP request with h, reporting the version as an ETag
+func serveHTTP(w http.ResponseWriter, r *http.Request, h Handler) {
+  body, _ := ioutil.ReadAll(r.Body)
+
+  path, err := ParseURLPath(r.URL.EscapedPath())
+  if err != nil {
+    writeError(w, wrapError(CodeBadRequest, err))
+    return
+  }
+
+  ifMatch, err := ParseETag(r.Header.Get("If-Match"))
+  if err != nil {
+    writeError(w, wrapError(CodeBadRequest, err))
+    return
+  }
+
+  options, err := ParseOptions(r.URL.Query())
+  if err != nil {
+    writeError(w, err)
+    return
+  }
+
+  method := r.Method
+  if method == http.MethodPost && mediaType(r.Header.Get("Content-Type")) == BatchType {
+    method = MethodBatch
+  }
+
+  req := &Request{
+    Method: method,
+    ContentType: r.Header.Get("Content-Type"),
+    Path: path,
+    IfMatch: ifMatch,
+    Options: options,
+    Body: (*json.RawMessage)(&body),
+  }
+  if len(body) == 0 {
+    req.Body = nil
+  }
+
+  res, err := h.Request(req)
+  w.Header().Set("ETag", ETag(req.Version))
+
+  if err != nil {
+    writeError(w, err)
+    return
+  }
+
+  if res != nil {
+    w.Write(*res)
+  }
+}
@@ -0,0 +1,219 @@
+package serveJSON
+
+import (
+  "testing"
+  "net/http"
+  "net/http/httptest"
+  "encoding/json"
+  "strings"
+)
+
+type TestStream struct {
+  Name string `json:"name"`
+  URL string `json:"url"`
+}
+
+type TestMirror struct {
+  Streams []TestStream `json:"streams"`
+  Test TestStruct `json:"test"`
+}
+
+func newTestMirror() *TestMirror {
+  return &TestMirror{
+    Streams: []TestStream{
+      TestStream{Name: "kitchen", URL: "rtsp://kitchen"},
+      TestStream{Name: "porch", URL: "rtsp://porch"},
+    },
+    Test: TestStruct{Integer: 42},
+  }
+}
+
+func TestTreeVersions(t *testing.T) {
+  tree := NewTree(newTestMirror())
+
+  initial := tree.Version(Path{})
+  body := []byte(`"rtsp://garage"`)
+
+  _, err := tree.Request(&Request{
+    Method: http.MethodPost,
+    Body: (*json.RawMessage)(&body),
+    Path: Path{"streams", "0", "url"},
+  })
+
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  if v := tree.Version(Path{}); v <= initial {
+    t.Errorf("expected root version to increase past %d, got %d", initial, v)
+  }
+  if v := tree.Version(Path{"streams", "0"}); v <= initial {
+    t.Errorf("expected changed node version to increase past %d, got %d", initial, v)
+  }
+  if v := tree.Version(Path{"streams", "1"}); v != initial {
+    t.Errorf("expected sibling version to stay at %d, got %d", initial, v)
+  }
+
+  _, err = tree.Request(&Request{
+    Method: http.MethodDelete,
+    Path: Path{"streams", "0"},
+  })
+
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  if v := tree.Version(Path{"streams", "1"}); v == initial {
+    t.Errorf("expected siblings of a deleted element to change version")
+  }
+  if v := tree.Version(Path{"test"}); v != initial {
+    t.Errorf("expected unrelated node version to stay at %d, got %d", initial, v)
+  }
+}
+
+func TestTreeIfMatch(t *testing.T) {
+  tree := NewTree(newTestMirror())
+  body := []byte(`"rtsp://garage"`)
+
+  req := &Request{
+    Method: http.MethodGet,
+    Path: Path{"streams", "0"},
+  }
+  if _, err := tree.Request(req); err != nil {
+    t.Fatal(err)
+  }
+  version := req.Version
+
+  _, err := tree.Request(&Request{
+    Method: http.MethodPost,
+    Body: (*json.RawMessage)(&body),
+    Path: Path{"streams", "0", "url"},
+    IfMatch: version,
+  })
+
+  if err != nil {
+    t.Errorf("expected matching version to succeed, got %v", err)
+  }
+
+  _, err = tree.Request(&Request{
+    Method: http.MethodPost,
+    Body: (*json.RawMessage)(&body),
+    Path: Path{"streams", "0", "url"},
+    IfMatch: version,
+  })
+
+  if _, ok := err.(*VersionError); !ok {
+    t.Errorf("expected version error for stale If-Match, got %v", err)
+  }
+
+  _, err = tree.Request(&Request{
+    Method: http.MethodPost,
+    Body: (*json.RawMessage)(&body),
+    Path: Path{"streams", "1", "url"},
+    IfMatch: version,
+  })
+
+  if err != nil {
+    t.Errorf("expected unchanged sibling to match, got %v", err)
+  }
+}
+
+func TestTreeHTTPETag(t *testing.T) {
+  tree := NewTree(newTestMirror())
+
+  w := httptest.NewRecorder()
+  tree.ServeHTTP(w, httptest.NewRequest("GET", "/streams/1", nil))
+
+  etag := w.Header().Get("ETag")
+  if w.Code != 200 || etag == "" {
+    t.Fatalf("expected 200 with an ETag, got %d '%s'", w.Code, etag)
+  }
+
+  w = httptest.NewRecorder()
+  r := httptest.NewRequest("POST", "/streams/1/name", strings.NewReader(`"garage"`))
+  r.Header.Set("If-Match", etag)
+  tree.ServeHTTP(w, r)
+
+  if w.Code != 200 {
+    t.Errorf("expected 200, got %d `%s`", w.Code, w.Body.String())
+  } else if w.Header().Get("ETag") == etag {
+    t.Errorf("expected ETag to change after a mutation")
+  }
+
+  w = httptest.NewRecorder()
+  r = httptest.NewRequest("POST", "/streams/1/name", strings.NewReader(`"shed"`))
+  r.Header.Set("If-Match", etag)
+  tree.ServeHTTP(w, r)
+
+  if w.Code != http.StatusPreconditionFailed {
+    t.Errorf("expected 412, got %d `%s`", w.Code, w.Body.String())
+  }
+
+  w = httptest.NewRecorder()
+  r = httptest.NewRequest("POST", "/streams/1/name", strings.NewReader(`"shed"`))
+  r.Header.Set("If-Match", "*")
+  tree.ServeHTTP(w, r)
+
+  if w.Code != 200 {
+    t.Errorf("expected wildcard If-Match to succeed, got %d `%s`", w.Code, w.Body.String())
+  }
+}
+
+func TestParseETag(t *testing.T) {
+  cases := map[string]uint64{
+    "": 0,
+    "*": 0,
+    `"12"`: 12,
+    `W/"7"`: 7,
+  }
+
+  for etag, expected := range cases {
+    if v, err := ParseETag(etag); err != nil {
+      t.Errorf("'%s': %v", etag, err)
+    } else if v != expected {
+      t.Errorf("'%s': expected %d, got %d", etag, expected, v)
+    }
+  }
+
+  if _, err := ParseETag(`"abc"`); err == nil {
+    t.Errorf("expected invalid ETag error, got none")
+  }
+}
+
+func TestTreeConcurrent(t *testing.T) {
+  tree := NewTree(&TestMapStruct{})
+  done := make(chan bool)
+
+  for i := 0; i < 8; i++ {
+    go func(i int) {
+      for j := 0; j < 50; j++ {
+        body := []byte(`{"integer": 1}`)
+        tree.Request(&Request{
+          Method: http.MethodPost,
+          Body: (*json.RawMessage)(&body),
+          Path: Path{"named", string('a' + rune(i))},
+        })
+        tree.Request(&Request{
+          Method: http.MethodGet,
+          Path: Path{"named"},
+        })
+        tree.View(func(data interface{}) error {
+          _, err := json.Marshal(data)
+          return err
+        })
+      }
+      done <- true
+    }(i)
+  }
+
+  for i := 0; i < 8; i++ {
+    <-done
+  }
+
+  tree.View(func(data interface{}) error {
+    if n := len(data.(*TestMapStruct).Named); n != 8 {
+      t.Errorf("expected 8 entries, got %d", n)
+    }
+    return nil
+  })
+}
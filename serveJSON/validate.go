@@ -0,0 +1,156 @@
+package serveJSON
+
+import (
+  "fmt"
+  "reflect"
+  "strconv"
+)
+
+/*
+Validator is implemented by values that check their own consistency.  After a
+mutation ServeJSON validates the changed value, everything beneath it and each
+of its ancestors, and rolls the change back if any of them fail.
+*/
+type Validator interface {
+  Validate() error
+}
+
+var validatorType = reflect.TypeOf((*Validator)(nil)).Elem()
+
+/*
+ValidationError reports the path of the value that failed validation along
+with the error it returned.
+*/
+type ValidationError struct {
+  Path Path
+  Err error
+}
+
+func (e *ValidationError) Error() string {
+  return fmt.Sprintf("validation failed at '%v': %v", e.Path, e.Err)
+}
+
+func (e *ValidationError) Unwrap() error {
+  return e.Err
+}
+
+func (e *ValidationError) ErrorCode() ErrorCode {
+  return CodeValidation
+}
+
+// validate checks the value at path beneath pv, its descendants and ancestors
+func validate(pv reflect.Value, path Path) error {
+  node, _, err := walk(path, pv, modeInternal)
+  if err != nil {
+    return err
+  }
+
+  if err := validateTree(node.Elem(), path); err != nil {
+    return err
+  }
+
+  for i := len(path) - 1; i >= 0; i-- {
+    ancestor, _, err := walk(path[:i], pv, modeInternal)
+    if err != nil {
+      return err
+    }
+    if err := validateValue(ancestor.Elem(), path[:i]); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+func validateValue(v reflect.Value, path Path) error {
+  if !v.IsValid() || !v.CanInterface() {
+    return nil
+  }
+
+  if !v.CanAddr() {
+    // pointer receivers need an addressable copy
+    c := reflect.New(v.Type())
+    c.Elem().Set(v)
+    v = c.Elem()
+  }
+
+  if k := v.Kind(); k == reflect.Slice || k == reflect.Array {
+    if err := checkKeys(v, path); err != nil {
+      return err
+    }
+  }
+
+  var validator Validator
+
+  if v.Addr().Type().Implements(validatorType) {
+    validator = v.Addr().Interface().(Validator)
+  } else if v.Type().Implements(validatorType) {
+    if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+      return nil
+    }
+    validator = v.Interface().(Validator)
+  } else {
+    return nil
+  }
+
+  if err := validator.Validate(); err != nil {
+    return &ValidationError{Path: append(Path{}, path...), Err: err}
+  }
+  return nil
+}
+
+// validateTree validates v and everything reachable from it, children first
+func validateTree(v reflect.Value, path Path) error {
+  if !v.IsValid() || !v.CanInterface() {
+    return nil
+  }
+
+  switch v.Kind() {
+  case reflect.Ptr, reflect.Interface:
+    if v.IsNil() {
+      return nil
+    }
+    return validateTree(v.Elem(), path)
+  case reflect.Struct:
+    if err := validateFields(v, path); err != nil {
+      return err
+    }
+  case reflect.Array, reflect.Slice:
+    for i := 0; i < v.Len(); i++ {
+      if err := validateTree(v.Index(i), append(path, strconv.Itoa(i))); err != nil {
+        return err
+      }
+    }
+  case reflect.Map:
+    for _, k := range v.MapKeys() {
+      if err := validateTree(v.MapIndex(k), append(path, fmt.Sprint(k.Interface()))); err != nil {
+        return err
+      }
+    }
+  }
+
+  return validateValue(v, path)
+}
+
+// validateFields validates the fields of the struct v beneath path
+func validateFields(v reflect.Value, path Path) error {
+  t := v.Type()
+  for i := 0; i < t.NumField(); i++ {
+    f := t.Field(i)
+    if f.PkgPath != "" && promoted(f) {
+      // fields promoted from unexported embedded structs are the parent's
+      if err := validateFields(v.Field(i), path); err != nil {
+        return err
+      }
+      continue
+    }
+
+    name, ok := fieldName(f)
+    if !ok {
+      continue
+    }
+    if err := validateTree(v.Field(i), append(path, name)); err != nil {
+      return err
+    }
+  }
+  return nil
+}
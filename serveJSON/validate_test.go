@@ -0,0 +1,229 @@
+package serveJSON
+
+import (
+  "testing"
+  "net/http"
+  "encoding/json"
+  "fmt"
+  "reflect"
+)
+
+type TestRange struct {
+  High int `json:"high"`
+  Low int `json:"low"`
+}
+
+func (r *TestRange) Validate() error {
+  if r.High < r.Low {
+    return fmt.Errorf("high %d is below low %d", r.High, r.Low)
+  }
+  return nil
+}
+
+type TestName string
+
+func (n TestName) Validate() error {
+  if n == "" {
+    return fmt.Errorf("name is empty")
+  }
+  return nil
+}
+
+type TestValidated struct {
+  Range TestRange `json:"range"`
+  Ranges map[string]TestRange `json:"ranges"`
+  Names []TestName `json:"names"`
+  Pointer *TestRange `json:"pointer"`
+}
+
+type TestCounter struct {
+  count chan *Request
+}
+
+func (c TestCounter) Notify(req *Request) error {
+  c.count <- req
+  return nil
+}
+
+func validatedPost(path Path, body string, face interface{}) error {
+  b := []byte(body)
+  _, err := ServeJSON(&Request{
+    Method: http.MethodPost,
+    Body: (*json.RawMessage)(&b),
+    Path: path,
+  }, face)
+  return err
+}
+
+func TestValidateRollback(t *testing.T) {
+  validated := &TestValidated{
+    Range: TestRange{High: 10, Low: 5},
+    Ranges: map[string]TestRange{"a": TestRange{High: 3, Low: 1}},
+    Names: []TestName{"one"},
+    Pointer: &TestRange{High: 2, Low: 1},
+  }
+  pointer := validated.Pointer
+
+  if err := validatedPost(Path{"range", "low"}, `7`, validated); err != nil {
+    t.Error(err)
+  }
+
+  err := validatedPost(Path{"range", "low"}, `11`, validated)
+  if _, ok := err.(*ValidationError); !ok {
+    t.Errorf("expected validation error from the parent, got %v", err)
+  } else if validated.Range.Low != 7 {
+    t.Errorf("expected change to be rolled back, got %d", validated.Range.Low)
+  }
+
+  err = validatedPost(Path{"ranges", "a", "low"}, `4`, validated)
+  if err == nil {
+    t.Errorf("expected validation error from the map value, got none")
+  } else if validated.Ranges["a"].Low != 1 {
+    t.Errorf("expected map value to be rolled back, got %v", validated.Ranges["a"])
+  }
+
+  err = validatedPost(Path{"ranges", "b"}, `{"high": 1, "low": 2}`, validated)
+  if err == nil {
+    t.Errorf("expected validation error for the new key, got none")
+  } else if _, ok := validated.Ranges["b"]; ok {
+    t.Errorf("expected new key to be rolled back, got %v", validated.Ranges)
+  }
+
+  err = validatedPost(Path{}, `{"pointer": {"low": 3}}`, validated)
+  if err == nil {
+    t.Errorf("expected validation error from a descendant, got none")
+  } else if validated.Pointer != pointer || pointer.Low != 1 {
+    t.Errorf("expected pointer to be restored in place, got %#v", validated.Pointer)
+  }
+
+  b := []byte(`""`)
+  _, err = ServeJSON(&Request{
+    Method: http.MethodPut,
+    Body: (*json.RawMessage)(&b),
+    Path: Path{"names"},
+  }, validated)
+
+  if err == nil {
+    t.Errorf("expected validation error from the new element, got none")
+  } else if len(validated.Names) != 1 {
+    t.Errorf("expected put to be rolled back, got %v", validated.Names)
+  }
+}
+
+func TestValidateRollbackScope(t *testing.T) {
+  validated := &TestValidated{
+    Range: TestRange{High: 10, Low: 5},
+    Ranges: map[string]TestRange{"a": TestRange{High: 3, Low: 1}},
+    Names: []TestName{"one"},
+  }
+  ranges, names := validated.Ranges, validated.Names
+
+  // only the range is copied and put back, leaving the rest of the tree alone
+  if err := validatedPost(Path{"range", "low"}, `11`, validated); err == nil {
+    t.Errorf("expected validation error, got none")
+  }
+  if reflect.ValueOf(validated.Ranges).Pointer() != reflect.ValueOf(ranges).Pointer() || &validated.Names[0] != &names[0] {
+    t.Errorf("expected the values beside the range left in place")
+  }
+
+  // a new key is taken away by putting back the map it was added to
+  if err := validatedPost(Path{"ranges", "b", "low"}, `2`, validated); err == nil {
+    t.Errorf("expected validation error for the new key, got none")
+  } else if _, ok := validated.Ranges["b"]; ok || len(validated.Ranges) != 1 {
+    t.Errorf("expected new key to be rolled back, got %v", validated.Ranges)
+  }
+}
+
+func TestValidateTreeNotify(t *testing.T) {
+  tree := NewTree(&TestValidated{Range: TestRange{High: 10, Low: 5}})
+  counter := TestCounter{make(chan *Request, 2)}
+  tree.Watch(counter)
+
+  b := []byte(`11`)
+  _, err := tree.Request(&Request{
+    Method: http.MethodPost,
+    Body: (*json.RawMessage)(&b),
+    Path: Path{"range", "low"},
+  })
+
+  if err == nil {
+    t.Errorf("expected validation error, got none")
+  }
+
+  b2 := []byte(`9`)
+  _, err = tree.Request(&Request{
+    Method: http.MethodPost,
+    Body: (*json.RawMessage)(&b2),
+    Path: Path{"range", "low"},
+  })
+
+  if err != nil {
+    t.Error(err)
+  }
+
+  if req := <-counter.count; req.Error != nil || string(*req.Response) != "9" {
+    t.Errorf("expected only the successful request to be broadcast, got %#v", req)
+  }
+}
+
+type testBounds struct {
+  High int `json:"high"`
+  Low int `json:"low"`
+  Names []TestName `json:"names"`
+}
+
+func (b testBounds) Validate() error {
+  if b.High < b.Low {
+    return fmt.Errorf("high %d is below low %d", b.High, b.Low)
+  }
+  return nil
+}
+
+type TestEmbedded struct {
+  testBounds
+  Label string `json:"label"`
+}
+
+func TestValidateEmbedded(t *testing.T) {
+  embedded := &TestEmbedded{testBounds{High: 10, Low: 5, Names: []TestName{"one"}}, "bounds"}
+
+  expected, _ := json.Marshal(embedded)
+  if output, err := ServeJSON(&Request{Method: http.MethodGet}, embedded); err != nil {
+    t.Error(err)
+  } else if string(*output) != string(expected) {
+    t.Errorf("expected `%s`, got `%s`", expected, *output)
+  }
+
+  // the unexported struct itself can't be reached, only the fields it promotes
+  for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodDelete} {
+    b := []byte(`{}`)
+    if _, err := ServeJSON(&Request{Method: method, Path: Path{"testBounds"}, Body: (*json.RawMessage)(&b)}, embedded); Code(err) != CodeNotFound {
+      t.Errorf("%s: expected %s, got %v", method, CodeNotFound, err)
+    }
+  }
+
+  if err := validatedPost(Path{"low"}, `3`, embedded); err != nil {
+    t.Error(err)
+  } else if embedded.Low != 3 {
+    t.Errorf("expected the promoted field set, got %d", embedded.Low)
+  }
+
+  if err := validatedPost(Path{"low"}, `11`, embedded); err == nil {
+    t.Errorf("expected validation error, got none")
+  } else if embedded.Low != 3 {
+    t.Errorf("expected change to be rolled back, got %d", embedded.Low)
+  }
+
+  if err := validatedPost(Path{}, `{"names": ["two", ""]}`, embedded); err == nil {
+    t.Errorf("expected validation error from a promoted element, got none")
+  } else if len(embedded.Names) != 1 || embedded.Names[0] != "one" {
+    t.Errorf("expected names to be rolled back, got %v", embedded.Names)
+  }
+
+  b := []byte(`{"high": 8, "label": "patched"}`)
+  if _, err := ServeJSON(&Request{Method: http.MethodPatch, Body: (*json.RawMessage)(&b)}, embedded); err != nil {
+    t.Error(err)
+  } else if embedded.High != 8 || embedded.Label != "patched" {
+    t.Errorf("expected the patch merged, got %#v", embedded)
+  }
+}
@@ -0,0 +1,99 @@
+package serveJSON
+
+import (
+  "fmt"
+  "strconv"
+  "strings"
+)
+
+/*
+VersionError is returned when a mutation expects a version of a node other
+than the current one, usually because another client changed it first.
+*/
+type VersionError struct {
+  Path Path
+  Expected uint64
+  Actual uint64
+}
+
+func (e *VersionError) Error() string {
+  return fmt.Sprintf("precondition failed, '%v' is at version %d, expected %d", e.Path, e.Actual, e.Expected)
+}
+
+func (e *VersionError) ErrorCode() ErrorCode {
+  return CodePreconditionFailed
+}
+
+// ETag formats a version as a strong HTTP entity tag
+func ETag(version uint64) string {
+  return fmt.Sprintf("\"%d\"", version)
+}
+
+/*
+ParseETag parses an If-Match header value into a version.  An empty header or
+"*" matches any version and parses as 0.
+*/
+func ParseETag(etag string) (uint64, error) {
+  etag = strings.TrimSpace(etag)
+  if etag == "" || etag == "*" {
+    return 0, nil
+  }
+  etag = strings.TrimPrefix(etag, "W/")
+  return strconv.ParseUint(strings.Trim(etag, "\""), 10, 64)
+}
+
+type modification struct {
+  path Path
+  version uint64
+}
+
+/*
+versions tracks the version at which each subtree last changed.  A node's
+version is the latest change to it, any of its ancestors or any of its
+descendants, so it can be compared without walking the tree.
+*/
+type versions struct {
+  current uint64
+  modified []modification
+}
+
+func hasPrefix(path, prefix Path) bool {
+  if len(prefix) > len(path) {
+    return false
+  }
+  for i, p := range prefix {
+    if path[i] != p {
+      return false
+    }
+  }
+  return true
+}
+
+func (v *versions) node(path Path) uint64 {
+  version := uint64(1)
+  for _, m := range v.modified {
+    if m.version > version && (hasPrefix(path, m.path) || hasPrefix(m.path, path)) {
+      version = m.version
+    }
+  }
+  return version
+}
+
+// record bumps the version and marks path as changed at it
+func (v *versions) record(path Path) uint64 {
+  if v.current == 0 {
+    v.current = 1
+  }
+  v.current++
+
+  // changes beneath path are superseded by this one
+  modified := v.modified[:0]
+  for _, m := range v.modified {
+    if !hasPrefix(m.path, path) {
+      modified = append(modified, m)
+    }
+  }
+  v.modified = append(modified, modification{path: append(Path{}, path...), version: v.current})
+
+  return v.current
+}
@@ -15,6 +15,7 @@ type Sockets struct {
   connections map[string]*websocket.Conn
   upgrader websocket.Upgrader
   lock sync.Locker
+  send sync.Mutex
   Incoming chan *server.Request
 }
 
@@ -93,7 +94,10 @@ func (s *Sockets) handleIncoming(name string, conn *websocket.Conn) {
     if err = json.Unmarshal(msg, req); err != nil {
       log.Printf("message error: %v", err)
 
-      if err = sendError(conn, err); err != nil {
+      locker(&s.send, func() {
+        err = sendError(conn, err)
+      })
+      if err != nil {
         log.Printf("error: %v", err)
         break
       }
@@ -105,6 +109,9 @@ func (s *Sockets) handleIncoming(name string, conn *websocket.Conn) {
   }
 }
 func (s *Sockets) Stop() {
+  s.send.Lock()
+  defer s.send.Unlock()
+
   s.lock.Lock()
   defer s.lock.Unlock()
 
@@ -130,6 +137,10 @@ func (s *Sockets) Notify(req *server.Request) error {
     return err
   }
 
+  // websocket connections support only one concurrent writer
+  s.send.Lock()
+  defer s.send.Unlock()
+
   toRemove := make(map[string]*websocket.Conn)
 
   if req.Error != nil {
@@ -146,7 +157,11 @@ func (s *Sockets) Notify(req *server.Request) error {
       }
     }
   } else if req.Method == http.MethodGet && req.Requestor != "" {
-    if conn := s.connections[req.Requestor]; conn == nil {
+    var conn *websocket.Conn
+    locker(s.lock, func() {
+      conn = s.connections[req.Requestor]
+    })
+    if conn == nil {
       log.Printf("connection not found: %s", req.Requestor)
     } else if err := sendMessage(conn, req); err != nil {
       toRemove[req.Requestor] = conn